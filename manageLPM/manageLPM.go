@@ -23,23 +23,117 @@ import (
 "strconv"
 "encoding/json"
 "strings"
+"math/big"
+"time"
+"sort"
+"crypto/sha256"
+"encoding/hex"
+"hash/fnv"
 
 "github.com/hyperledger/fabric/core/chaincode/shim"	
+pb "github.com/hyperledger/fabric/protos/peer"
+"github.com/hyperledger/fabric/protos/ledger/queryresult"
+"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 )
 
 // ManageLPM example simple Chaincode implementation
 type ManageLPM struct {
 }
 
+// Records live under composite keys, <objectType>~<id>, and are listed with partial key range queries
+var CustomerObjectType = "customer"					// customer~<customerId> holds a Customer
+var MerchantObjectType = "merchant"					// merchant~<merchantId> holds a Merchant
+var OwnerObjectType = "owner"						// owner~<ownerId> holds an Owner
+var TransactionObjectType = "txn"					// txn~<transactionId> holds a Transaction
+var CustomerTxnIndex = "txn~customer"				// txn~customer~<customerId>~<transactionDateTime>~<transactionId> lists a Customer's transactions by time
+var MerchantTxnIndex = "txn~merchant"				// txn~merchant~<merchantId>~<transactionDateTime>~<transactionId> lists a Merchant's transactions by time
+var MerchantCustomerIndex = "customer~merchant"		// customer~merchant~<merchantId>~<customerId> lists a Merchant's customers, a simple key so batches range read it, see getMemberKey
+var CampaignObjectType = "campaign"					// campaign~<campaignId> holds a Campaign
+var MerchantCampaignIndex = "campaign~merchant"		// campaign~merchant~<merchantId>~<campaignId> lists a Merchant's campaigns
+var CampaignCountersObjectType = "campaignCounters"	// campaignCounters~<campaignId> holds CampaignCounters, apart from the Campaign like MerchantCounters
+var CampaignCountersDeltaObjectType = "campaignCountersDelta"	// campaignCountersDelta~<day>~<campaignId>~<txId> holds what one transaction added to CampaignCounters
+var FxRateObjectType = "fxRate"						// fxRate~<baseCurrency>~<quoteCurrency>~<inverted effectiveDateTime> holds an FxRate, a simple key so the rate in effect is one range read, see getFxRateKey
+
+// Index arrays written by earlier chaincode versions, only read when migrating old data
 var CustomerIndexStr = "_Customerindex"				// name for the key/value that will store a list of all known Customer
 var TransactionIndexStr = "_Transactionindex"		// name for the key/value that will store a list of all known Transaction
 var MerchantIndexStr = "_Merchantindex"				//name for the key/value that will store a list of all known Merchant
 var OwnerIndexStr = "_Ownerindex"				//name for the key/value that will store a list of all known Owner
+var MigrationIndexes = []string{OwnerIndexStr, MerchantIndexStr, CustomerIndexStr, TransactionIndexStr}	// walked in this order, customers resolve merchants and transactions resolve customers
+
+// Schema version of the ledger, bumped whenever stored records change shape
+var SchemaVersion = 2								// records under composite keys with fixed point amounts
+var LegacySchemaVersion = 1							// records under plain keys listed in the index arrays
+var SchemaVersionKey = "_schemaVersion"				// name for the key/value that stores the schema version
+var MigrationProgressKey = "_migrationProgress"		// name for the key/value that stores where migrate stopped
+var ExchangeConfigKey = "_exchangeConfig"			// name for the key/value that stores the ExchangeConfig of the network
+var MigrationReportObjectType = "migrationReport"	// migrationReport~<batch> holds the report of one migrate batch
+var MigrationBatchSize = 100						// records migrated per invocation when no batchSize is given
+var MaxMigrationBatchSize = 1000					// upper bound on batchSize, keeps one invocation within the proposal timeout
+var MigrationIndexChunkObjectType = "migrationIndexChunk"	// migrationIndexChunk~<index>~<chunk> holds one chunk of a legacy index array
+var MigrationIndexChunkSize = 1000					// entries per chunk, a batch reads the chunks it walks instead of the whole array
+var MaxMigrationAttempts = 3						// a record failing this often moves to FailedRecords and is no longer retried
+var LegacyDateTimeLayouts = []string{				// formats transactionDateTime was seen in before it came from the proposal, without a zone read as UTC
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+	"01/02/2006",
+	"1/2/2006, 3:04:05 PM",
+	"Mon Jan 02 2006 15:04:05 GMT-0700",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+	time.ANSIC,
+}
+
+// Paging of transaction history queries
+var HistoryPageSize = 50							// transactions per page when no pageSize is given
+var MaxHistoryPageSize = 500						// upper bound on pageSize
 
 type Customer struct{							// Attributes of a Customer 
 	CustomerID string `json:"customerId"`					
 	UserName string `json:"userName"`
 	CustomerName string `json:"customerName"`
+	WalletWorth Money `json:"walletWorth"`
+	Holdings []MerchantHolding `json:"holdings"`
+	Status string `json:"status,omitempty"`						// active, closed or deactivated, empty on records from before statuses
+	StatusReason string `json:"statusReason,omitempty"`
+	StatusDateTime string `json:"statusDateTime,omitempty"`		// proposal timestamp of the last status change
+}
+
+type MerchantHolding struct{					// Points a Customer holds with one Merchant
+	MerchantID string `json:"merchantId"`
+	MerchantName string `json:"merchantName"`
+	MerchantColor string `json:"merchantColor"`
+	Currency string `json:"currency"`
+	Points Points `json:"points"`
+	Worth Money `json:"worth"`
+	JoinedDate string `json:"joinedDate"`
+	Lots []PointsLot `json:"lots,omitempty"`					// Points by the date they were earned, oldest first, redemptions consume the oldest
+	LastActivityDate string `json:"lastActivityDate,omitempty"`	// proposal timestamp the points last went up or down other than by expiry
+	Tier string `json:"tier,omitempty"`							// the Merchant's tier as of the last accumulation or expirePoints batch that reviewed it
+	Earnings []EarningPeriod `json:"earnings,omitempty"`		// accumulations by day within the Merchant's tier window, oldest first
+	Accruals int64 `json:"accruals,omitempty"`					// accumulations credited since campaigns, a first purchase campaign applies while it is 0
+}
+
+type EarningPeriod struct{						// What a customer earned with one Merchant in one UTC day
+	Period string `json:"period"`								// 2006-01-02, a month 2006-01 written before daily periods counts as its first day
+	Points Points `json:"points"`								// before any tier multiplier
+	Spend Money `json:"spend"`								// amount spent, in the Merchant's currency
+}
+
+type PointsLot struct{							// Points of a holding earned at the same time
+	EarnedDate string `json:"earnedDate"`
+	Points Points `json:"points"`
+}
+
+type LegacyCustomer struct{						// Customer as stored before holdings, merchant columns are comma separated
+	CustomerID string `json:"customerId"`
+	UserName string `json:"userName"`
+	CustomerName string `json:"customerName"`
 	WalletWorth string `json:"walletWorth"`
 	MerchantIDs string `json:"merchantIDs"`
 	MerchantNames string `json:"merchantNames"`
@@ -47,12 +141,27 @@ type Customer struct{							// Attributes of a Customer
 	MerchantCurrencies string `json:"merchantCurrencies"`
 	MerchantsPointsCount string `json:"merchantsPointsCount"`
 	MerchantsPointsWorth string `json:"merchantsPointsWorth"`
+	Holdings []MerchantHolding `json:"holdings"`				// set instead of the columns on Customers stored as holdings before composite keys
 }
 
-type Transaction struct{							// Attributes of a Transaction 
-	TransactionID string `json:"transactionId"`					
+type LegacyMerchant struct{						// Merchant as stored before fixed point, amounts are decimal strings
+	MerchantID string `json:"merchantId"`
+	MerchantUserName string `json:"merchantUserName"`
+	MerchantName string `json:"merchantName"`
+	MerchantIndustry string `json:"merchantIndustry"`
+	IndustryColor string `json:"industryColor"`
+	PointsPerDollarSpent string `json:"pointsPerDollarSpent"`
+	ExchangeRate string `json:"exchangeRate"`
+	PurchaseBalance string `json:"purchaseBalance"`
+	MerchantCurrency string `json:"merchantCurrency"`
+	MerchantCU_date string `json:"merchantCU_date"`
+	MerchantInitialBalance string `json:"merchantInitialBalance"`		// only written by manageLPMOrig
+}
+
+type LegacyTransaction struct{					// Transaction as stored before fixed point, points are decimal strings
+	TransactionID string `json:"transactionId"`
 	TransactionDateTime string `json:"transactionDateTime"`
-	TransactionType string `json:"transactionType"`				// Values are Purchase, Transfer, Accumulation (Add Points), CustomerOnBoarding
+	TransactionType string `json:"transactionType"`
 	TransactionFrom string `json:"transactionFrom"`
 	TransactionTo string `json:"transactionTo"`
 	Credit string `json:"credit"`
@@ -60,17 +169,289 @@ type Transaction struct{							// Attributes of a Transaction
 	CustomerID string `json:"customerId"`
 }
 
+type Transaction struct{							// Attributes of a Transaction 
+	TransactionID string `json:"transactionId"`					// <Fabric txid>-<leg>, legs count from 0 within one invocation
+	TransactionDateTime string `json:"transactionDateTime"`		// proposal timestamp, RFC 3339 in UTC
+	TransactionType string `json:"transactionType"`				// Values are Purchase, Transfer, Accumulation (Add Points), CustomerOnBoarding
+	TransactionFrom string `json:"transactionFrom"`
+	TransactionTo string `json:"transactionTo"`
+	Credit Points `json:"credit"`
+	Debit Points `json:"debit"`
+	CustomerID string `json:"customerId"`
+	MerchantID string `json:"merchantId,omitempty"`				// set when the transaction belongs to one Merchant's program
+	ExternalReference string `json:"externalReference,omitempty"`	// the id the client sent, if any
+	CampaignID string `json:"campaignId,omitempty"`				// set on the bonus a Campaign credited
+	LinkedTransactionID string `json:"linkedTransactionId,omitempty"`	// the accumulation a bonus was credited for, the debit leg of an exchange
+	Amount *Money `json:"amount,omitempty"`					// money an accumulation or purchase was for, or the value of an exchange leg, the legs of one exchange net to zero
+}
+
+type TransactionFilter struct{					// Which transactions a history query returns, empty fields match everything
+	From string									// earliest transactionDateTime, RFC 3339
+	To string									// latest transactionDateTime, RFC 3339
+	TransactionType string
+	MerchantID string
+	HoldingsOnly bool							// only legs that moved a customer's points, see movesHolding
+}
+
+type TransactionPage struct{					// One page of a history query, oldest first
+	Transactions []Transaction `json:"transactions"`
+	Bookmark string `json:"bookmark"`				// pass back to read the next page, empty on the last page
+}
+
+type MerchantStatement struct{					// A Merchant's transactions for a date range, balances are the points its customers hold
+	MerchantID string `json:"merchantId"`
+	From string `json:"from,omitempty"`
+	To string `json:"to,omitempty"`
+	OpeningBalance Points `json:"openingBalance"`	// balance of every transaction before From, or before this page
+	Entries []StatementEntry `json:"entries"`		// oldest first
+	ClosingBalance Points `json:"closingBalance"`
+	Totals map[string]StatementTotal `json:"totals"`	// by transactionType, of every entry from From to To whichever page this is
+	Bookmark string `json:"bookmark"`				// pass back for the next page, empty on the last one, it only says where the page starts
+}
+
+type StatementEntry struct{						// One credit or debit on a MerchantStatement
+	TransactionID string `json:"transactionId"`
+	TransactionDateTime string `json:"transactionDateTime"`
+	TransactionType string `json:"transactionType"`
+	Counterparty string `json:"counterparty"`			// customerId the points were credited or debited for
+	Credit Points `json:"credit"`
+	Debit Points `json:"debit"`
+	Balance Points `json:"balance"`					// running balance after this entry
+}
+
+type StatementTotal struct{						// Sums of one transactionType on a MerchantStatement
+	Count int `json:"count"`
+	Credit Points `json:"credit"`
+	Debit Points `json:"debit"`
+}
+
 type Merchant struct{							// Attributes of a Merchant
 	MerchantID string `json:"merchantId"`					
 	MerchantUserName string `json:"merchantUserName"`
 	MerchantName string `json:"merchantName"`
 	MerchantIndustry string `json:"merchantIndustry"`
 	IndustryColor string `json:"industryColor"`					
-	PointsPerDollarSpent string `json:"pointsPerDollarSpent"`
-	ExchangeRate string `json:"exchangeRate"`
-	PurchaseBalance string `json:"purchaseBalance"`
+	PointsPerDollarSpent Rate `json:"pointsPerDollarSpent"`
+	ExchangeRate Rate `json:"exchangeRate"`
+	PurchaseBalance Money `json:"purchaseBalance"`
 	MerchantCurrency string `json:"merchantCurrency"`
 	MerchantCU_date string `json:"merchantCU_date"`
+	Status string `json:"status,omitempty"`						// active, closed or deactivated, empty on records from before statuses
+	StatusReason string `json:"statusReason,omitempty"`
+	StatusDateTime string `json:"statusDateTime,omitempty"`		// proposal timestamp of the last status change
+	DeactivationPolicy string `json:"deactivationPolicy,omitempty"`	// how deleteMerchant settles its members' points, cashout or expire
+	ExpiryPolicy string `json:"expiryPolicy,omitempty"`			// when its points expire, empty when they never do
+	ExpiryMonths int `json:"expiryMonths,omitempty"`				// months of the months and inactivity policies
+	TierBasis string `json:"tierBasis,omitempty"`				// what tiers qualify on, points or spend
+	TierWindowMonths int `json:"tierWindowMonths,omitempty"`	// months back from the proposal timestamp tiers qualify over, a rolling window
+	Tiers []Tier `json:"tiers,omitempty"`						// lowest threshold first
+	ExchangeOptIn bool `json:"exchangeOptIn,omitempty"`		// its points can be exchanged to and from other merchants' points
+	ExchangeDailyCap int64 `json:"exchangeDailyCap,omitempty"`	// minor units of merchantCurrency it may exchange out, and again in, per UTC day, 0 for no cap
+}
+
+type Tier struct{								// A membership tier of a Merchant
+	Name string `json:"name"`
+	Threshold int64 `json:"threshold"`						// hundredths of a point or minor units of merchantCurrency earned within the window, per tierBasis
+	Multiplier Rate `json:"multiplier"`						// points credited by an accumulation are multiplied by this, in millionths
+}
+
+type CustomerTier struct{						// Tier report of a customer with one Merchant
+	CustomerID string `json:"customerId"`
+	MerchantID string `json:"merchantId"`
+	Tier string `json:"tier"`								// qualified for at the proposal timestamp, empty below every tier
+	Multiplier Rate `json:"multiplier"`
+	TierBasis string `json:"tierBasis"`
+	TierWindowMonths int `json:"tierWindowMonths"`
+	Points Points `json:"points"`							// earned within the window, before any tier multiplier
+	Spend Money `json:"spend"`								// spent within the window
+	NextTier string `json:"nextTier,omitempty"`
+	NextThreshold int64 `json:"nextThreshold,omitempty"`
+}
+
+// Tier bases of a Merchant and the transaction recording a tier change
+var TierBasisPoints = "points"
+var TierBasisSpend = "spend"
+var MaxTierWindowMonths = 120
+var TierChangeTransactionType = "TierChange"		// transactionType recording a customer moving between tiers, from and to are tier names, it moves no points
+
+type Campaign struct{							// A time boxed bonus a Merchant credits on accumulations that qualify
+	CampaignID string `json:"campaignId"`
+	MerchantID string `json:"merchantId"`
+	CampaignName string `json:"campaignName"`
+	StartDateTime string `json:"startDateTime"`				// RFC 3339 in UTC, the campaign runs from start to end inclusive
+	EndDateTime string `json:"endDateTime"`
+	MinSpend Money `json:"minSpend"`							// an accumulation qualifies when this much or more was spent
+	MinTier string `json:"minTier,omitempty"`					// the customer must be in this tier of the Merchant or a higher one
+	FirstPurchase bool `json:"firstPurchase,omitempty"`		// only the customer's first accumulation with the Merchant qualifies
+	BonusType string `json:"bonusType"`						// multiplier or flat
+	Multiplier Rate `json:"multiplier,omitempty"`				// points credited are multiplied by this, in millionths, the bonus is what it adds
+	FlatBonus Points `json:"flatBonus,omitempty"`				// points added to a qualifying accumulation
+}
+
+type CampaignCounters struct{					// What a Campaign has cost, kept up to date as bonuses are written, see rebuildCounters
+	CampaignID string `json:"campaignId"`
+	Bonuses int64 `json:"bonuses"`
+	BonusPoints Points `json:"bonusPoints"`
+}
+
+type CampaignReport struct{						// A Campaign with what it has cost so far
+	Campaign Campaign `json:"campaign"`
+	Bonuses int64 `json:"bonuses"`
+	BonusPoints Points `json:"bonusPoints"`
+	BonusWorth Money `json:"bonusWorth"`						// bonusPoints at the Merchant's exchangeRate
+}
+
+type ExchangeConfig struct{						// Settings of exchangePoints the owner makes for the network
+	FeeRate Rate `json:"feeRate"`							// share of the value exchanged kept as the network fee, in millionths
+	UpdatedDateTime string `json:"updatedDateTime"`
+}
+
+type ExchangeVolume struct{						// Value a Merchant exchanged in one UTC day in one shard, each shard holds up to its share of the exchangeDailyCap
+	MerchantID string `json:"merchantId"`
+	Day string `json:"day"`									// 2006-01-02
+	Shard int `json:"shard"`
+	Out Money `json:"out"`									// value of its points exchanged to other merchants
+	In Money `json:"in"`									// value of other merchants' points exchanged to it
+}
+
+var ExchangeVolumeObjectType = "exchangeVolume"	// exchangeVolume~<merchantId>~<day>~<shard> holds an ExchangeVolume
+var ExchangeVolumeShards = 4						// shards of a Merchant's daily volume, an exchange only reads the others when its own has no room left
+var ExchangeTransactionType = "Exchange"			// transactionType of the debit and credit legs of an exchange, the debit redeems and the credit issues
+var ExchangeFeeTransactionType = "ExchangeFee"	// transactionType of the network fee leg of an exchange, its amount is the fee
+var NetworkName = "network"						// transactionTo of a network fee
+var NetworkAccountObjectType = "networkAccount"	// networkAccount~<currency>~<shard> holds the NetworkAccount of the exchanges hashed to that shard
+
+type NetworkAccount struct{						// Exchange fees the network was paid in one currency, kept in NetworkCounterShards shards like NetworkCounters
+	Currency string `json:"currency"`
+	Fees Money `json:"fees"`
+	Exchanges int64 `json:"exchanges"`						// fee legs booked
+}
+
+type FxRate struct{								// Value of one unit of a currency in another from a point in time on, set by the owner
+	BaseCurrency string `json:"baseCurrency"`				// ISO 4217
+	QuoteCurrency string `json:"quoteCurrency"`				// ISO 4217
+	Rate Rate `json:"rate"`									// quoteCurrency per baseCurrency, in millionths
+	EffectiveDateTime string `json:"effectiveDateTime"`		// RFC 3339 in UTC, the rate is in effect until a later one for the pair
+}
+
+type WalletWorth struct{							// Worth of a customer's holdings in one currency at the rates in effect at asOf
+	CustomerID string `json:"customerId"`
+	Currency string `json:"currency"`
+	AsOf string `json:"asOf"`
+	WalletWorth Money `json:"walletWorth"`
+	Holdings []HoldingWorth `json:"holdings"`
+}
+
+type HoldingWorth struct{						// Worth of one holding in its own currency and converted
+	MerchantID string `json:"merchantId"`
+	Worth Money `json:"worth"`
+	Converted Money `json:"converted"`
+	FxRate Rate `json:"fxRate"`								// the rate worth was converted at, in millionths
+}
+
+// Bonus types of a Campaign and the transaction crediting a bonus
+var CampaignBonusMultiplier = "multiplier"
+var CampaignBonusFlat = "flat"
+var CampaignBonusTransactionType = "CampaignBonus"	// transactionType whose credit is a Campaign's bonus, linked to the accumulation it was credited for
+
+// Expiry policies of a Merchant, expirePoints forfeits the points of a lot once its policy says so
+var ExpiryPolicyNone = "none"						// points never expire
+var ExpiryPolicyMonths = "months"					// a lot expires expiryMonths after it was earned
+var ExpiryPolicyYearEnd = "yearEnd"				// a lot expires at the end of the calendar year it was earned in, UTC
+var ExpiryPolicyInactivity = "inactivity"			// every lot expires expiryMonths after the holding's last activity
+var MaxExpiryMonths = 1200
+var ExpiryBatchSize = 100							// customers expirePoints looks at per invocation when no batchSize is given
+var MaxExpiryBatchSize = 1000						// upper bound on batchSize, keeps one invocation within the proposal timeout
+
+type DeactivationReport struct{					// Outcome of one deleteMerchant batch
+	MerchantID string `json:"merchantId"`
+	Customers int `json:"customers"`						// members looked at
+	Transactions int `json:"transactions"`				// CashOut or Expiry transactions written
+	Settled Points `json:"settled"`						// points cashed out or expired
+	Bookmark string `json:"bookmark"`						// customerId to pass to the next invocation, empty once every member was settled
+	Message string `json:"message"`
+	Code string `json:"code"`
+}
+
+type ExpiryReport struct{						// Outcome of one expirePoints batch
+	MerchantID string `json:"merchantId"`
+	AsOf string `json:"asOf"`								// proposal timestamp the lots were expired at
+	Customers int `json:"customers"`						// customers looked at
+	Transactions int `json:"transactions"`				// Expiry transactions written
+	TierChanges int `json:"tierChanges"`					// TierChange transactions written for customers whose earnings left the tier window
+	Expired Points `json:"expired"`
+	Bookmark string `json:"bookmark"`						// customerId to pass to the next invocation, empty once every customer was looked at
+	Message string `json:"message"`
+	Code string `json:"code"`
+}
+
+var MerchantCountersObjectType = "merchantCounters"	// merchantCounters~<merchantId> holds MerchantCounters, apart from the Merchant so counting does not contend with its updates
+var MerchantCountersDeltaObjectType = "merchantCountersDelta"	// merchantCountersDelta~<day>~<merchantId>~<txId> holds what one transaction added to MerchantCounters on that UTC day, added in by queries until folded
+var NetworkCountersObjectType = "networkCounters"	// networkCounters~<shard> holds the NetworkCounters added by the transactions hashed to that shard
+var NetworkCounterShards = 16						// shards the network totals are spread over so concurrent transactions rarely update the same one, changed only with rebuildCounters
+var RebuildBatchSize = 100							// records rebuildCounters looks at per invocation when no batchSize is given
+var MaxRebuildBatchSize = 1000						// upper bound on batchSize, keeps one invocation within the proposal timeout
+var RebuildPhases = []string{"counters", "merchants", "customers", "transactions"}	// walked in this order, the stored counters are dropped first
+var FoldBatchSize = 100								// counter deltas foldCounters folds per invocation when no batchSize is given
+var MaxFoldBatchSize = 1000							// upper bound on batchSize, keeps one invocation within the proposal timeout
+var CounterFoldLag = time.Hour						// foldCounters folds a day's deltas once the day ended this long before it, no transaction writes to it anymore
+var OnboardingTransactionType = "CustomerOnBoarding"	// transactionType of the opening balance a customer joins a merchant with
+var AccumulationTransactionType = "Accumulation"		// transactionType whose credit issues points for an amount spent
+var PurchaseTransactionType = "Purchase"				// transactionType whose debit redeems points
+var TransferTransactionType = "Transfer"				// transactionType that moves points between customers
+var CashOutTransactionType = "CashOut"					// transactionType whose debit pays out points when a customer or merchant goes
+var ExpiryTransactionType = "Expiry"					// transactionType whose debit forfeits points
+var OffboardingTransactionType = "Offboarding"		// transactionType recording a customer leaving a merchant, it moves no points
+
+// Statuses of Customers and Merchants, deleteCustomer and deleteMerchant change the status and keep the record
+var StatusActive = "active"
+var StatusClosed = "closed"							// a Customer closed by deleteCustomer
+var StatusDeactivated = "deactivated"				// a Merchant deactivated by deleteMerchant
+var DeactivationCashOut = "cashout"					// deleteMerchant policy, members' points are paid out
+var DeactivationExpire = "expire"					// deleteMerchant policy, members' points are forfeited, disassociateCustomer takes the same policies
+var DeactivationBatchSize = 100						// members deleteMerchant settles per invocation when no batchSize is given
+var MaxDeactivationBatchSize = 1000					// upper bound on batchSize, keeps one invocation within the proposal timeout
+
+type MerchantCounters struct{						// Counts kept up to date as customers and transactions are written, see rebuildCounters
+	MerchantID string `json:"merchantId"`
+	Inactive bool `json:"inactive,omitempty"`				// the Merchant was deactivated or deleted, its customers still count
+	Customers int64 `json:"customers"`						// customers whose first holding is with this merchant, so each is counted once in the network
+	ActiveMembers int64 `json:"activeMembers"`				// customers holding points with this merchant
+	PointsIssued Points `json:"pointsIssued"`
+	PointsRedeemed Points `json:"pointsRedeemed"`
+	PointsLiability Points `json:"pointsLiability"`			// points its customers hold
+	StatementBalance Points `json:"statementBalance"`		// credits less debits of every leg on its statement, what the statement closes with today
+}
+
+type RebuildReport struct{						// Outcome of one rebuildCounters batch
+	Records int `json:"records"`						// records looked at
+	Bookmark string `json:"bookmark"`						// to pass to the next invocation, empty once every record was looked at
+	Message string `json:"message"`
+	Code string `json:"code"`
+}
+
+type FoldReport struct{							// Outcome of one foldCounters batch
+	Folded int `json:"folded"`							// counter deltas folded into their counters
+	Done bool `json:"done"`								// no deltas of a day that ended CounterFoldLag ago were left once the batch was folded
+	Message string `json:"message"`
+	Code string `json:"code"`
+}
+
+type NetworkCounters struct{						// Totals of every MerchantCounters, for the owner, kept in NetworkCounterShards shards as they are counted
+	MerchantCount int64 `json:"merchantCount"`
+	UserCount int64 `json:"userCount"`
+	MemberCount int64 `json:"memberCount"`					// memberships, a customer of two merchants counts twice
+	PointsIssued Points `json:"pointsIssued"`
+	PointsRedeemed Points `json:"pointsRedeemed"`
+	PointsLiability Points `json:"pointsLiability"`
+}
+
+type MerchantBalance struct{						// Account balance report of a Merchant
+	MerchantID string `json:"merchantId"`
+	PointsLiability Points `json:"pointsLiability"`			// points outstanding across all its customers
+	LiabilityWorth Money `json:"liabilityWorth"`				// pointsLiability at exchangeRate
+	ExchangeRate Rate `json:"exchangeRate"`
+	PurchaseBalance Money `json:"purchaseBalance"`			// redeemed by purchases
 }
 
 type Owner struct{							// Attributes of a Owner
@@ -79,6 +460,190 @@ type Owner struct{							// Attributes of a Owner
 	OwnerName string `json:"ownerName"`
 }
 
+type MigrationProgress struct{				// Where migrate stopped, Phase indexes MigrationIndexes
+	Phase int `json:"phase"`
+	Position int `json:"position"`
+	Batch int `json:"batch"`
+	Migrated int `json:"migrated"`
+	Split bool `json:"split"`							// the index of Phase is stored in chunks
+	Length int `json:"length"`							// entries in the index of Phase, once split
+	Failed int `json:"failed"`							// records still in Pending
+	Pending []MigrationFailure `json:"pending,omitempty"`	// records that failed, retried once every index is walked
+	FailedRecords []MigrationFailure `json:"failedRecords,omitempty"`	// records skipped past, left under their plain keys for an operator to repair
+	Done bool `json:"done"`								// every index walked and nothing pending
+}
+
+type MigrationFailure struct{				// A legacy record migrate could not read or convert
+	Index string `json:"index"`
+	Key string `json:"key"`
+	Reason string `json:"reason"`
+	Attempts int `json:"attempts"`
+}
+
+type MigrationReport struct{				// Outcome of one migrate batch
+	Batch int `json:"batch"`
+	Records int `json:"records"`
+	Migrated int `json:"migrated"`
+	Skipped int `json:"skipped"`
+	Failures []MigrationFailure `json:"failures"`
+	Progress MigrationProgress `json:"progress"`
+	Message string `json:"message"`
+	Code string `json:"code"`
+}
+
+// Codes of a ChaincodeError, the same for every invoke and query
+var ErrNotFound = "NOT_FOUND"						// the customer, merchant, owner or transaction does not exist
+var ErrAlreadyExists = "ALREADY_EXISTS"				// a record with this id exists, or the association is already made
+var ErrInvalidArgument = "INVALID_ARGUMENT"			// wrong number of arguments, or a value that does not parse
+var ErrInsufficientPoints = "INSUFFICIENT_POINTS"	// a debit or redemption exceeds the points held
+var ErrFailedPrecondition = "FAILED_PRECONDITION"	// the ledger is not in a state that allows the request
+var ErrPermissionDenied = "PERMISSION_DENIED"		// the caller's bound role does not allow the request
+var ErrInternal = "INTERNAL"						// reading or writing state failed, or a stored record is malformed
+var ErrLimitExceeded = "LIMIT_EXCEEDED"				// the request would take a Merchant over one of its limits
+var ErrDeprecated = "DEPRECATED"					// a pre-1.0 signature the ledger no longer accepts, the message names its replacement
+
+type ChaincodeError struct{					// Returned by Invoke when a request is rejected
+	Code string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Roles an identity can be bound to, managed by the owner through bindRole and unbindRole
+var RoleOwner = "owner"								// runs the network, creates and deletes merchants
+var RoleMerchant = "merchant"						// manages its own merchant and issues points to its customers
+var RoleCustomer = "customer"						// spends its own points
+var RoleBindingObjectType = "roleBinding"			// roleBinding~<identity> holds a RoleBinding
+var EnrollmentIDAttribute = "hf.EnrollmentID"		// certificate attribute Fabric CA sets to the enrollment id
+
+type RoleBinding struct{						// The role an identity, <mspId>/<enrollmentId>, may act as
+	Identity string `json:"identity"`
+	Role string `json:"role"`
+	EntityID string `json:"entityId"`				// ownerId, merchantId or customerId the identity acts for
+}
+
+var ProcessedRequestObjectType = "request"		// request~<caller>~<externalReference> holds the ProcessedRequest the caller made with the reference
+
+type ProcessedRequest struct{					// A request that wrote transactions, kept so a retry with the same externalReference is answered, not reapplied
+	ExternalReference string `json:"externalReference"`
+	Caller string `json:"caller"`					// identity that made the request, references are only unique per caller
+	Function string `json:"function"`
+	Fingerprint string `json:"fingerprint"`		// sha256 of the function and its arguments
+	Result []byte `json:"result,omitempty"`		// payload returned the first time
+	Event *Event `json:"event,omitempty"`			// evtsender event sent the first time
+}
+
+type writeBatch struct{						// Writes of one invocation, staged so they reach the ledger together or not at all
+	shim.ChaincodeStubInterface
+	keys []string								// keys in the order they were first written
+	values map[string][]byte
+	deleted map[string]bool
+	events []stagedEvent
+}
+
+type stagedEvent struct{
+	name string
+	payload []byte
+}
+
+type stagedIterator struct{					// Results of a range read with the writes staged in its range merged in, by key
+	results []*queryresult.KV
+	next int
+}
+
+type Event struct{							// Payload of evtsender
+	CustomerID string `json:"customerID,omitempty"`
+	MerchantID string `json:"merchantID,omitempty"`
+	OwnerID string `json:"ownerID,omitempty"`
+	Message string `json:"message"`
+	Code string `json:"code"`
+}
+
+// Amounts on the ledger are fixed point integers, no balance arithmetic goes through float64
+var MoneyScale = 2							// Money is stored in minor units, cents in a currency not in CurrencyMinorUnits
+var PointsScale = 2							// Points are stored in hundredths of a point
+var RateScale = 6							// Rates are stored in millionths
+var DefaultCurrency = "USD"					// currency used when a record or argument does not carry one
+var CurrencyMinorUnits = map[string]int{	// ISO 4217 minor units of the currencies that have other than MoneyScale
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0,
+	"UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+type Money struct{							// An amount in minor units of a currency
+	Units int64 `json:"units"`
+	Currency string `json:"currency"`
+}
+
+type Points int64							// Points in hundredths of a point
+
+type Rate int64								// pointsPerDollarSpent or exchangeRate (dollars per point) in millionths
+
+// Every function callable through Invoke, by the name clients already use
+type chaincodeFunction func(t *ManageLPM, stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+var invokeFunctions = map[string]chaincodeFunction{		// functions that write state
+	"init": (*ManageLPM).initLedger,										//initialize the chaincode state, used as reset
+	"createCustomer": (*ManageLPM).createCustomer,							//create a new Customer
+	"updateCustomerAccumulation": (*ManageLPM).updateCustomerAccumulation,	//pre-1.0 signature, rejected as deprecated
+	"updateCustomerPurchase": (*ManageLPM).updateCustomerPurchase,			//pre-1.0 signature, rejected as deprecated
+	"updateCustomerTransfer": (*ManageLPM).updateCustomerTransfer,			//pre-1.0 signature, rejected as deprecated
+	"updateCustomerAccumulationSC": (*ManageLPM).updateCustomerAccumulationSC,	//update a Customer, points computed by the ledger
+	"updateCustomerPurchaseSC": (*ManageLPM).updateCustomerPurchaseSC,		//update a Customer, points computed by the ledger
+	"updateCustomerTransferSC": (*ManageLPM).updateCustomerTransferSC,		//update a Customer, points computed by the ledger
+	"deleteCustomer": (*ManageLPM).deleteCustomer,							// close a Customer
+	"createMerchant": (*ManageLPM).createMerchant,							//create a new Merchant
+	"updateMerchant": (*ManageLPM).updateMerchant,							//update a Merchant
+	"deleteMerchant": (*ManageLPM).deleteMerchant,							// deactivate a Merchant
+	"createOwner": (*ManageLPM).createOwner,								// create a owner
+	"updateMerchantsPPDS": (*ManageLPM).updateMerchantsPPDS,				//update a Merchant's PPDS
+	"updateMerchantsPurchaseBal": (*ManageLPM).updateMerchantsPurchaseBal,	//credit a Merchant's purchase balance
+	"associateCustomer": (*ManageLPM).associateCustomer,					// associate a customer to Merchant
+	"disassociateCustomer": (*ManageLPM).disassociateCustomer,				// remove a customer from a Merchant
+	"updateMerchantsExchangeRate": (*ManageLPM).updateMerchantsExchangeRate,	// update a Merchant's Exchange Rate
+	"updateMerchantsExpiryPolicy": (*ManageLPM).updateMerchantsExpiryPolicy,	// update when a Merchant's points expire
+	"expirePoints": (*ManageLPM).expirePoints,								// expire a Merchant's points that are due, run by a scheduler
+	"updateMerchantsTiers": (*ManageLPM).updateMerchantsTiers,				// update a Merchant's membership tiers
+	"createCampaign": (*ManageLPM).createCampaign,							// create a Merchant's Campaign
+	"endCampaign": (*ManageLPM).endCampaign,								// end a Campaign before its endDateTime
+	"updateExchangeFee": (*ManageLPM).updateExchangeFee,					// update the network fee of exchangePoints
+	"updateMerchantsExchangeSettings": (*ManageLPM).updateMerchantsExchangeSettings,	// update a Merchant's opt-in and daily cap for exchangePoints
+	"exchangePoints": (*ManageLPM).exchangePoints,							// exchange a Customer's points with one Merchant to another
+	"setFxRate": (*ManageLPM).setFxRate,									// add an FX rate to the table from its effective date on
+	"migrate": (*ManageLPM).migrate,										// upgrade records listed in the legacy index arrays
+	"bindRole": (*ManageLPM).bindRole,										// bind an identity to a role
+	"unbindRole": (*ManageLPM).unbindRole,									// remove an identity's role
+	"rebuildCounters": (*ManageLPM).rebuildCounters,						// recompute every Merchant's and Campaign's counters
+	"foldCounters": (*ManageLPM).foldCounters,								// fold counter deltas into the counters they add to
+}
+
+var queryFunctions = map[string]chaincodeFunction{		// read-only functions, they never write state or send events
+	"getCustomerByID": (*ManageLPM).getCustomerByID,						//Read a Customer by Id
+	"getCustomerDetailsByID": (*ManageLPM).getCustomerDetailsByID,			//Read a Customer by Id
+	"getActivityHistory": (*ManageLPM).getActivityHistory,					//Read a Customer's transactions
+	"getMerchantStatement": (*ManageLPM).getMerchantStatement,				//Read a Merchant's transactions and balances
+	"getActivityHistoryForMerchant": (*ManageLPM).getMerchantStatement,		//Read a Merchant's transactions, by merchantId now
+	"getCustomerTier": (*ManageLPM).getCustomerTier,						//Read a Customer's tier with a Merchant
+	"getCampaignsByMerchantID": (*ManageLPM).getCampaignsByMerchantID,		//Read a Merchant's Campaigns and their cost
+	"getFxRate": (*ManageLPM).getFxRate,									//Read the FX rate in effect between two currencies
+	"getWalletWorth": (*ManageLPM).getWalletWorth,							//Read a Customer's holdings' worth in one currency
+	"getAllCustomers": (*ManageLPM).getAllCustomers,						//Read all Customers
+	"getCustomersByMerchantID": (*ManageLPM).getCustomersByMerchantID,		//Read a Merchant's Customers
+	"getMerchantByName": (*ManageLPM).getMerchantByName,					//Read all Merchants by Name
+	"getMerchantByID": (*ManageLPM).getMerchantByID,						//Read a Merchant by Id
+	"getMerchantDetailsByID": (*ManageLPM).getMerchantDetailsByID,			//Read a Merchant by Id
+	"getMerchantsByIndustry": (*ManageLPM).getMerchantsByIndustry,			//Read all Merchants in an Industry
+	"getAllMerchants": (*ManageLPM).getAllMerchants,						//Read all Merchants
+	"getMerchantsAccountBalance": (*ManageLPM).getMerchantsAccountBalance,	//Read a Merchant's balance
+	"getMerchantsUserCount": (*ManageLPM).getMerchantsUserCount,			//Read a Merchant's Customer count
+	"getMerchantCounters": (*ManageLPM).getMerchantCounters,				//Read a Merchant's counters
+	"getOwnersMerchantUserCount": (*ManageLPM).getOwnersMerchantUserCount,	//Read Merchant and Customer counts
+	"getNetworkAccount": (*ManageLPM).getNetworkAccount,					//Read the exchange fees the network was paid
+	"getOwnerByID": (*ManageLPM).getOwnerByID,								//Read an Owner by Id
+	"getMigrationStatus": (*ManageLPM).getMigrationStatus,					//Read schema version and migration reports
+	"getCallerIdentity": (*ManageLPM).getCallerIdentity,					//Read the caller's identity and role
+	"getRoleBindings": (*ManageLPM).getRoleBindings,						//Read all role bindings
+}
+
 // ============================================================================================================================
 // Main - start the chaincode for LPM management
 // ============================================================================================================================
@@ -91,16 +656,45 @@ func main() {
 // ============================================================================================================================
 // Init - reset all the things
 // ============================================================================================================================
-func (t *ManageLPM) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *ManageLPM) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	batch := newWriteBatch(stub)
+	payload, err := t.initLedger(batch, args)
+	if err == nil {
+		err = batch.commit()
+	}
+	return toResponse(payload, err)
+}
+// ============================================================================================================================
+// initLedger - write the test var and the schema version -- Internal Function
+// ============================================================================================================================
+func (t *ManageLPM) initLedger(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var msg string
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting ' ' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting ' ' as an argument")
+	}
+
+	// the first identity to initialize the ledger becomes its owner, afterwards only an owner may reset it
+	bound, err := hasRoleBindings(stub)
+	if err != nil {
+		return nil, err
+	}
+	if bound {
+		_, err = requireRole(stub, RoleBinding{Role: RoleOwner})
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
+		}
+	} else {
+		caller, err := getCaller(stub)
+		if err != nil {
+			return nil, err
+		}
+		caller.Role = RoleOwner
+		err = putRoleBinding(stub, caller)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Initialize the chaincode
@@ -110,148 +704,72 @@ func (t *ManageLPM) Init(stub shim.ChaincodeStubInterface, function string, args
 	if err != nil {
 		return nil, err
 	}
-	var empty []string
-	jsonAsBytes, _ := json.Marshal(empty)								//marshal an emtpy array of strings to clear the index
-	err = stub.PutState(CustomerIndexStr, jsonAsBytes)
+	// a ledger still holding legacy index arrays stays at LegacySchemaVersion until migrate has run
+	legacy, err := hasLegacyIndexes(stub)
 	if err != nil {
 		return nil, err
 	}
-	err = stub.PutState(TransactionIndexStr, jsonAsBytes)
-	if err != nil {
-		return nil, err
+	if !legacy {
+		err = stub.PutState(SchemaVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+		if err != nil {
+			return nil, err
+		}
 	}
-	err = stub.PutState(MerchantIndexStr, jsonAsBytes)
+	err = setEvent(stub, "evtsender", Event{Message: "ManageLPM chaincode is deployed successfully.", Code: "200"})
 	if err != nil {
 		return nil, err
 	}
-	tosend := "{ \"message\" : \"ManageLPM chaincode is deployed successfully.\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
-	if err != nil {
-		return nil, err
-	} 
 	return nil, nil
 }
 // ============================================================================================================================
-// Run - Our entry point for Invocations - [LEGACY] obc-peer 4/25/2016
-// ============================================================================================================================
-func (t *ManageLPM) Run(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("run is running " + function)
-	return t.Invoke(stub, function, args)
-}
-// ============================================================================================================================
-// Invoke - Our entry point for Invocations
+// Invoke - Our entry point for Invocations and Queries, a rejected request comes back as a ChaincodeError
 // ============================================================================================================================
-func (t *ManageLPM) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *ManageLPM) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
 	// Handle different functions
-	if function == "init" {													//initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	} else if function == "createCustomer" {											//create a new Customer
-		return t.createCustomer(stub, args)
-	}else if function == "updateCustomerAccumulation" {									//update a Customer
-		return t.updateCustomerAccumulation(stub, args)
-	}else if function == "updateCustomerPurchase" {									//update a Customer
-		return t.updateCustomerPurchase(stub, args)
-	}else if function == "updateCustomerTransfer" {									//update a Customer
-		return t.updateCustomerTransfer(stub, args)
-	}else if function == "deleteCustomer" {									// delete a Customer
-		return t.deleteCustomer(stub, args)
-	}else if function == "createMerchant" {											//create a new Merchant
-		return t.createMerchant(stub, args)
-	}else if function == "updateMerchant" {									//update a Merchant
-		return t.updateMerchant(stub, args)
-	}else if function == "deleteMerchant" {									// delete a Merchant
-		return t.deleteMerchant(stub, args)
-	}else if function == "createOwner" {									// create a owner
-		return t.createOwner(stub, args)
-	}else if function == "updateMerchantsPPDS" {									//update a Merchant's PPDS
-		return t.updateMerchantsPPDS(stub, args)
-	}else if function == "associateCustomer" {									// associate a customer to Merchant
-		return t.associateCustomer(stub, args)
-	}else if function == "updateMerchantsExchangeRate" {									// update a Merchant's Exchange Rate
-		return t.updateMerchantsExchangeRate(stub, args)
+	if handler, ok := queryFunctions[function]; ok {
+		return toResponse(handler(t, stub, args))
+	}
+	if handler, ok := invokeFunctions[function]; ok {
+		// nothing a mutating function writes reaches the ledger unless the whole function succeeds
+		batch := newWriteBatch(stub)
+		payload, err := handler(t, batch, args)
+		if err == nil {
+			err = batch.commit()
+		}
+		return toResponse(payload, err)
 	}
 	fmt.Println("invoke did not find func: " + function)
-	errMsg := "{ \"message\" : \"Received unknown function invocation\", \"code\" : \"503\"}"
-	err := stub.SetEvent("errEvent", []byte(errMsg))
-	if err != nil {
-		return nil, err
-	} 
-	return nil, nil			//error
-}
-// ============================================================================================================================
-// Query - Our entry point for Queries
-// ============================================================================================================================
-func (t *ManageLPM) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
-
-	// Handle different functions
-	if function == "getCustomerByID" {													//Read a Customer by Id
-		return t.getCustomerByID(stub, args)
-	}else if function == "getCustomerDetailsByID" {													//Read all transactions 
-		return t.getCustomerDetailsByID(stub, args)
-	}else if function == "getActivityHistory" {													//Read all transactions 
-		return t.getActivityHistory(stub, args)
-	}else if function == "getActivityHistoryForMerchant" {													//Read all transactions 
-		return t.getActivityHistoryForMerchant(stub, args)
-	}else if function == "getAllCustomers" {													//Read all Customers
-		return t.getAllCustomers(stub, args)
-	}else if function == "getCustomersByMerchantID" {													//Read a Customer by transId
-		return t.getCustomersByMerchantID(stub, args)
-	}else if function == "getMerchantByName" {													//Read all Merchants by Name
-		return t.getMerchantByName(stub, args)
-	}else if function == "getMerchantByID" {													//Read all Merchants
-		return t.getMerchantByID(stub, args)
-	}else if function == "getMerchantDetailsByID" {													//Read all Merchants
-		return t.getMerchantDetailsByID(stub, args)
-	}else if function == "getMerchantsByIndustry" {													//Read all Merchants
-		return t.getMerchantsByIndustry(stub, args)
-	}else if function == "getAllMerchants" {													//Read all Merchants
-		return t.getAllMerchants(stub, args)
-	}else if function == "getMerchantsAccountBalance" {													//Read all Merchants
-		return t.getMerchantsAccountBalance(stub, args)
-	}else if function == "getMerchantsUserCount" {													//Read all Merchants
-		return t.getMerchantsUserCount(stub, args)
-	}else if function == "getOwnersMerchantUserCount" {													//Read all Merchants
-		return t.getOwnersMerchantUserCount(stub, args)
-	}else if function == "getOwnerByID" {													//Read all Merchants
-		return t.getOwnerByID(stub, args)
-	}
-	fmt.Println("query did not find func: " + function)						//error
-	errMsg := "{ \"message\" : \"Received unknown function query\", \"code\" : \"503\"}"
-	err := stub.SetEvent("errEvent", []byte(errMsg))
-	if err != nil {
-		return nil, err
-	} 
-	return nil, nil
+	return toResponse(errorResponse(ErrInvalidArgument, "Received unknown function invocation"))
 }
 // ============================================================================================================================
 // getCustomerByID - get Customer details for a specific ID from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getCustomerByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var customerId string
-	var err error
 	fmt.Println("start getCustomerByID")
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'customerId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId' and optionally 'includeInactive' as arguments")
+	}
+	includeInactive, err := getIncludeInactive(args, 1)
+	if err != nil {
+		return nil, err
 	}
 	// set customerId
 	customerId = args[0]
 	fmt.Print("customerId in getCustomerByID : "+customerId)
-	valAsbytes, err := stub.GetState(customerId)									//get the customerId from chaincode state
+	res, found, err := getCustomer(stub, customerId)								//get the customerId from chaincode state
 	if err != nil {
-		errMsg := "{ \"message\" : \""+ customerId + " not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
+	}
+	if !found || (!includeInactive && !res.isActive()) {
+		return errorResponse(ErrNotFound, customerId + " not Found.")
+	}
+	valAsbytes, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
 	}
 	fmt.Print("valAsbytes : ")
 	fmt.Println(valAsbytes)
@@ -262,375 +780,464 @@ func (t *ManageLPM) getCustomerByID(stub shim.ChaincodeStubInterface, args []str
 // getCustomerDetailsByID - get Customer details for a specific ID from chaincode state POST Implementation
 // ============================================================================================================================
 func (t *ManageLPM) getCustomerDetailsByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var customerId string
-	var err error
 	fmt.Println("start getCustomerDetailsByID")
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'customerId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-	// set customerId
-	customerId = args[0]
-	fmt.Print("customerId in getCustomerDetailsByID : "+customerId)
-	valAsbytes, err := stub.GetState(customerId)									//get the customerId from chaincode state
-	if err != nil {
-		errMsg := "{ \"message\" : \""+ customerId + " not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-	fmt.Print("valAsbytes : ")
-	fmt.Println(valAsbytes)
-	fmt.Println("end getCustomerDetailsByID")
-	return valAsbytes, nil													//send it onward
+	return t.getCustomerByID(stub, args)
 }
 // ============================================================================================================================
-//  getActivityHistory - get Customer Transaction Activity details for a given customer from chaincode state
+//  getActivityHistory - get one page of a Customer's Transactions, oldest first, optionally from a bookmark and filtered
+//  by date range, transactionType and merchantId
 // ============================================================================================================================
 func (t *ManageLPM) getActivityHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, errResp string
-	var transactionIndex []string
-	var valIndex Transaction
 	var customerId string
+	var pageSize = HistoryPageSize
+	var bookmark string
 	var err error
+	filter := TransactionFilter{}
 	fmt.Println("start getActivityHistory")
-	
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'customerId' as argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+
+	if len(args) < 1 || len(args) > 7 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId' and optionally 'pageSize', 'bookmark', 'fromDateTime', 'toDateTime', 'transactionType' and 'merchantId' as arguments")
 	}
 	// set customerId
 	customerId = args[0]
 	fmt.Println("customerId in getActivityHistory::" + customerId)
-		
-	transactionAsBytes, err := stub.GetState(TransactionIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Transaction index string")
-	}
-	json.Unmarshal(transactionAsBytes, &transactionIndex)								//un stringify it aka JSON.parse()
-	jsonResp = "{"
-	for i,val := range transactionIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getActivityHistory")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
-        if valIndex.CustomerID == customerId{
-			fmt.Println("Customer found")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			fmt.Println("jsonResp inside if")
-			fmt.Println(jsonResp)
-			fmt.Println("transactionIndex::")
-			fmt.Println(transactionIndex)
-			fmt.Println("length::")
-			fmt.Println(len(transactionIndex))
-			if i < len(transactionIndex)-1 {
-				fmt.Println("i::")
-				fmt.Println(i)
-				jsonResp = jsonResp + ","
-				fmt.Println("jsonResp inside if if")
-				fmt.Println(jsonResp)
-			}
-		} 
+	// an empty optional argument leaves its default
+	if len(args) > 1 && args[1] != "" {
+		pageSize, err = strconv.Atoi(args[1])
+		if err != nil || pageSize <= 0 || pageSize > MaxHistoryPageSize {
+			return errorResponse(ErrInvalidArgument, "Invalid pageSize " + args[1] + ", expecting 1 to " + strconv.Itoa(MaxHistoryPageSize))
+		}
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+	if len(args) > 3 {
+		filter.From, err = parseDateTimeBound(args[3], false)
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid fromDateTime " + args[3] + ", expecting RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	if len(args) > 4 {
+		filter.To, err = parseDateTimeBound(args[4], true)
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid toDateTime " + args[4] + ", expecting RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	if len(args) > 5 {
+		filter.TransactionType = args[5]
+	}
+	if len(args) > 6 {
+		filter.MerchantID = args[6]
+	}
+
+	page, err := getTransactionPage(stub, CustomerTxnIndex, customerId, pageSize, bookmark, filter)	//only this customer's txn~customer keys are read
+	if err != nil {
+		return nil, err
 	}
-	jsonResp = jsonResp + "}"
-	if strings.Contains(jsonResp, "},}"){
-		fmt.Println("in if for jsonResp contains wrong json")	
-		jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
+	jsonResp, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
+	fmt.Println("jsonResp : " + string(jsonResp))
 	fmt.Println("end getActivityHistory")
-	return []byte(jsonResp), nil											//send it onward
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
-//  getActivityHistoryForMerchant - get Customer Transaction Activity details for a given merchant from chaincode state
+//  getMerchantStatement - get one page of a Merchant's statement for a date range, every credit and debit of its customers'
+//  points with the customer it was for, opening and closing balances of the points they hold and totals by transactionType
 // ============================================================================================================================
-func (t *ManageLPM) getActivityHistoryForMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, errResp string
-	var transactionIndex []string
-	var valIndex Transaction
-	var merchantName string
-	var transactionTypeCustomerOnBoarding string
+func (t *ManageLPM) getMerchantStatement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var merchantId string
+	var pageSize = HistoryPageSize
+	var bookmark string
 	var err error
-	fmt.Println("start getActivityHistoryForMerchant")
-	
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantName' as argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+	fmt.Println("start getMerchantStatement")
+
+	if len(args) < 1 || len(args) > 5 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' and optionally 'fromDateTime', 'toDateTime', 'pageSize' and 'bookmark' as arguments")
+	}
+	// set merchantId
+	merchantId = args[0]
+	fmt.Println("merchantId in getMerchantStatement::" + merchantId)
+	res := MerchantStatement{MerchantID: merchantId, Entries: []StatementEntry{}, Totals: map[string]StatementTotal{}}
+	// only legs that moved a customer's points count, a purchase is its customer's debit and not the Merchant's receipt
+	filter := TransactionFilter{MerchantID: merchantId, HoldingsOnly: true}
+	if len(args) > 1 {
+		res.From, err = parseDateTimeBound(args[1], false)
 		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+			return errorResponse(ErrInvalidArgument, "Invalid fromDateTime " + args[1] + ", expecting RFC 3339 or YYYY-MM-DD")
+		}
 	}
-	// set merchantName
-	merchantName = args[0]
-	fmt.Println("merchantName in getActivityHistoryForMerchant::" + merchantName)
-
-	transactionAsBytes, err := stub.GetState(TransactionIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Transaction index string")
-	}
-	json.Unmarshal(transactionAsBytes, &transactionIndex)								//un stringify it aka JSON.parse()
-	jsonResp = "{"
-	transactionTypeCustomerOnBoarding = "CustomerOnBoarding"
-	for i,val := range transactionIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getActivityHistoryForMerchant")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
-
-		if valIndex.TransactionType == transactionTypeCustomerOnBoarding{
-			if valIndex.TransactionFrom == merchantName{
-				fmt.Println("Customer's merchant found for CustomerOnBoarding")
-				jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-				fmt.Println("jsonResp inside if")
-				fmt.Println(jsonResp)
-				fmt.Println("transactionIndex::")
-				fmt.Println(transactionIndex)
-				fmt.Println("length::")
-				fmt.Println(len(transactionIndex))
-				if i < len(transactionIndex)-1 {
-					fmt.Println("i::")
-					fmt.Println(i)
-					jsonResp = jsonResp + ","
-					fmt.Println("jsonResp inside if if")
-					fmt.Println(jsonResp)
-				}
-			}
-		} else if valIndex.TransactionTo == merchantName{
-			fmt.Println("Customer's merchant found for other transactions")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			fmt.Println("jsonResp inside if")
-			fmt.Println(jsonResp)
-			fmt.Println("transactionIndex::")
-			fmt.Println(transactionIndex)
-			fmt.Println("length::")
-			fmt.Println(len(transactionIndex))
-			if i < len(transactionIndex)-1 {
-				fmt.Println("i::")
-				fmt.Println(i)
-				jsonResp = jsonResp + ","
-				fmt.Println("jsonResp inside if if")
-				fmt.Println(jsonResp)
-			}
+	if len(args) > 2 {
+		res.To, err = parseDateTimeBound(args[2], true)
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid toDateTime " + args[2] + ", expecting RFC 3339 or YYYY-MM-DD")
 		}
 	}
-	jsonResp = jsonResp + "}"
-	if strings.Contains(jsonResp, "},}"){
-		fmt.Println("in if for jsonResp contains wrong json")	
-		jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
+	if len(args) > 3 && args[3] != "" {
+		pageSize, err = strconv.Atoi(args[3])
+		if err != nil || pageSize <= 0 || pageSize > MaxHistoryPageSize {
+			return errorResponse(ErrInvalidArgument, "Invalid pageSize " + args[3] + ", expecting 1 to " + strconv.Itoa(MaxHistoryPageSize))
+		}
 	}
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
-	fmt.Println("end getActivityHistoryForMerchant")
-	return []byte(jsonResp), nil											//send it onward
-}
-// ============================================================================================================================
-//  getAllCustomers- get details of all Merchants from chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) getAllCustomers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, errResp string
-	var customerIndex []string
-	var err error
-	fmt.Println("start getAllCustomers")
-		
-	customerAsBytes, err := stub.GetState(CustomerIndexStr)
+	if len(args) > 4 {
+		bookmark = args[4]
+	}
+	filter.From = res.From
+	filter.To = res.To
+	_, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+
+	// the bookmark is only the txn~merchant key the page starts at, balances and totals are worked out again for every page
+	page, err := getTransactionPage(stub, MerchantTxnIndex, merchantId, pageSize, bookmark, filter)	//only this merchant's txn~merchant keys are read
+	if err != nil {
+		return nil, err
+	}
+	res.OpeningBalance, res.Totals, err = getStatementSums(stub, merchantId, filter, bookmark)
 	if err != nil {
-		return nil, errors.New("Failed to get Customer index")
+		return nil, err
 	}
-	json.Unmarshal(customerAsBytes, &customerIndex)			//un stringify it aka JSON.parse()
-	jsonResp = "{"
-	for i,val := range customerIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for all Customer")
-		valueAsBytes, err := stub.GetState(val)
+	for _, res_trans := range page.Transactions {
+		err = res.add(res_trans)
 		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-		if i < len(customerIndex)-1 {
-			jsonResp = jsonResp + ","
+			return nil, err
 		}
 	}
-	jsonResp = jsonResp + "}"
-	if strings.Contains(jsonResp, "},}"){
-		fmt.Println("in if for jsonResp contains wrong json")	
-		jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
+	res.ClosingBalance = res.OpeningBalance
+	if len(res.Entries) > 0 {
+		res.ClosingBalance = res.Entries[len(res.Entries)-1].Balance
 	}
-	fmt.Println("jsonResp in getAllCustomers::")
-	fmt.Println(jsonResp)
+	res.Bookmark = page.Bookmark
 
-	fmt.Println("end getAllCustomers")
-	return []byte(jsonResp), nil			//send it onward
-}
-// ============================================================================================================================
-// getCustomersByMerchantID - get Customers for a specific Merchant ID from chaincode state
+	jsonResp, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getMerchantStatement")
+	return jsonResp, nil											//send it onward
+}
 // ============================================================================================================================
-func (t *ManageLPM) getCustomersByMerchantID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, merchantId, errResp string
-	var err error
-	var customerIndex []string
-	var valIndex Customer
-	fmt.Println("start getCustomersByMerchantID")
+// getCustomerTier - get a customer's tier with a Merchant and what it earned within the tier window as of the proposal
+// ============================================================================================================================
+func (t *ManageLPM) getCustomerTier(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getCustomerTier")
+	if len(args) != 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId' and 'merchantId' as arguments")
+	}
+	customerId := args[0]
+	merchantId := args[1]
+	res, found, err := getCustomer(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !res.isActive() {
+		return errorResponse(ErrNotFound, customerId + " not Found.")
+	}
+	holdingIndex := getHoldingIndex(res, merchantId)
+	if holdingIndex < 0 {
+		return errorResponse(ErrNotFound, customerId + " is not associated with " + merchantId)
+	}
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " not Found.")
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	// the window rolls on without accumulations, so the tier is the one the earnings within it qualify for now
+	earnings := tierEarnings(res_Merchant, res.Holdings[holdingIndex], transactionDateTime)
+	report := CustomerTier{CustomerID: customerId, MerchantID: merchantId, TierBasis: res_Merchant.TierBasis, TierWindowMonths: res_Merchant.TierWindowMonths}
+	report.Tier, report.Multiplier = qualifyingTier(res_Merchant, earnings)
+	report.Spend = Money{Units: 0, Currency: res_Merchant.MerchantCurrency}
+	for _, earning := range earnings {
+		report.Points += earning.Points
+		report.Spend.Units += earning.Spend.Units
+	}
+	qualified := int64(report.Points)
+	if res_Merchant.TierBasis == TierBasisSpend {
+		qualified = report.Spend.Units
+	}
+	for _, tier := range res_Merchant.Tiers {
+		if tier.Threshold > qualified && report.NextTier == "" {
+			report.NextTier = tier.Name
+			report.NextThreshold = tier.Threshold
+		}
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getCustomerTier")
+	return jsonResp, nil											//send it onward
+}
+// ============================================================================================================================
+// getCampaignsByMerchantID - get a Merchant's Campaigns with the bonuses each has credited from chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) getCampaignsByMerchantID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getCampaignsByMerchantID")
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' as an argument")
+	}
+	merchantId := args[0]
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " not Found.")
+	}
+	campaignIds, err := getIndexedIds(stub, MerchantCampaignIndex, []string{merchantId})
+	if err != nil {
+		return nil, err
+	}
+	reports := []CampaignReport{}
+	for _, campaignId := range campaignIds {
+		res, found, err := getCampaign(stub, campaignId)
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
+		}
+		if !found {
+			continue
+		}
+		res_Counters, err := getCampaignCounters(stub, campaignId)
+		if err != nil {
+			return nil, err
+		}
+		report := CampaignReport{Campaign: res, Bonuses: res_Counters.Bonuses, BonusPoints: res_Counters.BonusPoints}
+		report.BonusWorth, err = pointsWorth(res_Counters.BonusPoints, res_Merchant.ExchangeRate, res_Merchant.MerchantCurrency)
+		if err != nil {
+			return errorResponse(ErrInternal, "Failed to price the bonuses of " + campaignId + ": " + err.Error())
+		}
+		reports = append(reports, report)
+	}
+	jsonResp, err := json.Marshal(reports)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getCampaignsByMerchantID")
+	return jsonResp, nil											//send it onward
+}
+// ============================================================================================================================
+// getFxRate - get the rate of one currency in another in effect at asOf, the proposal timestamp when not given
+// ============================================================================================================================
+func (t *ManageLPM) getFxRate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getFxRate")
+	if len(args) < 2 || len(args) > 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'baseCurrency', 'quoteCurrency' and optionally 'asOf' as arguments")
+	}
+	asOf, err := getAsOf(stub, args, 2)
+	if err != nil {
+		return nil, err
+	}
+	baseCurrency, err := parseCurrency(args[0])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid baseCurrency " + args[0] + ", expecting an ISO 4217 code")
+	}
+	quoteCurrency, err := parseCurrency(args[1])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid quoteCurrency " + args[1] + ", expecting an ISO 4217 code")
+	}
+	res, err := getFxRateAt(stub, baseCurrency, quoteCurrency, asOf)
+	if err != nil {
+		return nil, err
+	}
+	jsonResp, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getFxRate")
+	return jsonResp, nil											//send it onward
+}
+// ============================================================================================================================
+// getWalletWorth - get the worth of a customer's holdings in one currency at the rates in effect at asOf, the proposal
+// timestamp when not given
+// ============================================================================================================================
+func (t *ManageLPM) getWalletWorth(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getWalletWorth")
+	if len(args) < 2 || len(args) > 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId', 'currency' and optionally 'asOf' as arguments")
+	}
+	customerId := args[0]
+	currency, err := parseCurrency(args[1])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid currency " + args[1] + ", expecting an ISO 4217 code")
+	}
+	asOf, err := getAsOf(stub, args, 2)
+	if err != nil {
+		return nil, err
+	}
+	res, found, err := getCustomer(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !res.isActive() {
+		return errorResponse(ErrNotFound, customerId + " not Found.")
+	}
+	report := WalletWorth{CustomerID: customerId, Currency: currency, AsOf: asOf, WalletWorth: Money{Units: 0, Currency: currency}, Holdings: []HoldingWorth{}}
+	for _, holding := range res.Holdings {
+		res_FxRate, err := getFxRateAt(stub, holding.Worth.Currency, currency, asOf)
+		if err != nil {
+			return nil, err
+		}
+		converted, err := convertMoney(stub, holding.Worth, currency, asOf)
+		if err != nil {
+			return nil, err
+		}
+		report.WalletWorth, err = report.WalletWorth.Add(converted)
+		if err != nil {
+			return errorResponse(ErrInternal, "Failed to total the worth of " + customerId + ": " + err.Error())
+		}
+		report.Holdings = append(report.Holdings, HoldingWorth{MerchantID: holding.MerchantID, Worth: holding.Worth, Converted: converted, FxRate: res_FxRate.Rate})
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getWalletWorth")
+	return jsonResp, nil											//send it onward
+}
+// ============================================================================================================================
+//  getAllCustomers- get details of all Merchants from chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) getAllCustomers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getAllCustomers")
+	if len(args) > 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting optionally 'includeInactive' as an argument")
+	}
+	includeInactive, err := getIncludeInactive(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	customers, err := getCustomerRecords(stub)
+	if err != nil {
+		return nil, err
+	}
+	for id, res := range customers {
+		if !includeInactive && !res.isActive() {
+			delete(customers, id)
+		}
+	}
+	jsonResp, err := json.Marshal(customers)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp in getAllCustomers::")
+	fmt.Println(string(jsonResp))
+
+	fmt.Println("end getAllCustomers")
+	return jsonResp, nil			//send it onward
+}
+// ============================================================================================================================
+// getCustomersByMerchantID - get Customers for a specific Merchant ID from chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) getCustomersByMerchantID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var merchantId string
+	fmt.Println("start getCustomersByMerchantID")
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' and optionally 'includeInactive' as arguments")
+	}
+	includeInactive, err := getIncludeInactive(args, 1)
+	if err != nil {
+		return nil, err
 	}
 	// set merchantId
 	merchantId = args[0]
 
-	customerAsBytes, err := stub.GetState(CustomerIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Customer index string")
-	}
-	json.Unmarshal(customerAsBytes, &customerIndex)								//un stringify it aka JSON.parse()
-	fmt.Print("customerIndex : ")
-	fmt.Println(customerIndex)
-	
-	jsonResp = "{"
-	for i,val := range customerIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getCustomersByMerchantID")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
-		if strings.Contains(valIndex.MerchantIDs, merchantId){
-			fmt.Println("Customer found")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			fmt.Println("jsonResp inside if")
-			fmt.Println(jsonResp)
-			/*if i < len(customerIndex)-1 {
-				jsonResp = jsonResp + ","
-			}*/
-		} 
-	}
-	jsonResp = jsonResp + "}"
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
+	customers, err := getCustomersOfMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	for customerId, res := range customers {
+		if !includeInactive && !res.isActive() {
+			delete(customers, customerId)
+		}
+	}
+	jsonResp, err := json.Marshal(customers)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
 	fmt.Println("end getCustomersByMerchantID")
-	return []byte(jsonResp), nil											//send it onward
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
 //  getMerchantByName - get Merchant details by name from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getMerchantByName(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, merchantName, errResp string
-	var merchantIndex []string
-	var valIndex Merchant
+	var merchantName string
 	fmt.Println("start getMerchantByName")
-	var err error
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantName' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantName' and optionally 'includeInactive' as arguments")
+	}
+	includeInactive, err := getIncludeInactive(args, 1)
+	if err != nil {
+		return nil, err
 	}
 	// set merchant's name
 	merchantName = args[0]
 	fmt.Println("merchantName" + merchantName)
-	merchantAsBytes, err := stub.GetState(MerchantIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Merchant index string")
-	}
-	json.Unmarshal(merchantAsBytes, &merchantIndex)				//un stringify it aka JSON.parse()
-	fmt.Print("merchantIndex : ")
-	fmt.Println(merchantIndex)
-	jsonResp = "{"
-	for i,val := range merchantIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getMerchantByName")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
+	allMerchants, err := getMerchantRecords(stub)
+	if err != nil {
+		return nil, err
+	}
+	merchants := map[string]Merchant{}
+	for val,valIndex := range allMerchants{
+		if !includeInactive && !valIndex.isActive() {
+			continue
+		}
 		if valIndex.MerchantName == merchantName{
 			fmt.Println("Merchant found")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			fmt.Println("jsonResp inside if")
-			fmt.Println(jsonResp)
-			/*if i < len(merchantIndex)-1 {
-				jsonResp = jsonResp + ","
-			}*/
-		} 
-	}
-	jsonResp = jsonResp + "}"
-	fmt.Println("jsonResp in getMerchantByName: " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
+			merchants[val] = valIndex
+		}
+	}
+	jsonResp, err := json.Marshal(merchants)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp in getMerchantByName: " + string(jsonResp))
 	fmt.Println("end getMerchantByName")
-	return []byte(jsonResp), nil											//send it onward
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
 // getMerchantByID - get Merchant details for a specific ID from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getMerchantByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var merchantId string
-	var err error
 	fmt.Println("start getMerchantByID")
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' and optionally 'includeInactive' as arguments")
+	}
+	includeInactive, err := getIncludeInactive(args, 1)
+	if err != nil {
+		return nil, err
 	}
 	// set merchantId
 	merchantId = args[0]
-	valAsbytes, err := stub.GetState(merchantId)									//get the merchantId from chaincode state
+	res, found, err := getMerchant(stub, merchantId)								//get the merchantId from chaincode state
 	if err != nil {
-		errMsg := "{ \"message\" : \""+ merchantId + " not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
+	}
+	if !found || (!includeInactive && !res.isActive()) {
+		return errorResponse(ErrNotFound, merchantId + " not Found.")
+	}
+	valAsbytes, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
 	}
 	fmt.Println("end getMerchantByID")
 	return valAsbytes, nil													//send it onward
@@ -639,310 +1246,187 @@ func (t *ManageLPM) getMerchantByID(stub shim.ChaincodeStubInterface, args []str
 // getMerchantDetailsByID - get Merchant details for a specific ID from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getMerchantDetailsByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var merchantId string
-	var err error
 	fmt.Println("start getMerchantDetailsByID")
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-	// set merchantId
-	merchantId = args[0]
-	valAsbytes, err := stub.GetState(merchantId)									//get the merchantId from chaincode state
-	if err != nil {
-		errMsg := "{ \"message\" : \""+ merchantId + " not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-	fmt.Println("end getMerchantDetailsByID")
-	return valAsbytes, nil													//send it onward
+	return t.getMerchantByID(stub, args)
 }
 // ============================================================================================================================
 // getMerchantsByIndustry - get Merchants for a given Industry from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getMerchantsByIndustry(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, industryName, errResp string
-	var err error
-	var merchantIndex []string
-	var valIndex Merchant
+	var industryName string
 	fmt.Println("start getMerchantsByIndustry")
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'industryName' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'industryName' and optionally 'includeInactive' as arguments")
+	}
+	includeInactive, err := getIncludeInactive(args, 1)
+	if err != nil {
+		return nil, err
 	}
 	// set merchantId
 	industryName = args[0]
 
-	merchantAsBytes, err := stub.GetState(MerchantIndexStr)
+	allMerchants, err := getMerchantRecords(stub)
 	if err != nil {
-		return nil, errors.New("Failed to get Merchant index string")
+		return nil, err
 	}
-	json.Unmarshal(merchantAsBytes, &merchantIndex)			//un stringify it aka JSON.parse()
-	fmt.Print("merchantIndex : ")
-	fmt.Println(merchantIndex)
-	
-	jsonResp = "{"
-	for i,val := range merchantIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getMerchantsByIndustry")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
+	merchants := map[string]Merchant{}
+	for val,valIndex := range allMerchants{
+		if !includeInactive && !valIndex.isActive() {
+			continue
 		}
-		//fmt.Print("valueAsBytes : ")
-		//fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
 		if valIndex.MerchantIndustry == industryName{
 			fmt.Println("Merchant found")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			fmt.Println("jsonResp inside if")
-			fmt.Println(jsonResp)
-			if i < len(merchantIndex)-1 {
-				jsonResp = jsonResp + ","
-			}
-		} 
+			merchants[val] = valIndex
+		}
 	}
-	jsonResp = jsonResp + "}"
-	if strings.Contains(jsonResp, "},}"){
-		fmt.Println("in if for jsonResp contains wrong json")	
-		jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
+	jsonResp, err := json.Marshal(merchants)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
+	fmt.Println("jsonResp : " + string(jsonResp))
 	fmt.Println("end getMerchantsByIndustry")
-	return []byte(jsonResp), nil											//send it onward
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
 //  getAllMerchants- get details of all Merchants from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getAllMerchants(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, errResp string
-	var merchantIndex []string
-	var err error
 	fmt.Println("start getAllMerchants")
-		
-	merchantAsBytes, err := stub.GetState(MerchantIndexStr)
+	if len(args) > 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting optionally 'includeInactive' as an argument")
+	}
+	includeInactive, err := getIncludeInactive(args, 0)
 	if err != nil {
-		return nil, errors.New("Failed to get Merchant index")
+		return nil, err
 	}
-	json.Unmarshal(merchantAsBytes, &merchantIndex)								//un stringify it aka JSON.parse()
-	jsonResp = "{"
-	for i,val := range merchantIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for all Merchant")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-		if i < len(merchantIndex)-1 {
-			jsonResp = jsonResp + ","
+
+	merchants, err := getMerchantRecords(stub)
+	if err != nil {
+		return nil, err
+	}
+	for id, res := range merchants {
+		if !includeInactive && !res.isActive() {
+			delete(merchants, id)
 		}
 	}
-	jsonResp = jsonResp + "}"
-	if strings.Contains(jsonResp, "},}"){
-		fmt.Println("in if for jsonResp contains wrong json")	
-		jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
+	jsonResp, err := json.Marshal(merchants)
+	if err != nil {
+		return nil, err
 	}
 	fmt.Println("jsonResp in getAllMerchants::")
-	fmt.Println(jsonResp)
+	fmt.Println(string(jsonResp))
 
 	fmt.Println("end getAllMerchants")
-	return []byte(jsonResp), nil			//send it onward
+	return jsonResp, nil			//send it onward
 }
 // ============================================================================================================================
-// getMerchantsAccountBalance - get merchants account balance from chaincode state
+// getMerchantsAccountBalance - get a merchant's points liability, its worth and its purchase balance from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getMerchantsAccountBalance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, merchantId, errResp string
-	var err error
-	var customerIndex []string
-	accountBalance := float64(0.0)
-	var valIndex Customer
-	var merchantIndex Merchant
-	var merchantIndexForPointsWorth int
+	var merchantId string
 	fmt.Println("start getMerchantsAccountBalance")
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantId' as argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' as argument")
 	}
-	// set merchantName
+	// set merchantId
 	merchantId = args[0]
-	
-	// Get Merchants Balance from Merchant Struct START
-	merchantAsbytes, err := stub.GetState(merchantId)
+
+	// every figure is kept up to date as its customers' holdings change, no customer is read here
+	res_Merchant, found, err := getMerchant(stub, merchantId)
 	if err != nil {
-		errMsg := "{ \"message\" : \""+ merchantId + " not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	json.Unmarshal(merchantAsbytes, &merchantIndex)
-	fmt.Print("purchaseBalance for merchant : ")
-	fmt.Print(merchantIndex.PurchaseBalance)
-	accountBalanceMerchant, _ := strconv.ParseFloat(merchantIndex.PurchaseBalance, 64)
-	// Get Merchants Balance from Merchant Struct END
-
-	customerAsBytes, err := stub.GetState(CustomerIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Customer index string")
-	}
-	json.Unmarshal(customerAsBytes, &customerIndex)			//un stringify it aka JSON.parse()
-	fmt.Print("customerIndex : ")
-	fmt.Println(customerIndex)
-	jsonResp = "{"
-	for i,val := range customerIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getMerchantsAccountBalance")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
-		if strings.Contains(valIndex.MerchantIDs, merchantId){
-			fmt.Println("Merchant found")
-
-			// find the index of the merchant to take the merchantPointsWorth in that index only
-			fmt.Println("valIndex.MerchantIDs::"+valIndex.MerchantIDs)
-			stringSliceMerchantIDs := strings.Split(valIndex.MerchantIDs, ",")
-			for j,val := range stringSliceMerchantIDs{
-				if val == merchantId{
-					fmt.Println(strconv.Itoa(j) + " - looking at " + val + " for index")
-					merchantIndexForPointsWorth = j
-				}
-			}
-			// Sum all pointsWorth at the merchantIndex
-			fmt.Println("valIndex.MerchantsPointsWorth::"+valIndex.MerchantsPointsWorth)
-			stringSlice := strings.Split(valIndex.MerchantsPointsWorth, ",")
-			for k,val := range stringSlice{
-				if merchantIndexForPointsWorth == k{
-					fmt.Println(strconv.Itoa(k) + " - looking at " + val + " for balance")
-					valToBeAdded, _ := strconv.ParseFloat(val, 64)
-	     		    accountBalance = accountBalance + valToBeAdded
-	     		}
-    		}
-    		accountBalance = accountBalance + accountBalanceMerchant
-		} 
-	}
-	jsonResp = jsonResp + "\"merchantAccountBalance\":" + strconv.FormatFloat(accountBalance, 'f', 2, 64)
-	jsonResp = jsonResp + "}"
-	if strings.Contains(jsonResp, "},}"){
-		fmt.Println("in if for jsonResp contains wrong json")	
-		jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
-	}
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " not Found.")
+	}
+	res_Counters, err := getMerchantCounters(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	res := MerchantBalance{}
+	res.MerchantID = merchantId
+	res.PointsLiability = res_Counters.PointsLiability
+	res.LiabilityWorth, err = pointsWorth(res_Counters.PointsLiability, res_Merchant.ExchangeRate, res_Merchant.MerchantCurrency)
+	if err != nil {
+		return errorResponse(ErrInternal, "Failed to value the points liability of " + merchantId + ": " + err.Error())
+	}
+	res.ExchangeRate = res_Merchant.ExchangeRate
+	res.PurchaseBalance = res_Merchant.PurchaseBalance
+	jsonResp, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
 	fmt.Println("end getMerchantsAccountBalance")
-	return []byte(jsonResp), nil											//send it onward
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
 // getMerchantsUserCount - get merchants user count from chaincode state
 // ============================================================================================================================
 func (t *ManageLPM) getMerchantsUserCount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, merchantId, errResp string
-	var err error
-	var customerIndex []string
-	var userCount = 0;
-	var valIndex Customer
+	var merchantId string
 	fmt.Println("start getMerchantsUserCount")
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' as an argument")
 	}
-	// set merchantName
+	// set merchantId
 	merchantId = args[0]
 
-	customerAsBytes, err := stub.GetState(CustomerIndexStr)
+	res, err := getMerchantCounters(stub, merchantId)
 	if err != nil {
-		return nil, errors.New("Failed to get Customer index string")
+		return nil, err
 	}
-	json.Unmarshal(customerAsBytes, &customerIndex)			//un stringify it aka JSON.parse()
-	fmt.Print("customerIndex : ")
-	fmt.Println(customerIndex)
-	jsonResp = "{"
-	for i,val := range customerIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getMerchantsUserCount")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		fmt.Print("valueAsBytes : ")
-		fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		fmt.Print("valIndex: ")
-		fmt.Print(valIndex)
-		if strings.Contains(valIndex.MerchantIDs, merchantId){
-			fmt.Println("Merchant found")
-			userCount++;
-		} 
-	}
-	jsonResp = jsonResp + "\"merchantUsersCount\":" + strconv.Itoa(userCount)
-	jsonResp = jsonResp + "}"
-	
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
+	jsonResp, err := json.Marshal(map[string]int64{"merchantUsersCount": res.ActiveMembers})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("jsonResp : " + string(jsonResp))
 	fmt.Println("end getMerchantsUserCount")
-	return []byte(jsonResp), nil											//send it onward
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
-// getOwnerByID - get Owner details for a specific ID from chaincode state
+// getMerchantCounters - get a merchant's members, customers and points issued, redeemed and outstanding from chaincode state
 // ============================================================================================================================
-func (t *ManageLPM) getOwnerByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var ownerId string
-	var err error
-	fmt.Println("start getOwnerByID")
+func (t *ManageLPM) getMerchantCounters(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getMerchantCounters")
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'ownerId' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' as an argument")
+	}
+	res, err := getMerchantCounters(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonResp, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getMerchantCounters")
+	return jsonResp, nil											//send it onward
+}
+// ============================================================================================================================
+// getOwnerByID - get Owner details for a specific ID from chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) getOwnerByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var ownerId string
+	fmt.Println("start getOwnerByID")
+	if len(args) != 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'ownerId' as an argument")
 	}
 	// set ownerId
 	ownerId = args[0]
 	fmt.Print("ownerId in getOwnerByID : "+ownerId)
-	valAsbytes, err := stub.GetState(ownerId)									//get the ownerId from chaincode state
+	res, found, err := getOwner(stub, ownerId)									//get the ownerId from chaincode state
 	if err != nil {
-		errMsg := "{ \"message\" : \""+ ownerId + " not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, ownerId + " not Found.")
+	}
+	valAsbytes, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
 	}
 	fmt.Print("valAsbytes : ")
 	fmt.Println(valAsbytes)
@@ -950,1391 +1434,5925 @@ func (t *ManageLPM) getOwnerByID(stub shim.ChaincodeStubInterface, args []string
 	return valAsbytes, nil													//send it onward
 }
 // ============================================================================================================================
-// getOwnersMerchantUserCount - get owners merchants and users count from chaincode state
+// getOwnersMerchantUserCount - get network totals of merchants, users and points, the sum of the NetworkCounterShards shards
+// written with every merchant's counters
 // ============================================================================================================================
 func (t *ManageLPM) getOwnersMerchantUserCount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp string
-	var err error
-	var merchantIndex []string
-	var customerIndex []string
-	var merchantCount = 0;
-	var userCount = 0;
 	fmt.Println("start getOwnersMerchantUserCount")
-	
-	merchantAsBytes, err := stub.GetState(MerchantIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Merchant index string")
-	}
-	json.Unmarshal(merchantAsBytes, &merchantIndex)			//un stringify it aka JSON.parse()
-	fmt.Print("merchantIndex : ")
-	fmt.Println(merchantIndex)
-	jsonResp = "{"
-	for i,val := range merchantIndex{
-		fmt.Println("Merchant found")
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val)
-		merchantCount++;
-	}
-	customerAsBytes, err := stub.GetState(CustomerIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Customer index string")
-	}
-	json.Unmarshal(customerAsBytes, &customerIndex)			//un stringify it aka JSON.parse()
-	fmt.Print("customerIndex : ")
-	fmt.Println(customerIndex)
-	jsonResp = "{"
-	for j,valCustomer := range customerIndex{
-		fmt.Println("Customer found")
-		fmt.Println(strconv.Itoa(j) + " - looking at " + valCustomer)
-		userCount++;
-	}
-
-	jsonResp = jsonResp + "\"merchantCount\":" + strconv.Itoa(merchantCount) + "," + "\"userCount\":" + strconv.Itoa(userCount)
-	jsonResp = jsonResp + "}"
-	
-	fmt.Println("jsonResp : " + jsonResp)
-	fmt.Print("jsonResp in bytes : ")
-	fmt.Println([]byte(jsonResp))
-	fmt.Println("end getOwnersMerchantUserCount")
-	return []byte(jsonResp), nil
-}
-// ============================================================================================================================
-// create Customer - create a new Customer, store into chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) createCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	if len(args) != 13 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 13\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+
+	res := NetworkCounters{}
+	for shard := 0; shard < NetworkCounterShards; shard++ {
+		key, err := stub.CreateCompositeKey(NetworkCountersObjectType, []string{strconv.Itoa(shard)})
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
-	}
-	fmt.Println("start createCustomer")
-	customerId := args[0]
-	userName := args[1]
-	customerName := args[2]
-	walletWorth := args[3]
-	merchantID := args[4]
-	merchantName := args[5]
-	merchantColor := args[6]
-	merchantCurrency := args[7]
-	merchantsPointsCount := args[8]
-	merchantsPointsWorth := args[9]
-	transactionID := args[10]
- 	transactionDateTime := args[11]
-	transactionType := args[12]
-
-	customerAsBytes, err := stub.GetState(customerId)
-	if err != nil {
-		return nil, errors.New("Failed to get Customer customerID")
-	}
-	res := Customer{}
-	json.Unmarshal(customerAsBytes, &res)
-	if res.CustomerID == customerId{
-		errMsg := "{ \"message\" : \"This Customer arleady exists\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
+		}
+		res_Shard := NetworkCounters{}
+		_, err = getRecord(stub, key, &res_Shard)
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil				//all stop a Customer by this name exists
-	}
-	
-	//build the Customer json string manually
-	customer_json := 	`{`+
-		`"customerId": "` + customerId + `" , `+
-		`"customerName": "` + customerName + `" , `+
-		`"userName": "` + userName + `" , `+
-		`"walletWorth": "` + walletWorth + `" , `+
-		`"merchantIDs": "` + merchantID + `" , `+ 
-		`"merchantNames": "` + merchantName + `" , `+ 
-		`"merchantColors": "` + merchantColor + `" , `+
-		`"merchantCurrencies": "` + merchantCurrency + `" , `+ 
-		`"merchantsPointsCount": "` + merchantsPointsCount + `" , `+ 
-		`"merchantsPointsWorth": "` +  merchantsPointsWorth + `" `+ 
-	`}`
-	fmt.Println("customer_json: " + customer_json)
-	fmt.Print("customer_json in bytes array: ")
-	fmt.Println([]byte(customer_json))
-	err = stub.PutState(customerId, []byte(customer_json))									//store Customer with customerId as key
-	if err != nil {
-		return nil, err
-	}
-	//get the Customer index
-	customerIndexAsBytes, err := stub.GetState(CustomerIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Customer index")
-	}
-	var customerIndex []string	
-	json.Unmarshal(customerIndexAsBytes, &customerIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	customerIndex = append(customerIndex, customerId)									//add Customer customerID to index list
-	
-	jsonAsBytes, _ := json.Marshal(customerIndex)
-	fmt.Print("jsonAsBytes: ")
-	fmt.Println(jsonAsBytes)
-	err = stub.PutState(CustomerIndexStr, jsonAsBytes)						//store name of Customer
-	if err != nil {
-		return nil, err
+		}
+		err = res.addTotals(res_Shard)
+		if err != nil {
+			return errorResponse(ErrInternal, "Failed to total counters: " + err.Error())
+		}
 	}
 
-	// build the Transaction json string manually
-	transaction_json := `{`+
-		`"transactionId": "` + transactionID + `" , `+
-		`"transactionDateTime": "` + transactionDateTime + `" , `+
-		`"transactionType": "` + transactionType + `" , `+
-		`"transactionFrom": "` + merchantName + `" , `+ 
-		`"transactionTo": "` + userName + `" , `+ 
-		`"credit": "` + walletWorth + `" , `+ 
-		`"debit": "` + "0" + `" , `+ 
-		`"customerId": "` +  customerId + `" `+ 
-	`}`
-	err = stub.PutState(transactionID, []byte(transaction_json))					//store Transaction with id as key
+	jsonResp, err := json.Marshal(res)
 	if err != nil {
 		return nil, err
 	}
 
-	//get the Transaction index
-	transactionAsBytes, err := stub.GetState(TransactionIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Transaction index")
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getOwnersMerchantUserCount")
+	return jsonResp, nil
+}
+// ============================================================================================================================
+// getNetworkAccount - get the exchange fees the network was paid in one currency, the sum of the NetworkCounterShards shards
+// every exchange books its fee to
+// ============================================================================================================================
+func (t *ManageLPM) getNetworkAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getNetworkAccount")
+	if len(args) != 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'currency' as argument")
 	}
-	var transactionIndex []string	
-	json.Unmarshal(transactionAsBytes, &transactionIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	transactionIndex = append(transactionIndex, transactionID)			//add Transaction transactionID to index list
-	
-	transactioJsonAsBytes, _ := json.Marshal(transactionIndex)
-	fmt.Print("update transaction jsonAsBytes: ")
-	fmt.Println(transactioJsonAsBytes)
-	err = stub.PutState(TransactionIndexStr, transactioJsonAsBytes)						//store name of Transaction
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner})
 	if err != nil {
 		return nil, err
 	}
-
-	tosend := "{ \"customerID\" : \""+customerId+"\", \"message\" : \"Customer created succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	currency, err := parseCurrency(args[0])
 	if err != nil {
-		return nil, err
-	} 
+		return errorResponse(ErrInvalidArgument, "Invalid currency " + args[0] + ", expecting an ISO 4217 code")
+	}
 
-	fmt.Println("end createCustomer")
-	return nil, nil
-}
-// ============================================================================================================================
-// Write - update customer during accumulation into chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) updateCustomerAccumulation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	fmt.Println("Updating Customer - accumulation")
-	if len(args) != 11 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 11\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+	res := NetworkAccount{Currency: currency, Fees: Money{Units: 0, Currency: currency}}
+	for shard := 0; shard < NetworkCounterShards; shard++ {
+		key, err := stub.CreateCompositeKey(NetworkAccountObjectType, []string{currency, strconv.Itoa(shard)})
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
-	}
-	// set customerId
-	customerId := args[0]
-	customerAsBytes, err := stub.GetState(customerId)					//get the Customer for the specified customerId from chaincode state
-	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + customerId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+		}
+		res_Shard := NetworkAccount{Fees: Money{Units: 0, Currency: currency}}
+		_, err = getRecord(stub, key, &res_Shard)
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
-	}
-	res := Customer{}
-	res_trans := Transaction{}
- 	transactionId := args[4]
-	json.Unmarshal(customerAsBytes, &res)
-	if res.CustomerID == customerId{
-		fmt.Println("Customer found with customerId : " + customerId)
-		fmt.Println(res);
-		res.WalletWorth = args[1]
-		res.MerchantsPointsCount = args[2]
-		res.MerchantsPointsWorth = args[3]
-		res_trans.TransactionID = transactionId
- 		res_trans.TransactionDateTime = args[5]
- 		res_trans.TransactionType = args[6]
- 		res_trans.TransactionFrom = args[7]
- 		res_trans.TransactionTo = args[8]
- 		res_trans.Credit = args[9]
- 		res_trans.Debit = args[10]
- 		res_trans.CustomerID = customerId
-	}else{
-		errMsg := "{ \"message\" : \""+ customerId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+		}
+		res.Fees, err = res.Fees.Add(res_Shard.Fees)
 		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+			return errorResponse(ErrInternal, "Failed to total the network account: " + err.Error())
+		}
+		res.Exchanges += res_Shard.Exchanges
 	}
-	
-	//build the Customer json string manually
-	customer_json := 	`{`+
-		`"customerId": "` + res.CustomerID + `" , `+
-		`"userName": "` + res.UserName + `" , `+
-		`"customerName": "` + res.CustomerName + `" , `+
-		`"walletWorth": "` + res.WalletWorth + `" , `+
-		`"merchantIDs": "` + res.MerchantIDs + `" , `+
-		`"merchantNames": "` + res.MerchantNames + `" , `+
-		`"merchantColors": "` + res.MerchantColors + `" , `+
-		`"merchantCurrencies": "` + res.MerchantCurrencies + `" , `+
-		`"merchantsPointsCount": "` + res.MerchantsPointsCount + `" , `+ 
-		`"merchantsPointsWorth": "` +  res.MerchantsPointsWorth + `" `+ 
-	`}`
-	err = stub.PutState(customerId, []byte(customer_json))									//store Customer with id as key
+
+	jsonResp, err := json.Marshal(res)
 	if err != nil {
 		return nil, err
 	}
 
-	// build the Transaction json string manually
-	transaction_json := `{`+
-		`"transactionId": "` + transactionId + `" , `+
-		`"transactionDateTime": "` + res_trans.TransactionDateTime + `" , `+
-		`"transactionType": "` + res_trans.TransactionType + `" , `+
-		`"transactionFrom": "` + res_trans.TransactionFrom + `" , `+ 
-		`"transactionTo": "` + res_trans.TransactionTo + `" , `+ 
-		`"credit": "` + res_trans.Credit + `" , `+ 
-		`"debit": "` + res_trans.Debit + `" , `+ 
-		`"customerId": "` +  res_trans.CustomerID + `" `+ 
-	`}`
-	err = stub.PutState(transactionId, []byte(transaction_json))									//store Transaction with id as key
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getNetworkAccount")
+	return jsonResp, nil
+}
+// ============================================================================================================================
+// getMigrationStatus - get the schema version, migration progress and every batch report from chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) getMigrationStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getMigrationStatus")
+
+	schemaVersion, err := getSchemaVersion(stub)
 	if err != nil {
 		return nil, err
 	}
-
-	//get the Transaction index
-	transactionAsBytes, err := stub.GetState(TransactionIndexStr)
+	progress := MigrationProgress{}
+	_, err = getRecord(stub, MigrationProgressKey, &progress)
 	if err != nil {
-		return nil, errors.New("Failed to get Transaction index")
+		return nil, err
 	}
-	var transactionIndex []string	
-	json.Unmarshal(transactionAsBytes, &transactionIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	transactionIndex = append(transactionIndex, transactionId)									//add Transaction transactionId to index list
-	
-	jsonAsBytes, _ := json.Marshal(transactionIndex)
-	fmt.Print("update customer jsonAsBytes: ")
-	fmt.Println(jsonAsBytes)
-	err = stub.PutState(TransactionIndexStr, jsonAsBytes)						//store name of Transaction
+	reports := []MigrationReport{}
+	reportsIter, err := stub.GetStateByPartialCompositeKey(MigrationReportObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
-
-	tosend := "{ \"customerID\" : \""+customerId+"\", \"message\" : \"Customer details updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	defer reportsIter.Close()
+	for reportsIter.HasNext() {
+		queryResponse, err := reportsIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, valueAsBytes := queryResponse.Key, queryResponse.Value
+		report := MigrationReport{}
+		err = json.Unmarshal(valueAsBytes, &report)
+		if err != nil {
+			return nil, errors.New("Malformed record stored for " + strconv.Quote(key) + ": " + err.Error())
+		}
+		reports = append(reports, report)
+	}
+	jsonResp, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": schemaVersion,
+		"progress": progress,
+		"reports": reports,
+	})
 	if err != nil {
 		return nil, err
-	} 
-
-	fmt.Println("Customer details updated succcessfully")
-	return nil, nil
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getMigrationStatus")
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
-// Write - update customer during redemption into chaincode state
+// getCallerIdentity - get the invoking identity and the role bound to it, to hand to an owner for bindRole
 // ============================================================================================================================
-func (t *ManageLPM) updateCustomerPurchase(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	fmt.Println("Updating Customer - purchase")
-	if len(args) != 20 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 20\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-	// set customerId
-	customerId := args[0]
-	customerAsBytes, err := stub.GetState(customerId)					//get the Customer for the specified customerId from chaincode state
+func (t *ManageLPM) getCallerIdentity(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getCallerIdentity")
+	caller, err := getCaller(stub)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + customerId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	res := Customer{}
-	res_trans1 := Transaction{}
- 	res_trans2 := Transaction{}
- 	res_Merchant := Merchant{}
-
-  	transactionId1 := args[4]
- 	transactionId2 := args[11]
-	json.Unmarshal(customerAsBytes, &res)
-	if res.CustomerID == customerId{
-		fmt.Println("Customer found with customerId : " + customerId)
-		fmt.Println(res);
-		res.WalletWorth = args[1]
-		res.MerchantsPointsCount = args[2]
-		res.MerchantsPointsWorth = args[3]
-		res_trans1.TransactionID = transactionId1
- 		res_trans1.TransactionDateTime = args[5]
- 		res_trans1.TransactionType = args[6]
- 		res_trans1.TransactionFrom = args[7]
- 		res_trans1.TransactionTo = args[8]
- 		res_trans1.Credit = args[9]
- 		res_trans1.Debit = args[10]
- 		res_trans1.CustomerID = customerId
- 		res_trans2.TransactionID = transactionId2
- 		res_trans2.TransactionDateTime = args[12]
- 		res_trans2.TransactionType = args[6]
- 		res_trans2.TransactionFrom = args[13]
- 		res_trans2.TransactionTo = args[14]
- 		res_trans2.Credit = args[15]
- 		res_trans2.Debit = args[16]
- 		res_trans2.CustomerID = customerId
- 		res_Merchant.MerchantID = args[17]
- 		res_Merchant.PurchaseBalance = args[18]
- 		res_Merchant.MerchantCU_date = args[19]
-	}else{
-		errMsg := "{ \"message\" : \""+ customerId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	jsonResp, err := json.Marshal(caller)
+	if err != nil {
+		return nil, err
 	}
-	
-	//build the Customer json string manually
-	customer_json := 	`{`+
-		`"customerId": "` + res.CustomerID + `" , `+
-		`"userName": "` + res.UserName + `" , `+
-		`"customerName": "` + res.CustomerName + `" , `+
-		`"walletWorth": "` + res.WalletWorth + `" , `+
-		`"merchantIDs": "` + res.MerchantIDs + `" , `+
-		`"merchantNames": "` + res.MerchantNames + `" , `+
-		`"merchantColors": "` + res.MerchantColors + `" , `+
-		`"merchantCurrencies": "` + res.MerchantCurrencies + `" , `+
-		`"merchantsPointsCount": "` + res.MerchantsPointsCount + `" , `+ 
-		`"merchantsPointsWorth": "` +  res.MerchantsPointsWorth + `" `+ 
-	`}`
-	err = stub.PutState(customerId, []byte(customer_json))							//store Customer with id as key
-	if err != nil {
-		return nil, err
-	}
-
-	// build the Transaction1 json string manually
- 	transaction_json1 := `{`+
- 		`"transactionId": "` + transactionId1 + `" , `+
- 		`"transactionDateTime": "` + res_trans1.TransactionDateTime + `" , `+
- 		`"transactionType": "` + res_trans1.TransactionType + `" , `+
- 		`"transactionFrom": "` + res_trans1.TransactionFrom + `" , `+ 
- 		`"transactionTo": "` + res_trans1.TransactionTo + `" , `+ 
- 		`"credit": "` + res_trans1.Credit + `" , `+ 
- 		`"debit": "` + res_trans1.Debit + `" , `+ 
- 		`"customerId": "` +  res_trans1.CustomerID + `" `+ 
-    `}`
-	err = stub.PutState(transactionId1, []byte(transaction_json1))					//store Transaction with id as key
-	if err != nil {
-		return nil, err
-	}
-
-	//get the Transaction index
-	transactionAsBytes1, err := stub.GetState(TransactionIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Transaction index")
-	}
-	var transactionIndex1 []string	
-	json.Unmarshal(transactionAsBytes1, &transactionIndex1)							//un stringify it aka JSON.parse()
-	
-	//append
-	transactionIndex1 = append(transactionIndex1, transactionId1)					//add Transaction transactionId to index list
-	
-	jsonAsBytes1, _ := json.Marshal(transactionIndex1)
-	fmt.Print("update customer jsonAsBytes1: ")
-	fmt.Println(jsonAsBytes1)
-	err = stub.PutState(TransactionIndexStr, jsonAsBytes1)							//store name of Transaction
-	if err != nil {
-		return nil, err
-	}
-
-	// build the Transaction2 json string manually
- 	transaction_json2 := `{`+
- 		`"transactionId": "` + transactionId2 + `" , `+
- 		`"transactionDateTime": "` + res_trans2.TransactionDateTime + `" , `+
- 		`"transactionType": "` + res_trans2.TransactionType + `" , `+
- 		`"transactionFrom": "` + res_trans2.TransactionFrom + `" , `+ 
- 		`"transactionTo": "` + res_trans2.TransactionTo + `" , `+ 
- 		`"credit": "` + res_trans2.Credit + `" , `+ 
- 		`"debit": "` + res_trans2.Debit + `" , `+ 
- 		`"customerId": "` +  res_trans2.CustomerID + `" `+ 
- 	`}`
- 	err = stub.PutState(transactionId2, []byte(transaction_json2))					//store Transaction with id as key
- 	if err != nil {
- 		return nil, err
- 	}
- 
- 	//get the Transaction index
- 	transactionAsBytes2, err := stub.GetState(TransactionIndexStr)
- 	if err != nil {
- 		return nil, errors.New("Failed to get Transaction index")
- 	}
- 	var transactionIndex2 []string	
- 	json.Unmarshal(transactionAsBytes2, &transactionIndex2)							//un stringify it aka JSON.parse()
- 	
- 	//append
- 	transactionIndex2 = append(transactionIndex2, transactionId2)					//add Transaction transactionId to index list
- 	jsonAsBytes2, _ := json.Marshal(transactionIndex2)
- 	fmt.Println(jsonAsBytes2)
- 	err = stub.PutState(TransactionIndexStr, jsonAsBytes2)							//store name of Transaction
-  	if err != nil {
-  		return nil, err
-  	}
-
-	// update the Merchant START
-	merchant_args := []string{res_Merchant.MerchantID, res_Merchant.PurchaseBalance, res_Merchant.MerchantCU_date}
-	t.updateMerchantsPurchaseBal(stub, merchant_args)
- 	// update the Merchant END
-
-	tosend := "{ \"customerID\" : \""+customerId+"\", \"message\" : \"Customer details updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
-	if err != nil {
-		return nil, err
-	} 
-
-	fmt.Println("Customer details updated succcessfully")
-	return nil, nil
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getCallerIdentity")
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
-// Write - update customer during transfer into chaincode state
+// getRoleBindings - get every role binding from chaincode state, keyed by identity
 // ============================================================================================================================
-func (t *ManageLPM) updateCustomerTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	fmt.Println("Updating Customer - transfer")
-	if len(args) != 21 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 21\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+func (t *ManageLPM) getRoleBindings(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getRoleBindings")
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
 	}
-	// set customerIds
-	customerId1 := args[0]
-	customerId2 := args[17]
-	res1 := Customer{}
-	res2 := Customer{}
-	res_trans1 := Transaction{}
- 	res_trans2 := Transaction{}
-  	transactionId1 := args[4]
- 	transactionId2 := args[11]
-	
-	customer1AsBytes, err := stub.GetState(customerId1)					//get the Customer for the specified customerId from chaincode state
+	bindings := map[string]RoleBinding{}
+	bindingsIter, err := stub.GetStateByPartialCompositeKey(RoleBindingObjectType, []string{})
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + customerId1 + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	json.Unmarshal(customer1AsBytes, &res1)
-	if res1.CustomerID == customerId1{
-		fmt.Println("Customer found with customerId1 : " + customerId1)
-		fmt.Println(res1);
-		res1.WalletWorth = args[1]
-		res1.MerchantsPointsCount = args[2]
-		res1.MerchantsPointsWorth = args[3]
-	}else{
-		errMsg := "{ \"message\" : \""+ customerId1+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+	defer bindingsIter.Close()
+	for bindingsIter.HasNext() {
+		queryResponse, err := bindingsIter.Next()
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
-	}
-	
-	res_trans1.TransactionID = transactionId1
-	res_trans1.TransactionDateTime = args[5]
-	res_trans1.TransactionType = args[6]
-	res_trans1.TransactionFrom = args[7]
-	res_trans1.TransactionTo = args[8]
-	res_trans1.Credit = args[9]
-	res_trans1.Debit = args[10]
-	res_trans1.CustomerID = customerId1
-	res_trans2.TransactionID = transactionId2
-	res_trans2.TransactionDateTime = args[12]
-	res_trans2.TransactionType = args[6]
-	res_trans2.TransactionFrom = args[13]
-	res_trans2.TransactionTo = args[14]
-	res_trans2.Credit = args[15]
-	res_trans2.Debit = args[16]
-	res_trans2.CustomerID = customerId2
-
-	customer2AsBytes, err := stub.GetState(customerId2)					//get the Customer for the specified customerId from chaincode state
-	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + customerId2 + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+		}
+		res := RoleBinding{}
+		err = json.Unmarshal(queryResponse.Value, &res)
 		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+			return nil, errors.New("Malformed record stored for " + strconv.Quote(queryResponse.Key) + ": " + err.Error())
+		}
+		bindings[res.Identity] = res
 	}
-	json.Unmarshal(customer2AsBytes, &res2)
-	if res2.CustomerID == customerId2{
-		fmt.Println("Customer found with customerId2 : " + customerId2)
-		fmt.Println(res2);
-		res2.WalletWorth = args[18]
-		res2.MerchantsPointsCount = args[19]
-		res2.MerchantsPointsWorth = args[20]
-	}else{
-		errMsg := "{ \"message\" : \""+ customerId2+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	jsonResp, err := json.Marshal(bindings)
+	if err != nil {
+		return nil, err
 	}
-
-	//build the Customer1 json string manually
-	customer1_json := 	`{`+
-		`"customerId": "` + res1.CustomerID + `" , `+
-		`"userName": "` + res1.UserName + `" , `+
-		`"customerName": "` + res1.CustomerName + `" , `+
-		`"walletWorth": "` + res1.WalletWorth + `" , `+
-		`"merchantIDs": "` + res1.MerchantIDs + `" , `+
-		`"merchantNames": "` + res1.MerchantNames + `" , `+
-		`"merchantColors": "` + res1.MerchantColors + `" , `+
-		`"merchantCurrencies": "` + res1.MerchantCurrencies + `" , `+
-		`"merchantsPointsCount": "` + res1.MerchantsPointsCount + `" , `+ 
-		`"merchantsPointsWorth": "` +  res1.MerchantsPointsWorth + `" `+ 
-	`}`
-	err = stub.PutState(customerId1, []byte(customer1_json))							//store Customer with id as key
-	if err != nil {
-		return nil, err
-	}
-
-	//build the Customer2 json string manually
-	customer2_json := 	`{`+
-		`"customerId": "` + res2.CustomerID + `" , `+
-		`"userName": "` + res2.UserName + `" , `+
-		`"customerName": "` + res2.CustomerName + `" , `+
-		`"walletWorth": "` + res2.WalletWorth + `" , `+
-		`"merchantIDs": "` + res2.MerchantIDs + `" , `+
-		`"merchantNames": "` + res2.MerchantNames + `" , `+
-		`"merchantColors": "` + res2.MerchantColors + `" , `+
-		`"merchantCurrencies": "` + res2.MerchantCurrencies + `" , `+
-		`"merchantsPointsCount": "` + res2.MerchantsPointsCount + `" , `+ 
-		`"merchantsPointsWorth": "` +  res2.MerchantsPointsWorth + `" `+ 
-	`}`
-	err = stub.PutState(customerId2, []byte(customer2_json))							//store Customer with id as key
-	if err != nil {
-		return nil, err
-	}
-
-	// build the Transaction1 json string manually
- 	transaction_json1 := `{`+
- 		`"transactionId": "` + transactionId1 + `" , `+
- 		`"transactionDateTime": "` + res_trans1.TransactionDateTime + `" , `+
- 		`"transactionType": "` + res_trans1.TransactionType + `" , `+
- 		`"transactionFrom": "` + res_trans1.TransactionFrom + `" , `+ 
- 		`"transactionTo": "` + res_trans1.TransactionTo + `" , `+ 
- 		`"credit": "` + res_trans1.Credit + `" , `+ 
- 		`"debit": "` + res_trans1.Debit + `" , `+ 
- 		`"customerId": "` +  res_trans1.CustomerID + `" `+ 
-    `}`
-	err = stub.PutState(transactionId1, []byte(transaction_json1))					//store Transaction with id as key
-	if err != nil {
-		return nil, err
-	}
-
-	//get the Transaction index
-	transactionAsBytes1, err := stub.GetState(TransactionIndexStr)
-	if err != nil {
-		return nil, errors.New("Failed to get Transaction index")
-	}
-	var transactionIndex1 []string	
-	json.Unmarshal(transactionAsBytes1, &transactionIndex1)							//un stringify it aka JSON.parse()
-	
-	//append
-	transactionIndex1 = append(transactionIndex1, transactionId1)					//add Transaction transactionId to index list
-	
-	jsonAsBytes1, _ := json.Marshal(transactionIndex1)
-	fmt.Print("update customer jsonAsBytes1: ")
-	fmt.Println(jsonAsBytes1)
-	err = stub.PutState(TransactionIndexStr, jsonAsBytes1)							//store name of Transaction
-	if err != nil {
-		return nil, err
-	}
-
-	// build the Transaction2 json string manually
- 	transaction_json2 := `{`+
- 		`"transactionId": "` + transactionId2 + `" , `+
- 		`"transactionDateTime": "` + res_trans2.TransactionDateTime + `" , `+
- 		`"transactionType": "` + res_trans2.TransactionType + `" , `+
- 		`"transactionFrom": "` + res_trans2.TransactionFrom + `" , `+ 
- 		`"transactionTo": "` + res_trans2.TransactionTo + `" , `+ 
- 		`"credit": "` + res_trans2.Credit + `" , `+ 
- 		`"debit": "` + res_trans2.Debit + `" , `+ 
- 		`"customerId": "` +  res_trans2.CustomerID + `" `+ 
- 	`}`
- 	err = stub.PutState(transactionId2, []byte(transaction_json2))					//store Transaction with id as key
- 	if err != nil {
- 		return nil, err
- 	}
- 
- 	//get the Transaction index
- 	transactionAsBytes2, err := stub.GetState(TransactionIndexStr)
- 	if err != nil {
- 		return nil, errors.New("Failed to get Transaction index")
- 	}
- 	var transactionIndex2 []string	
- 	json.Unmarshal(transactionAsBytes2, &transactionIndex2)							//un stringify it aka JSON.parse()
- 	
- 	//append
- 	transactionIndex2 = append(transactionIndex2, transactionId2)					//add Transaction transactionId to index list
- 	jsonAsBytes2, _ := json.Marshal(transactionIndex2)
- 	fmt.Println(jsonAsBytes2)
- 	err = stub.PutState(TransactionIndexStr, jsonAsBytes2)							//store name of Transaction
-  	if err != nil {
-  		return nil, err
-  	}
-
-	tosend := "{ \"customerID\" : \""+customerId1+"\", \"message\" : \"Customer details updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
-	if err != nil {
-		return nil, err
-	} 
-
-	fmt.Println("Customer details updated succcessfully for transfer")
-	return nil, nil
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end getRoleBindings")
+	return jsonResp, nil											//send it onward
 }
 // ============================================================================================================================
-// Delete - remove a Customer and all his transactions from chain
+// create Customer - create a new Customer, store into chaincode state
 // ============================================================================================================================
-func (t *ManageLPM) deleteCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'customerId' as an argument\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+func (t *ManageLPM) createCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 13 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 13")
 	}
-	// set customerId
+	fmt.Println("start createCustomer")
 	customerId := args[0]
-	err := stub.DelState(customerId)						//remove the Customer from chaincode
+	userName := args[1]
+	customerName := args[2]
+	merchantID := args[4]
+	merchantName := args[5]
+	merchantColor := args[6]
+	merchantCurrency, err := parseCurrency(args[7])
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return errorResponse(ErrInvalidArgument, "Invalid merchantCurrency: " + err.Error())
 	}
-
-	//get the Customer index
-	customerAsBytes, err := stub.GetState(CustomerIndexStr)
+	walletWorth, err := ParseMoney(args[3], DefaultCurrency)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get Customer index\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return errorResponse(ErrInvalidArgument, "Invalid walletWorth: " + err.Error())
 	}
-	var customerIndex []string
-	json.Unmarshal(customerAsBytes, &customerIndex)								//un stringify it aka JSON.parse()
-	//remove marble from index
-	for i,val := range customerIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for " + customerId)
-		if val == customerId{															//find the correct Customer
-			fmt.Println("found Customer with matching customerId")
-			customerIndex = append(customerIndex[:i], customerIndex[i+1:]...)			//remove it
-			for x:= range customerIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + customerIndex[x])
-			}
-			break
-		}
+	merchantsPointsCount, err := ParsePoints(args[8])
+	if err != nil || merchantsPointsCount < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid merchantsPointsCount " + args[8])
 	}
-	jsonAsBytes, _ := json.Marshal(customerIndex)									//save new index
-	err = stub.PutState(CustomerIndexStr, jsonAsBytes)
-
-	tosend := "{ \"customerID\" : \""+customerId+"\", \"message\" : \"Customer deleted succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	merchantsPointsWorth, err := ParseMoney(args[9], merchantCurrency)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid merchantsPointsWorth: " + args[9])
+	}
+	externalReference := args[10]
+	transactionType, err := checkTransactionType(args[12], OnboardingTransactionType)	//args[11], the client's date, is superseded by the proposal timestamp
 	if err != nil {
 		return nil, err
-	} 
-
-	// TODO:: All transactions related to customer should be deleted.
-
-	fmt.Println("Customer deleted succcessfully")
-	return nil, nil
-}
-// ============================================================================================================================
-// create Merchant - create a new Merchant, store into chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) createMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	if len(args) != 10 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 10\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
 	}
-	fmt.Println("start createMerchant")
-	merchantID := args[0]
-	merchantUserName := args[1]
-	merchantName := args[2]
-	merchantIndustry := args[3]
-	industryColor := args[4]
-	pointsPerDollarSpent := args[5]
-	exchangeRate := args[6]
-	purchaseBalance := args[7]
-	merchantCurrency := args[8]
-	merchantCU_date := args[9]
-	merchantAsBytes, err := stub.GetState(merchantID)
-	if err != nil {
-		return nil, errors.New("Failed to get Merchant merchantID")
+	caller, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleMerchant, EntityID: merchantID})
+	if err != nil {
+		return nil, err
 	}
-	res := Merchant{}
-	json.Unmarshal(merchantAsBytes, &res)
-	fmt.Print("res: ")
-	fmt.Println(res)
-	if res.MerchantID == merchantID{
-		fmt.Println("This Merchant arleady exists: " + merchantID)
-		fmt.Println(res);
-		errMsg := "{ \"message\" : \"This Merchant arleady exists\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil				//all stop a Merchant by this name exists
+	// only the owner may open a customer with a balance, a merchant's customers start from nothing and earn their points
+	if caller.Role != RoleOwner && (walletWorth.Units != 0 || merchantsPointsCount != 0 || merchantsPointsWorth.Units != 0) {
+		return errorResponse(ErrPermissionDenied, caller.Identity + " cannot open " + customerId + " with a balance, only the owner can")
+	}
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "createCustomer", args, externalReference)
+	if err != nil || replayed {
+		return payload, err
 	}
-	//build the Merchant json string manually
-	merchant_json := 	`{`+
-		`"merchantId": "` + merchantID + `" , `+
-		`"merchantUserName": "` + merchantUserName + `" , `+
-		`"merchantName": "` + merchantName + `" , `+
-		`"merchantIndustry": "` + merchantIndustry + `" , `+ 
-		`"industryColor": "` + industryColor + `" , `+
-		`"pointsPerDollarSpent": "` + pointsPerDollarSpent + `" , `+ 
-		`"exchangeRate": "` + exchangeRate + `" , `+ 
-		`"purchaseBalance": "` + purchaseBalance + `" , `+
-		`"merchantCurrency": "` + merchantCurrency + `" , `+
-		`"merchantCU_date": "` + merchantCU_date + `" `+ 
-	`}`
-	fmt.Println("merchant_json: " + merchant_json)
-	fmt.Print("merchant_json in bytes array: ")
-	fmt.Println([]byte(merchant_json))
-	err = stub.PutState(merchantID, []byte(merchant_json))		//store Merchant with merchantId as key
+	transactionDateTime, err := getTxDateTime(stub)
 	if err != nil {
 		return nil, err
 	}
-	//get the Merchant index
-	merchantIndexAsBytes, err := stub.GetState(MerchantIndexStr)
+
+	_, found, err := getCustomer(stub, customerId)
 	if err != nil {
-		return nil, errors.New("Failed to get Merchant index")
+		return nil, err
 	}
-	var merchantIndex []string
-	json.Unmarshal(merchantIndexAsBytes, &merchantIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	merchantIndex = append(merchantIndex, merchantID)									//add Merchant merchantID to index list
-	fmt.Println("! Merchant index after appending merchantID: ", merchantIndex)
-	jsonAsBytes, _ := json.Marshal(merchantIndex)
-	fmt.Print("jsonAsBytes: ")
-	fmt.Println(jsonAsBytes)
-	err = stub.PutState(MerchantIndexStr, jsonAsBytes)						//store name of Merchant
+	if found {
+		return errorResponse(ErrAlreadyExists, "This Customer arleady exists")				//all stop a Customer by this name exists
+	}
+	res_Merchant, found, err := getMerchant(stub, merchantID)
 	if err != nil {
 		return nil, err
 	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantID + " Not Found.")
+	}
+	if !res_Merchant.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantID + " is " + res_Merchant.Status + ", it cannot take new customers")
+	}
+	if res_Merchant.MerchantCurrency != merchantCurrency {
+		return errorResponse(ErrInvalidArgument, merchantID + " keeps its points in " + res_Merchant.MerchantCurrency + ", not " + merchantCurrency)
+	}
 
-	tosend := "{ \"merchantID\" : \""+merchantID+"\", \"message\" : \"Merchant created succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	res := Customer{}
+	res.CustomerID = customerId
+	res.UserName = userName
+	res.CustomerName = customerName
+	res.WalletWorth = walletWorth
+	res.Status = StatusActive
+	res.Holdings = []MerchantHolding{
+		MerchantHolding{
+			MerchantID: merchantID,
+			MerchantName: merchantName,
+			MerchantColor: merchantColor,
+			Currency: merchantCurrency,
+			Points: merchantsPointsCount,
+			Worth: merchantsPointsWorth,
+			JoinedDate: transactionDateTime,
+		},
+	}
+	err = putCustomer(stub, res)											//store Customer with customerId as key
 	if err != nil {
 		return nil, err
-	} 
-
-	fmt.Println("end createMerchant")
-	return nil, nil
-}
-// ============================================================================================================================
-// Write - update merchant into chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) updateMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	fmt.Println("Updating Merchant")
-	if len(args) != 9 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 9\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
 	}
-	// set merchantId
-	merchantId := args[0]
-	merchantAsBytes, err := stub.GetState(merchantId)									//get the Merchant for the specified merchant from chaincode state
+	err = indexCustomerHoldings(stub, res)									//list the Customer under its Merchant
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + merchantId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-	res := Merchant{}
-	json.Unmarshal(merchantAsBytes, &res)
-	if res.MerchantID == merchantId{
-		fmt.Println("Merchant found with merchantId : " + merchantId)
-		//fmt.Println(res);
-		res.MerchantUserName = args[1]
-		res.MerchantName = args[2]
-		res.MerchantIndustry = args[3]
-		res.IndustryColor = args[4]
-		res.PointsPerDollarSpent = args[5]
-		res.ExchangeRate = args[6]
-		res.PurchaseBalance = args[7]
-		res.MerchantCurrency = args[8]
-		res.MerchantCU_date = args[9]
-	}else{
-		errMsg := "{ \"message\" : \""+ merchantId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	
-	//build the Merchant json string manually
-	merchant := 	`{`+
-		`"merchantID": "` + res.MerchantID + `" , `+
-		`"merchantUserName": "` + res.MerchantUserName + `" , `+
-		`"merchantName": "` + res.MerchantName + `" , `+
-		`"merchantIndustry": "` + res.MerchantIndustry + `" , `+ 
-		`"industryColor": "` + res.IndustryColor + `" , `+
-		`"pointsPerDollarSpent": "` + res.PointsPerDollarSpent + `" , `+
-		`"exchangeRate": "` + res.ExchangeRate + `" , `+ 
-		`"purchaseBalance": "` + res.PurchaseBalance + `" , `+ 
-		`"merchantCurrency": "` + res.MerchantCurrency + `" , `+
-		`"merchantCU_date": "` +  res.MerchantCU_date + `" `+ 
-		`}`
-	err = stub.PutState(merchantId, []byte(merchant))						//store Merchant with id as key
+
+	res_trans := Transaction{}
+	res_trans.TransactionID = newTransactionId(stub, 0)
+	res_trans.TransactionDateTime = transactionDateTime
+	res_trans.TransactionType = transactionType
+	res_trans.TransactionFrom = merchantName
+	res_trans.TransactionTo = userName
+	res_trans.Credit = merchantsPointsCount
+	res_trans.Debit = 0
+	res_trans.CustomerID = customerId
+	res_trans.MerchantID = merchantID
+	res_trans.ExternalReference = externalReference
+	err = putTransaction(stub, res_trans)									//store Transaction with id as key
 	if err != nil {
 		return nil, err
 	}
 
-	tosend := "{ \"merchantId\" : \""+merchantId+"\", \"message\" : \"Merchant details updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal([]Transaction{res_trans})
+	if err != nil {
+		return nil, err
+	}
+	event := Event{CustomerID: customerId, Message: "Customer created succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "createCustomer", args, payload, event, externalReference)
 	if err != nil {
 		return nil, err
-	} 
+	}
 
-	fmt.Println("Merchant details updated succcessfully")
-	return nil, nil
+	err = setEvent(stub, "evtsender", event)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end createCustomer")
+	return payload, nil
 }
 // ============================================================================================================================
-// Write - update merchant's purchase balance into chaincode state
+// updateCustomerAccumulation - pre-1.0 accumulation that wrote the balances the client computed, rejected as deprecated
 // ============================================================================================================================
-func (t *ManageLPM) updateMerchantsPurchaseBal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *ManageLPM) updateCustomerAccumulation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return rejectDeprecated("updateCustomerAccumulation", args, 11, "updateCustomerAccumulationSC", "'customerId', 'merchantId', 'amountSpent', 'transactionId' and 'transactionType'")
+}
+// ============================================================================================================================
+// updateCustomerPurchase - pre-1.0 purchase that wrote the balances the client computed, rejected as deprecated
+// ============================================================================================================================
+func (t *ManageLPM) updateCustomerPurchase(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return rejectDeprecated("updateCustomerPurchase", args, 20, "updateCustomerPurchaseSC", "'customerId', 'merchantId', 'purchaseAmount', 'customerTransactionId', 'merchantTransactionId' and 'transactionType'")
+}
+// ============================================================================================================================
+// updateCustomerTransfer - pre-1.0 transfer that wrote the balances the client computed, rejected as deprecated
+// ============================================================================================================================
+func (t *ManageLPM) updateCustomerTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return rejectDeprecated("updateCustomerTransfer", args, 21, "updateCustomerTransferSC", "'fromCustomerId', 'toCustomerId', 'merchantId', 'points', 'fromTransactionId', 'toTransactionId' and 'transactionType'")
+}
+// ============================================================================================================================
+// rejectDeprecated - fail a call to a pre-1.0 function, naming the function and arguments that replace it. The balances it took
+// from the client are never written, and it carries no amount or merchant the ledger could compute them from -- Internal Function
+// ============================================================================================================================
+func rejectDeprecated(function string, args []string, legacyArgs int, replacement string, replacementArgs string) ([]byte, error) {
+	if len(args) != legacyArgs {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting " + strconv.Itoa(legacyArgs) + ", or call " + replacement + " with " + replacementArgs + " as arguments")
+	}
+	return errorResponse(ErrDeprecated, function + " with client computed balances is deprecated, call " + replacement + " with " + replacementArgs + " as arguments")
+}
+// ============================================================================================================================
+// Write - update customer during accumulation into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateCustomerAccumulationSC(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
-	fmt.Println("Updating Merchant - Purchase Balance")
-	if len(args) != 3 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 3\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	fmt.Println("Updating Customer - accumulation")
+	if len(args) != 5 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId', 'merchantId', 'amountSpent', 'transactionId' and 'transactionType' as arguments")
 	}
-	// set merchantId
-	merchantId := args[0]
-	newPurchaseBal := args[1]
-	merchantAsBytes, err := stub.GetState(merchantId)									//get the Merchant for the specified merchant from chaincode state
+	// set customerId
+	customerId := args[0]
+	merchantId := args[1]
+	externalReference := args[3]
+	transactionType, err := checkTransactionType(args[4], AccumulationTransactionType)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + merchantId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	res := Merchant{}
-	json.Unmarshal(merchantAsBytes, &res)
-	if res.MerchantID == merchantId{
-		fmt.Println("Merchant found with merchantId : " + merchantId)
-		fmt.Println("Merchants old purchaseBalance : " + res.PurchaseBalance)
-		fmt.Println("Merchants new purchaseBalance : " + newPurchaseBal)
-		res.PurchaseBalance += newPurchaseBal 
-		res.MerchantCU_date = args[2]
-	}else{
-		errMsg := "{ \"message\" : \""+ merchantId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	// points are issued by the merchant the customer spent with, how many is worked out here from what was spent
+	caller, err := requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
 	}
-	
-	//build the Merchant json string manually
-	merchant_json := 	`{`+
-		`"merchantID": "` + res.MerchantID + `" , `+
-		`"merchantUserName": "` + res.MerchantUserName + `" , `+
-		`"merchantName": "` + res.MerchantName + `" , `+
-		`"merchantIndustry": "` + res.MerchantIndustry + `" , `+ 
-		`"industryColor": "` + res.IndustryColor + `" , `+
-		`"pointsPerDollarSpent": "` + res.PointsPerDollarSpent + `" , `+
-		`"exchangeRate": "` + res.ExchangeRate + `" , `+ 
-		`"purchaseBalance": "` + res.PurchaseBalance + `" , `+ 
-		`"merchantCurrency": "` + res.MerchantCurrency + `" , `+
-		`"merchantCU_date": "` +  res.MerchantCU_date + `" `+ 
-		`}`
-
-	fmt.Println("merchant_json in updateMerchantsPurchaseBal::" + merchant_json)
-		
-	err = stub.PutState(merchantId, []byte(merchant_json))						//store Merchant with id as key
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "updateCustomerAccumulationSC", args, externalReference)
+	if err != nil || replayed {
+		return payload, err
+	}
+	res, found, err := getCustomer(stub, customerId)					//get the Customer for the specified customerId from chaincode state
 	if err != nil {
 		return nil, err
 	}
+	if !found {
+		return errorResponse(ErrNotFound, customerId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId + " is " + res.Status)
+	}
+	holdingIndex := getHoldingIndex(res, merchantId)
+	if holdingIndex < 0 {
+		return errorResponse(ErrFailedPrecondition, customerId + " is not associated with " + merchantId)
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res_Merchant.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res_Merchant.Status + ", its points can no longer be accrued")
+	}
+	if res_Merchant.PointsPerDollarSpent <= 0 {
+		return errorResponse(ErrFailedPrecondition, merchantId + " has no pointsPerDollarSpent set")
+	}
+	fmt.Println("Customer found with customerId : " + customerId)
+	fmt.Println(res);
 
-	tosend := "{ \"merchantId\" : \""+merchantId+"\", \"message\" : \"Merchant purchase balance details updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	// Calculation, an amountSpent in another currency is converted at the rate in effect at the proposal timestamp
+	amountSpent, err := parseAmount(args[2], res_Merchant.MerchantCurrency)
+	if err != nil || amountSpent.Units <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid amountSpent " + args[2])
+	}
+	amountSpent, err = convertMoney(stub, amountSpent, res_Merchant.MerchantCurrency, transactionDateTime)
 	if err != nil {
 		return nil, err
-	} 
+	}
+	pointsValue, err := moneyPoints(amountSpent, res_Merchant.PointsPerDollarSpent)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid amountSpent " + args[2] + ": " + err.Error())
+	}
+	if pointsValue <= 0 {
+		return errorResponse(ErrInvalidArgument, "Spending " + args[2] + " would credit no points with " + merchantId)
+	}
+	holding := &res.Holdings[holdingIndex]
+	worthBefore := holding.Worth
 
-	fmt.Println("Merchant purchase balance updated succcessfully")
-	return nil, nil
-}
-// ============================================================================================================================
-// Write - update merchant's PPDS into chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) updateMerchantsPPDS(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	fmt.Println("Updating Merchant - Points Per Dolllar Spent")
-	if len(args) != 3 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 3\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	res_trans := Transaction{}
+	res_trans.TransactionID = newTransactionId(stub, 0)
+	res_trans.TransactionDateTime = transactionDateTime
+	res_trans.TransactionType = transactionType
+	res_trans.TransactionFrom = res_Merchant.MerchantName
+	res_trans.TransactionTo = res.UserName
+	res_trans.Credit = Points(pointsValue)
+	res_trans.Debit = 0
+	res_trans.CustomerID = customerId
+	res_trans.MerchantID = merchantId
+	res_trans.ExternalReference = externalReference
+	res_trans.Amount = &amountSpent
+
+	// the tier reached with the issuing Merchant multiplies the points credited to the holding and its active campaigns
+	// add their bonuses
+	var transactions []Transaction
+	credit := res_trans.Credit
+	previousTier := holding.Tier
+	res_trans.Credit, err = accrueTier(stub, res_Merchant, holding, res_trans.Credit, amountSpent, transactionDateTime)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Failed to apply the tier of " + customerId + ": " + err.Error())
 	}
-	// set merchantId
-	merchantId := args[0]
-	newPPDS := args[1]
-	merchantAsBytes, err := stub.GetState(merchantId)									//get the Merchant for the specified merchant from chaincode state
+	if holding.Tier != previousTier {
+		res_tier := newTierChange(stub, *holding, previousTier, 1 + len(transactions), transactionDateTime)
+		res_tier.CustomerID = customerId
+		res_tier.ExternalReference = externalReference
+		transactions = append(transactions, res_tier)
+	}
+	bonuses, err := applyCampaigns(stub, res_Merchant, holding, credit, amountSpent, transactionDateTime)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + merchantId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	res := Merchant{}
-	json.Unmarshal(merchantAsBytes, &res)
-	if res.MerchantID == merchantId{
-		fmt.Println("Merchant found with merchantId : " + merchantId)
-		fmt.Println("Merchants old pointsPerDollarSpent : " + res.PointsPerDollarSpent)
-		fmt.Println("Merchants new pointsPerDollarSpent : " + newPPDS)
-		res.PointsPerDollarSpent = newPPDS 
-		res.MerchantCU_date = args[2]
-	}else{
-		errMsg := "{ \"message\" : \""+ merchantId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
+	for _, res_bonus := range bonuses {
+		res_bonus.TransactionID = newTransactionId(stub, 1 + len(transactions))
+		res_bonus.TransactionTo = res.UserName
+		res_bonus.CustomerID = customerId
+		res_bonus.ExternalReference = externalReference
+		res_bonus.LinkedTransactionID = res_trans.TransactionID
+		transactions = append(transactions, res_bonus)
+	}
+	holding.Accruals++
+	transactions = append([]Transaction{res_trans}, transactions...)
+	err = moveWalletWorth(stub, &res, worthBefore, holding.Worth, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putCustomer(stub, res)										//store Customer with id as key
+	if err != nil {
+		return nil, err
+	}
+	for _, res_trans := range transactions {
+		err = putTransaction(stub, res_trans)							//store Transaction with id as key
 		if err != nil {
 			return nil, err
-		} 
-		return nil, nil
+		}
 	}
-	
-	//build the Merchant json string manually
-	merchant_json := 	`{`+
-		`"merchantID": "` + res.MerchantID + `" , `+
-		`"merchantUserName": "` + res.MerchantUserName + `" , `+
-		`"merchantName": "` + res.MerchantName + `" , `+
-		`"merchantIndustry": "` + res.MerchantIndustry + `" , `+ 
-		`"industryColor": "` + res.IndustryColor + `" , `+
-		`"pointsPerDollarSpent": "` + res.PointsPerDollarSpent + `" , `+
-		`"exchangeRate": "` + res.ExchangeRate + `" , `+ 
-		`"purchaseBalance": "` + res.PurchaseBalance + `" , `+ 
-		`"merchantCurrency": "` + res.MerchantCurrency + `" , `+
-		`"merchantCU_date": "` +  res.MerchantCU_date + `" `+ 
-		`}`
 
-	fmt.Println("merchant_json in updateMerchantsPPDS::" + merchant_json)
-		
-	err = stub.PutState(merchantId, []byte(merchant_json))						//store Merchant with id as key
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal(transactions)
+	if err != nil {
+		return nil, err
+	}
+	event := Event{CustomerID: customerId, Message: "Customer details updated succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "updateCustomerAccumulationSC", args, payload, event, externalReference)
 	if err != nil {
 		return nil, err
 	}
 
-	tosend := "{ \"merchantId\" : \""+merchantId+"\", \"message\" : \"Merchant points per dollar spent updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = setEvent(stub, "evtsender", event)
 	if err != nil {
 		return nil, err
-	} 
+	}
 
-	fmt.Println("Merchant points per dollar spent updated succcessfully")
-	return nil, nil
+	fmt.Println("Customer details updated succcessfully")
+	return payload, nil
 }
 // ============================================================================================================================
-// Write - update merchant's Exchange Rate into chaincode state
+// Write - update customer during redemption into chaincode state
 // ============================================================================================================================
-func (t *ManageLPM) updateMerchantsExchangeRate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *ManageLPM) updateCustomerPurchaseSC(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
-	fmt.Println("Updating Merchant - ExchangeRate")
-	if len(args) != 3 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 3\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	fmt.Println("Updating Customer - purchase")
+	if len(args) != 6 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId', 'merchantId', 'purchaseAmount', 'customerTransactionId', 'merchantTransactionId' and 'transactionType' as arguments")
 	}
-	// set merchantId
-	merchantId := args[0]
-	newExchangeRate := args[1]
-	merchantAsBytes, err := stub.GetState(merchantId)				//get the Merchant for the specified merchant from chaincode state
+	// set customerId
+	customerId := args[0]
+	merchantId := args[1]
+	externalReference1 := args[3]
+	externalReference2 := args[4]
+	if externalReference1 != "" && externalReference1 == externalReference2 {
+		return errorResponse(ErrInvalidArgument, "Both legs of a purchase cannot use externalReference " + externalReference1)
+	}
+	transactionType, err := checkTransactionType(args[5], PurchaseTransactionType)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + merchantId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-	res := Merchant{}
-	json.Unmarshal(merchantAsBytes, &res)
-	if res.MerchantID == merchantId{
-		fmt.Println("Merchant found with merchantId : " + merchantId)
-		fmt.Println("Merchants old exchangeRate : " + res.ExchangeRate)
-		fmt.Println("Merchants new exchangeRate : " + newExchangeRate)
-		res.ExchangeRate = newExchangeRate
-		res.MerchantCU_date = args[2]
-	}else{
-		errMsg := "{ \"message\" : \""+ merchantId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	// the customer spends its own points, or the merchant it is buying from spends them for it
+	caller, err := requireRole(stub, RoleBinding{Role: RoleCustomer, EntityID: customerId}, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
 	}
-	
-	//build the Merchant json string manually
-	merchant_json := 	`{`+
-		`"merchantID": "` + res.MerchantID + `" , `+
-		`"merchantUserName": "` + res.MerchantUserName + `" , `+
-		`"merchantName": "` + res.MerchantName + `" , `+
-		`"merchantIndustry": "` + res.MerchantIndustry + `" , `+ 
-		`"industryColor": "` + res.IndustryColor + `" , `+
-		`"pointsPerDollarSpent": "` + res.PointsPerDollarSpent + `" , `+
-		`"exchangeRate": "` + res.ExchangeRate + `" , `+ 
-		`"purchaseBalance": "` + res.PurchaseBalance + `" , `+ 
-		`"merchantCurrency": "` + res.MerchantCurrency + `" , `+
-		`"merchantCU_date": "` +  res.MerchantCU_date + `" `+ 
-		`}`
-
-	fmt.Println("merchant_json in updateMerchantsExchangeRate::" + merchant_json)
-		
-	err = stub.PutState(merchantId, []byte(merchant_json))						//store Merchant with id as key
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "updateCustomerPurchaseSC", args, externalReference1, externalReference2)
+	if err != nil || replayed {
+		return payload, err
+	}
+	res, found, err := getCustomer(stub, customerId)					//get the Customer for the specified customerId from chaincode state
 	if err != nil {
 		return nil, err
 	}
-
-	tosend := "{ \"merchantId\" : \""+merchantId+"\", \"message\" : \"Merchant exchange rate updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	if !found {
+		return errorResponse(ErrNotFound, customerId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId + " is " + res.Status)
+	}
+	holdingIndex := getHoldingIndex(res, merchantId)
+	if holdingIndex < 0 {
+		return errorResponse(ErrFailedPrecondition, customerId + " is not associated with " + merchantId)
+	}
+	transactionDateTime, err := getTxDateTime(stub)
 	if err != nil {
 		return nil, err
-	} 
+	}
+	fmt.Println("Customer found with customerId : " + customerId)
+	fmt.Println(res);
 
-	fmt.Println("Merchant exchange rate updated succcessfully")
-	return nil, nil
-}
-// ============================================================================================================================
-// Delete - remove a merchant from chain
-// ============================================================================================================================
-func (t *ManageLPM) deleteMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'merchantId' as an argument\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	// the Merchant is checked and updated before anything is written, a missing merchant fails the whole purchase
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
 	}
-	// set merchantId
-	merchantId := args[0]
-	err := stub.DelState(merchantId)													//remove the Merchant from chaincode
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res_Merchant.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res_Merchant.Status)
+	}
+	if res_Merchant.ExchangeRate <= 0 {
+		return errorResponse(ErrFailedPrecondition, merchantId + " has no exchangeRate set")
+	}
+	// Calculation, a purchaseAmount in another currency is converted at the rate in effect at the proposal timestamp
+	purchaseAmount, err := parseAmount(args[2], res_Merchant.MerchantCurrency)
+	if err != nil || purchaseAmount.Units <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid purchaseAmount " + args[2])
+	}
+	purchaseAmount, err = convertMoney(stub, purchaseAmount, res_Merchant.MerchantCurrency, transactionDateTime)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, err
 	}
-
-	//get the Merchant index
-	merchantAsBytes, err := stub.GetState(MerchantIndexStr)
+	// rounded up so the points cover the amount
+	pointsValue, err := moneyPoints(purchaseAmount, res_Merchant.ExchangeRate)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get Merchant index\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return errorResponse(ErrInvalidArgument, "Invalid purchaseAmount " + args[2] + ": " + err.Error())
 	}
-	var merchantIndex []string
-	json.Unmarshal(merchantAsBytes, &merchantIndex)								//un stringify it aka JSON.parse()
-	//remove marble from index
-	for i,val := range merchantIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for " + merchantId)
-		if val == merchantId{															//find the correct Merchant
-			fmt.Println("found Merchant with matching merchantId")
-			merchantIndex = append(merchantIndex[:i], merchantIndex[i+1:]...)			//remove it
-			for x:= range merchantIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + merchantIndex[x])
-			}
-			break
-		}
+	covered, err := pointsWorth(Points(pointsValue), res_Merchant.ExchangeRate, res_Merchant.MerchantCurrency)
+	if err != nil {
+		return nil, err
 	}
-	jsonAsBytes, _ := json.Marshal(merchantIndex)									//save new index
-	err = stub.PutState(MerchantIndexStr, jsonAsBytes)
-
-	tosend := "{ \"merchantID\" : \""+merchantId+"\", \"message\" : \"Merchant deleted succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	if covered.Units < purchaseAmount.Units {
+		pointsValue++
+	}
+	holding := &res.Holdings[holdingIndex]
+	worthBefore := holding.Worth
+	err = debitHolding(holding, Points(pointsValue), customerId)
+	if err != nil {
+		return nil, err
+	}
+	err = moveWalletWorth(stub, &res, worthBefore, holding.Worth, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	err = addPurchaseBalance(&res_Merchant, purchaseAmount, transactionDateTime)
 	if err != nil {
 		return nil, err
-	} 
+	}
 
-	fmt.Println("Merchant deleted succcessfully")
-	return nil, nil
-}
-// ============================================================================================================================
-// create Owner - create a Owner, store into chaincode state
-// ============================================================================================================================
-func (t *ManageLPM) createOwner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	if len(args) != 3 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 3\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	// Transaction1 debits the customer, Transaction2 records the Merchant taking the points as payment
+	res_trans1 := Transaction{}
+	res_trans1.TransactionID = newTransactionId(stub, 0)
+	res_trans1.TransactionDateTime = transactionDateTime
+	res_trans1.TransactionType = transactionType
+	res_trans1.TransactionFrom = res.UserName
+	res_trans1.TransactionTo = res_Merchant.MerchantName
+	res_trans1.Credit = 0
+	res_trans1.Debit = Points(pointsValue)
+	res_trans1.CustomerID = customerId
+	res_trans1.MerchantID = merchantId
+	res_trans1.ExternalReference = externalReference1
+	res_trans1.Amount = &purchaseAmount
+
+	res_trans2 := Transaction{}
+	res_trans2.TransactionID = newTransactionId(stub, 1)
+	res_trans2.TransactionDateTime = transactionDateTime
+	res_trans2.TransactionType = transactionType
+	res_trans2.TransactionFrom = res.UserName
+	res_trans2.TransactionTo = res_Merchant.MerchantName
+	res_trans2.Credit = Points(pointsValue)
+	res_trans2.Debit = 0
+	res_trans2.CustomerID = customerId
+	res_trans2.MerchantID = merchantId
+	res_trans2.ExternalReference = externalReference2
+	res_trans2.LinkedTransactionID = res_trans1.TransactionID
+	res_trans2.Amount = &purchaseAmount
+
+	err = putMerchant(stub, res_Merchant)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("start createOwner")
-	ownerId := args[0]
-	ownerUserName := args[1]
-	ownerName := args[2]
-	
-	ownerAsBytes, err := stub.GetState(ownerId)
+	err = putCustomer(stub, res)										//store Customer with id as key
 	if err != nil {
-		return nil, errors.New("Failed to get Owner ownerID")
+		return nil, err
 	}
-	res := Owner{}
-	json.Unmarshal(ownerAsBytes, &res)
-	if res.OwnerID == ownerId{
-		errMsg := "{ \"message\" : \"This Owner arleady exists\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil				//all stop a Owner by this name exists
+	err = putTransaction(stub, res_trans1)								//store Transaction with id as key
+	if err != nil {
+		return nil, err
 	}
-	
-	//build the Owner json string manually
-	owner_json := 	`{`+
-		`"ownerId": "` + ownerId + `" , `+
-		`"ownerName": "` + ownerName + `" , `+
-		`"ownerUserName": "` +  ownerUserName + `" `+
-	`}`
-	fmt.Println("owner_json: " + owner_json)
-	fmt.Print("owner_json in bytes array: ")
-	fmt.Println([]byte(owner_json))
-	err = stub.PutState(ownerId, []byte(owner_json))									//store Owner with ownerId as key
+	err = putTransaction(stub, res_trans2)								//store Transaction with id as key
 	if err != nil {
 		return nil, err
 	}
-	//get the Owner index
-	ownerIndexAsBytes, err := stub.GetState(OwnerIndexStr)
+
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal([]Transaction{res_trans1, res_trans2})
 	if err != nil {
-		return nil, errors.New("Failed to get Owner index")
+		return nil, err
 	}
-	var ownerIndex []string
-	json.Unmarshal(ownerIndexAsBytes, &ownerIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	ownerIndex = append(ownerIndex, ownerId)									//add Owner ownerID to index list
-	
-	jsonAsBytes, _ := json.Marshal(ownerIndex)
-	fmt.Print("jsonAsBytes: ")
-	fmt.Println(jsonAsBytes)
-	err = stub.PutState(OwnerIndexStr, jsonAsBytes)						//store name of Owner
+	event := Event{CustomerID: customerId, Message: "Customer details updated succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "updateCustomerPurchaseSC", args, payload, event, externalReference1, externalReference2)
 	if err != nil {
 		return nil, err
 	}
 
-	tosend := "{ \"ownerID\" : \""+ownerId+"\", \"message\" : \"Owner created succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = setEvent(stub, "evtsender", event)
 	if err != nil {
 		return nil, err
-	} 
+	}
 
-	fmt.Println("end createOwner")
-	return nil, nil
+	fmt.Println("Customer details updated succcessfully")
+	return payload, nil
 }
 // ============================================================================================================================
-// associate Customer - associate a customer to Merchant, store into chaincode state
+// Write - update customer during transfer into chaincode state
 // ============================================================================================================================
-func (t *ManageLPM) associateCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *ManageLPM) updateCustomerTransferSC(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
-	var walletWorth, merchantIDs, merchantNames, merchantColors, merchantCurrencies, merchantsPointsCount, merchantsPointsWorth string
-	if len(args) != 6 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 6\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	fmt.Println("Updating Customer - transfer")
+	if len(args) != 7 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'fromCustomerId', 'toCustomerId', 'merchantId', 'points', 'fromTransactionId', 'toTransactionId' and 'transactionType' as arguments")
 	}
-	fmt.Println("start associateCustomer")
-	customerId := args[0]
-	merchantId := args[1]
-	startingBalance := args[2]
-	
-	customerAsBytes, err := stub.GetState(customerId)
+	// set customerIds
+	customerId1 := args[0]
+	customerId2 := args[1]
+	merchantId := args[2]
+	externalReference1 := args[4]
+	externalReference2 := args[5]
+	if customerId1 == customerId2 {
+		return errorResponse(ErrInvalidArgument, "Cannot transfer points from " + customerId1 + " to itself")
+	}
+	if externalReference1 != "" && externalReference1 == externalReference2 {
+		return errorResponse(ErrInvalidArgument, "Both legs of a transfer cannot use externalReference " + externalReference1)
+	}
+	transactionType, err := checkTransactionType(args[6], TransferTransactionType)
 	if err != nil {
-		return nil, errors.New("Failed to get Customer customerID")
+		return nil, err
 	}
-	
-	merchantAsBytes, err := stub.GetState(merchantId)
+	points, err := ParsePoints(args[3])
+	if err != nil || points <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid points " + args[3])
+	}
+	// the customer spends its own points, or the merchant whose points they are moves them for it
+	caller, err := requireRole(stub, RoleBinding{Role: RoleCustomer, EntityID: customerId1}, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
 	if err != nil {
-		return nil, errors.New("Failed to get Merchant merchantID")
+		return nil, err
 	}
-	
-	res := Customer{}
-	res_Merchant := Merchant{}
-	res_trans := Transaction{}
-	
-	json.Unmarshal(merchantAsBytes, &res_Merchant)
-	
-	if res_Merchant.MerchantID == merchantId{
-		fmt.Println("Merchant found with merchantId in associateCustomer: " + merchantId)
-		fmt.Println(res_Merchant);
-	}else{
-		errMsg := "{ \"message\" : \""+ merchantId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "updateCustomerTransferSC", args, externalReference1, externalReference2)
+	if err != nil || replayed {
+		return payload, err
 	}
 
-	// Calculation	
-	floatStartingBalance, _ := strconv.ParseFloat(startingBalance, 64)
-	//fmt.Println("-------------------------floatStartingBalance----------------------------"+strconv.FormatFloat(floatStartingBalance, 'f', 2, 64))
-	floatPointsPerDollarSpent, _ := strconv.ParseFloat(res_Merchant.PointsPerDollarSpent, 64)
-	pointsToBeCredited := floatStartingBalance / floatPointsPerDollarSpent
-	//fmt.Println("pointsToBeCredited in associateCustomer: " + strconv.FormatFloat(pointsToBeCredited, 'f', 2, 64))
-	json.Unmarshal(customerAsBytes, &res)
-	floatWalletWorth, _ := strconv.ParseFloat(res.WalletWorth, 64)
-	newWalletWorth := floatWalletWorth + floatStartingBalance
-	//fmt.Println("newWalletWorth in associateCustomer: " + strconv.FormatFloat(newWalletWorth, 'f', 2, 64))
-	if res.CustomerID == customerId{
-		fmt.Println("Customer found with customerId in associateCustomer: " + customerId)
-		fmt.Println(res);
-		walletWorth = strconv.FormatFloat(newWalletWorth, 'f', 2, 64)
-		merchantIDs = res.MerchantIDs + "," + res_Merchant.MerchantID
-		merchantNames = res.MerchantNames + "," + res_Merchant.MerchantName
-		merchantColors = res.MerchantColors + "," + res_Merchant.IndustryColor
-		merchantCurrencies = res.MerchantCurrencies + "," + res_Merchant.MerchantCurrency
-		merchantsPointsCount = res.MerchantsPointsCount + "," + strconv.FormatFloat(pointsToBeCredited, 'f', 2, 64)
-		merchantsPointsWorth = res.MerchantsPointsWorth + "," + startingBalance
-	
-		res_trans.TransactionID = args[3]
- 		res_trans.TransactionDateTime = args[4]
- 		res_trans.TransactionType = args[5]
- 		res_trans.TransactionFrom = res_Merchant.MerchantName
- 		res_trans.TransactionTo = res.UserName
- 		res_trans.Credit = strconv.FormatFloat(pointsToBeCredited, 'f', 2, 64)
- 		res_trans.Debit = "0"
- 		res_trans.CustomerID = customerId
-	}else{
-		errMsg := "{ \"message\" : \""+ customerId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	res1, found, err := getCustomer(stub, customerId1)					//get the Customer for the specified customerId from chaincode state
+	if err != nil {
+		return nil, err
 	}
-
-	//build the Customer json string manually
-	customer_json := 	`{`+
-		`"customerId": "` + customerId + `" , `+
-		`"customerName": "` + res.CustomerName + `" , `+
-		`"userName": "` + res.UserName + `" , `+
-		`"walletWorth": "` + walletWorth + `" , `+
-		`"merchantIDs": "` + merchantIDs + `" , `+ 
-		`"merchantNames": "` + merchantNames + `" , `+ 
-		`"merchantColors": "` + merchantColors + `" , `+
-		`"merchantCurrencies": "` + merchantCurrencies + `" , `+ 
-		`"merchantsPointsCount": "` + merchantsPointsCount + `" , `+ 
-		`"merchantsPointsWorth": "` +  merchantsPointsWorth + `" `+ 
-	`}`
-	fmt.Println("customer_json: " + customer_json)
-	fmt.Print("customer_json in bytes array: ")
-	fmt.Println([]byte(customer_json))
-	err = stub.PutState(customerId, []byte(customer_json))									//store Customer with customerId as key
+	if !found {
+		return errorResponse(ErrNotFound, customerId1 + " Not Found.")
+	}
+	if !res1.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId1 + " is " + res1.Status)
+	}
+	res2, found, err := getCustomer(stub, customerId2)					//get the Customer for the specified customerId from chaincode state
 	if err != nil {
 		return nil, err
 	}
-
-	// build the Transaction json string manually
-	transaction_json := `{`+
-		`"transactionId": "` + res_trans.TransactionID + `" , `+
-		`"transactionDateTime": "` + res_trans.TransactionDateTime + `" , `+
-		`"transactionType": "` + res_trans.TransactionType + `" , `+
-		`"transactionFrom": "` + res_trans.TransactionFrom + `" , `+ 
-		`"transactionTo": "` + res_trans.TransactionTo + `" , `+ 
-		`"credit": "` + res_trans.Credit + `" , `+ 
-		`"debit": "` + res_trans.Debit + `" , `+ 
-		`"customerId": "` +  res_trans.CustomerID + `" `+ 
-	`}`
-	err = stub.PutState(res_trans.TransactionID, []byte(transaction_json))					//store Transaction with id as key
+	if !found {
+		return errorResponse(ErrNotFound, customerId2 + " Not Found.")
+	}
+	if !res2.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId2 + " is " + res2.Status)
+	}
+	holdingIndex1 := getHoldingIndex(res1, merchantId)
+	holdingIndex2 := getHoldingIndex(res2, merchantId)
+	if holdingIndex1 < 0 || holdingIndex2 < 0 {
+		return errorResponse(ErrFailedPrecondition, customerId1 + " and " + customerId2 + " must both be associated with " + merchantId)
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	res_Merchant, found, err := getMerchant(stub, merchantId)
 	if err != nil {
 		return nil, err
 	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res_Merchant.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res_Merchant.Status + ", its points can no longer be accrued")
+	}
+	fmt.Println("Customer found with customerId1 : " + customerId1)
+	fmt.Println(res1);
+	fmt.Println("Customer found with customerId2 : " + customerId2)
+	fmt.Println(res2);
 
-	//get the Transaction index
-	transactionAsBytes, err := stub.GetState(TransactionIndexStr)
+	// Calculation, the points leave the sender with their share of its worth and reach the receiver at the Merchant's exchangeRate
+	holding1 := &res1.Holdings[holdingIndex1]
+	worthBefore1 := holding1.Worth
+	err = debitHolding(holding1, points, customerId1)
 	if err != nil {
-		return nil, errors.New("Failed to get Transaction index")
+		return nil, err
 	}
-	var transactionIndex []string	
-	json.Unmarshal(transactionAsBytes, &transactionIndex)							//un stringify it aka JSON.parse()
-	
-	//append
-	transactionIndex = append(transactionIndex, res_trans.TransactionID)			//add Transaction res_trans.TransactionID to index list
-	
-	jsonAsBytes, _ := json.Marshal(transactionIndex)
-	fmt.Print("update transaction jsonAsBytes: ")
-	fmt.Println(jsonAsBytes)
-	err = stub.PutState(TransactionIndexStr, jsonAsBytes)						//store name of Transaction
+	err = moveWalletWorth(stub, &res1, worthBefore1, holding1.Worth, transactionDateTime)
 	if err != nil {
 		return nil, err
 	}
-
-	tosend := "{ \"customerID\" : \""+customerId+"\", \"message\" : \"Customer associated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	holding2 := &res2.Holdings[holdingIndex2]
+	worthBefore2 := holding2.Worth
+	err = creditHolding(stub, res_Merchant, holding2, points, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	err = moveWalletWorth(stub, &res2, worthBefore2, holding2.Worth, transactionDateTime)
 	if err != nil {
 		return nil, err
-	} 
+	}
 
-	fmt.Println("end associateCustomer")
-	return nil, nil
-}
\ No newline at end of file
+	// Transaction1 debits the sender, Transaction2 credits the receiver
+	res_trans1 := Transaction{}
+	res_trans1.TransactionID = newTransactionId(stub, 0)
+	res_trans1.TransactionDateTime = transactionDateTime
+	res_trans1.TransactionType = transactionType
+	res_trans1.TransactionFrom = res1.UserName
+	res_trans1.TransactionTo = res2.UserName
+	res_trans1.Credit = 0
+	res_trans1.Debit = points
+	res_trans1.CustomerID = customerId1
+	res_trans1.MerchantID = merchantId
+	res_trans1.ExternalReference = externalReference1
+
+	res_trans2 := Transaction{}
+	res_trans2.TransactionID = newTransactionId(stub, 1)
+	res_trans2.TransactionDateTime = transactionDateTime
+	res_trans2.TransactionType = transactionType
+	res_trans2.TransactionFrom = res1.UserName
+	res_trans2.TransactionTo = res2.UserName
+	res_trans2.Credit = points
+	res_trans2.Debit = 0
+	res_trans2.CustomerID = customerId2
+	res_trans2.MerchantID = merchantId
+	res_trans2.ExternalReference = externalReference2
+	res_trans2.LinkedTransactionID = res_trans1.TransactionID
+
+	err = putCustomer(stub, res1)										//store Customer with id as key
+	if err != nil {
+		return nil, err
+	}
+	err = putCustomer(stub, res2)										//store Customer with id as key
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans1)								//store Transaction with id as key
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans2)								//store Transaction with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal([]Transaction{res_trans1, res_trans2})
+	if err != nil {
+		return nil, err
+	}
+	event := Event{CustomerID: customerId1, Message: "Customer details updated succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "updateCustomerTransferSC", args, payload, event, externalReference1, externalReference2)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", event)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Customer details updated succcessfully for transfer")
+	return payload, nil
+}
+// ============================================================================================================================
+// Delete - close a Customer, cashing out the points it holds, the Customer and its transactions are kept
+// ============================================================================================================================
+func (t *ManageLPM) deleteCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var reason string
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId' and optionally 'reason' as arguments")
+	}
+	// set customerId
+	customerId := args[0]
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleCustomer, EntityID: customerId})
+	if err != nil {
+		return nil, err
+	}
+	res, found, err := getCustomer(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, customerId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId + " is already " + res.Status)
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the Customer and its Transactions are kept, every points balance it still holds is cashed out
+	var settlements []Transaction
+	for i := range res.Holdings {
+		worthBefore := res.Holdings[i].Worth
+		res_trans := settleHolding(stub, &res.Holdings[i], CashOutTransactionType, res.UserName, len(settlements), transactionDateTime)
+		err = moveWalletWorth(stub, &res, worthBefore, res.Holdings[i].Worth, transactionDateTime)
+		if err != nil {
+			return nil, err
+		}
+		res_trans.CustomerID = customerId
+		if res_trans.Debit > 0 {
+			settlements = append(settlements, res_trans)
+		}
+	}
+	res.Status = StatusClosed
+	res.StatusReason = reason
+	res.StatusDateTime = transactionDateTime
+	err = putCustomer(stub, res)										//store Customer with id as key
+	if err != nil {
+		return nil, err
+	}
+	for _, res_trans := range settlements {
+		err = putTransaction(stub, res_trans)							//store Transaction with id as key
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = setEvent(stub, "evtsender", Event{CustomerID: customerId, Message: "Customer closed succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Customer closed succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// create Merchant - create a new Merchant, store into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) createMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 10 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 10")
+	}
+	fmt.Println("start createMerchant")
+	merchantID := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	merchantCurrency, err := parseCurrency(args[8])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid merchantCurrency: " + err.Error())
+	}
+	pointsPerDollarSpent, err := ParseRate(args[5])
+	if err != nil || pointsPerDollarSpent <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid pointsPerDollarSpent " + args[5])
+	}
+	exchangeRate, err := ParseRate(args[6])
+	if err != nil || exchangeRate <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid exchangeRate " + args[6])
+	}
+	purchaseBalance, err := ParseMoney(args[7], merchantCurrency)
+	if err != nil || purchaseBalance.Units < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid purchaseBalance " + args[7])
+	}
+	_, found, err := getMerchant(stub, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		fmt.Println("This Merchant arleady exists: " + merchantID)
+		return errorResponse(ErrAlreadyExists, "This Merchant arleady exists")				//all stop a Merchant by this name exists
+	}
+	res := Merchant{}
+	res.MerchantID = merchantID
+	res.MerchantUserName = args[1]
+	res.MerchantName = args[2]
+	res.MerchantIndustry = args[3]
+	res.IndustryColor = args[4]
+	res.PointsPerDollarSpent = pointsPerDollarSpent
+	res.ExchangeRate = exchangeRate
+	res.PurchaseBalance = purchaseBalance
+	res.MerchantCurrency = merchantCurrency
+	res.MerchantCU_date = args[9]
+	res.Status = StatusActive
+	err = putMerchant(stub, res)								//store Merchant with merchantId as key
+	if err != nil {
+		return nil, err
+	}
+	err = setMerchantCountersInactive(stub, merchantID, false)	//counters of a deleted merchant by this id carry on
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantID, Message: "Merchant created succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end createMerchant")
+	return nil, nil
+}
+// ============================================================================================================================
+// Write - update merchant into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant")
+	if len(args) != 10 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 10")
+	}
+	// set merchantId
+	merchantId := args[0]
+	caller, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res.Status)
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	res.MerchantUserName = args[1]
+	res.MerchantName = args[2]
+	res.MerchantIndustry = args[3]
+	res.IndustryColor = args[4]
+	res.PointsPerDollarSpent, err = ParseRate(args[5])
+	if err != nil || res.PointsPerDollarSpent <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid pointsPerDollarSpent " + args[5])
+	}
+	res.ExchangeRate, err = ParseRate(args[6])
+	if err != nil || res.ExchangeRate <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid exchangeRate " + args[6])
+	}
+	merchantCurrency, err := parseCurrency(args[8])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid merchantCurrency: " + err.Error())
+	}
+	// holdings keep their worth in the currency the Merchant had when they were made, so it cannot change
+	storedCurrency, err := parseCurrency(res.MerchantCurrency)
+	if err == nil && storedCurrency != merchantCurrency {
+		return errorResponse(ErrFailedPrecondition, merchantId + " keeps its points in " + storedCurrency + ", its currency cannot change")
+	}
+	purchaseBalance, err := ParseMoney(args[7], merchantCurrency)
+	if err != nil || purchaseBalance.Units < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid purchaseBalance " + args[7])
+	}
+	// the purchaseBalance is what the owner owes the Merchant, only the owner sets it
+	if purchaseBalance.Units != res.PurchaseBalance.Units && caller.Role != RoleOwner {
+		return errorResponse(ErrPermissionDenied, caller.Identity + " cannot change the purchaseBalance of " + merchantId + ", only the owner can")
+	}
+	res.PurchaseBalance = purchaseBalance
+	res.MerchantCurrency = merchantCurrency
+	res.MerchantCU_date = args[9]
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant details updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant details updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// Write - update merchant's purchase balance into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchantsPurchaseBal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant - Purchase Balance")
+	if len(args) != 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 3")
+	}
+	// set merchantId
+	merchantId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner})					// a merchant must not fund itself
+	if err != nil {
+		return nil, err
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	// an amount in another currency is converted at the rate in effect at the proposal timestamp
+	newPurchaseBal, err := parseAmount(args[1], res.MerchantCurrency)
+	if err != nil || newPurchaseBal.Units < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid purchaseBalance " + args[1])
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	newPurchaseBal, err = convertMoney(stub, newPurchaseBal, res.MerchantCurrency, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	fmt.Println("Merchants old purchaseBalance : " + res.PurchaseBalance.String())
+	fmt.Println("Merchants purchaseBalance credit : " + newPurchaseBal.String())
+	err = addPurchaseBalance(&res, newPurchaseBal, transactionDateTime)	//args[2], the client's date, is superseded by the proposal timestamp
+	if err != nil {
+		return nil, err
+	}
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant purchase balance details updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant purchase balance updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// addPurchaseBalance - credit a redeemed amount to the merchant's purchase balance -- Internal Function
+// ============================================================================================================================
+func addPurchaseBalance(res *Merchant, amount Money, date string) error {
+	var err error
+	if amount.Currency == "" {
+		amount.Currency = res.MerchantCurrency
+	}
+	res.PurchaseBalance, err = res.PurchaseBalance.Add(amount)
+	if err != nil {
+		return newError(ErrInvalidArgument, "Failed to update purchaseBalance for " + res.MerchantID + ": " + err.Error())
+	}
+	res.MerchantCU_date = date
+	return nil
+}
+// ============================================================================================================================
+// Write - update merchant's PPDS into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchantsPPDS(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant - Points Per Dolllar Spent")
+	if len(args) != 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 3")
+	}
+	// set merchantId
+	merchantId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	newPPDS, err := ParseRate(args[1])
+	if err != nil || newPPDS <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid pointsPerDollarSpent " + args[1])
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res.Status)
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	fmt.Println("Merchants old pointsPerDollarSpent : " + res.PointsPerDollarSpent.String())
+	fmt.Println("Merchants new pointsPerDollarSpent : " + newPPDS.String())
+	res.PointsPerDollarSpent = newPPDS
+	res.MerchantCU_date = args[2]
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant points per dollar spent updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant points per dollar spent updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// Write - update merchant's Exchange Rate into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchantsExchangeRate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant - ExchangeRate")
+	if len(args) != 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 3")
+	}
+	// set merchantId
+	merchantId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	newExchangeRate, err := ParseRate(args[1])
+	if err != nil || newExchangeRate <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid exchangeRate " + args[1])
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res.Status)
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	fmt.Println("Merchants old exchangeRate : " + res.ExchangeRate.String())
+	fmt.Println("Merchants new exchangeRate : " + newExchangeRate.String())
+	res.ExchangeRate = newExchangeRate
+	res.MerchantCU_date = args[2]
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant exchange rate updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant exchange rate updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// update Merchant's Expiry Policy - update when a Merchant's points expire, store into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchantsExpiryPolicy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant - ExpiryPolicy")
+	if len(args) != 4 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId', 'expiryPolicy', 'expiryMonths' and 'merchantCU_date' as arguments")
+	}
+	// set merchantId
+	merchantId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	expiryPolicy := args[1]
+	expiryMonths := 0
+	if args[2] != "" {
+		expiryMonths, err = strconv.Atoi(args[2])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid expiryMonths " + args[2])
+		}
+	}
+	if expiryPolicy == ExpiryPolicyMonths || expiryPolicy == ExpiryPolicyInactivity {
+		if expiryMonths < 1 || expiryMonths > MaxExpiryMonths {
+			return errorResponse(ErrInvalidArgument, "Invalid expiryMonths " + args[2] + ", expecting 1 to " + strconv.Itoa(MaxExpiryMonths))
+		}
+	} else if expiryPolicy == ExpiryPolicyNone || expiryPolicy == ExpiryPolicyYearEnd {
+		if expiryMonths != 0 {
+			return errorResponse(ErrInvalidArgument, "expiryMonths is not used by the " + expiryPolicy + " policy")
+		}
+	} else {
+		return errorResponse(ErrInvalidArgument, "Invalid expiryPolicy " + expiryPolicy + ", expecting " + ExpiryPolicyNone + ", " + ExpiryPolicyMonths + ", " + ExpiryPolicyYearEnd + " or " + ExpiryPolicyInactivity)
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res.Status)
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	fmt.Println("Merchants old expiryPolicy : " + res.ExpiryPolicy + " " + strconv.Itoa(res.ExpiryMonths))
+	fmt.Println("Merchants new expiryPolicy : " + expiryPolicy + " " + strconv.Itoa(expiryMonths))
+	res.ExpiryPolicy = expiryPolicy
+	res.ExpiryMonths = expiryMonths
+	res.MerchantCU_date = args[3]
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant expiry policy updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant expiry policy updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// update Merchant's Tiers - update a Merchant's membership tiers, store into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchantsTiers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant - Tiers")
+	if len(args) != 6 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId', 'tierBasis', 'tierWindowMonths', 'tierNames', 'tierThresholds' and 'tierMultipliers' as arguments")
+	}
+	// set merchantId
+	merchantId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res.Status)
+	}
+	tierBasis := args[1]
+	var tiers []Tier
+	tierWindowMonths := 0
+	// empty names remove the tiers, every customer earns at pointsPerDollarSpent again
+	if args[3] != "" {
+		if tierBasis != TierBasisPoints && tierBasis != TierBasisSpend {
+			return errorResponse(ErrInvalidArgument, "Invalid tierBasis " + tierBasis + ", expecting " + TierBasisPoints + " or " + TierBasisSpend)
+		}
+		tierWindowMonths, err = strconv.Atoi(args[2])
+		if err != nil || tierWindowMonths < 1 || tierWindowMonths > MaxTierWindowMonths {
+			return errorResponse(ErrInvalidArgument, "Invalid tierWindowMonths " + args[2] + ", expecting 1 to " + strconv.Itoa(MaxTierWindowMonths))
+		}
+		stringSliceNames := strings.Split(args[3], ",")
+		stringSliceThresholds := strings.Split(args[4], ",")
+		stringSliceMultipliers := strings.Split(args[5], ",")
+		if len(stringSliceThresholds) != len(stringSliceNames) || len(stringSliceMultipliers) != len(stringSliceNames) {
+			return errorResponse(ErrInvalidArgument, "Expecting a threshold and a multiplier for each of " + strconv.Itoa(len(stringSliceNames)) + " tiers")
+		}
+		for i, name := range stringSliceNames {
+			tier := Tier{Name: name}
+			if name == "" {
+				return errorResponse(ErrInvalidArgument, "Tier " + strconv.Itoa(i + 1) + " has no name")
+			}
+			if tierBasis == TierBasisPoints {
+				threshold, err := ParsePoints(stringSliceThresholds[i])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "Invalid threshold for " + name + ": " + err.Error())
+				}
+				tier.Threshold = int64(threshold)
+			} else {
+				threshold, err := ParseMoney(stringSliceThresholds[i], res.MerchantCurrency)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "Invalid threshold for " + name + ": " + err.Error())
+				}
+				tier.Threshold = threshold.Units
+			}
+			if tier.Threshold < 0 || (i > 0 && tier.Threshold <= tiers[i - 1].Threshold) {
+				return errorResponse(ErrInvalidArgument, "Tier thresholds must not be negative and must go up, " + name + " does not")
+			}
+			tier.Multiplier, err = ParseRate(stringSliceMultipliers[i])
+			if err != nil || tier.Multiplier < 1000000 {
+				return errorResponse(ErrInvalidArgument, "Invalid multiplier for " + name + " " + stringSliceMultipliers[i] + ", expecting at least 1")
+			}
+			tiers = append(tiers, tier)
+		}
+	} else {
+		tierBasis = ""
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	fmt.Println("Merchants new tiers : " + strconv.Itoa(len(tiers)) + " on " + tierBasis)
+	res.TierBasis = tierBasis
+	res.TierWindowMonths = tierWindowMonths
+	res.Tiers = tiers
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant tiers updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant tiers updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// create Campaign - create a Merchant's Campaign, store into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) createCampaign(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 10 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'campaignId', 'merchantId', 'campaignName', 'startDateTime', 'endDateTime', 'minSpend', 'minTier', 'firstPurchase', 'bonusType' and 'bonus' as arguments")
+	}
+	fmt.Println("start createCampaign")
+	campaignId := args[0]
+	merchantId := args[1]
+	_, err = requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res_Merchant.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res_Merchant.Status)
+	}
+	_, found, err = getCampaign(stub, campaignId)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return errorResponse(ErrAlreadyExists, "This Campaign arleady exists")
+	}
+
+	res := Campaign{}
+	res.CampaignID = campaignId
+	res.MerchantID = merchantId
+	res.CampaignName = args[2]
+	res.StartDateTime, err = parseDateTimeBound(args[3], false)
+	if err != nil || res.StartDateTime == "" {
+		return errorResponse(ErrInvalidArgument, "Invalid startDateTime " + args[3] + ", expecting RFC 3339 or 2006-01-02")
+	}
+	res.EndDateTime, err = parseDateTimeBound(args[4], true)
+	if err != nil || res.EndDateTime == "" || res.EndDateTime < res.StartDateTime {
+		return errorResponse(ErrInvalidArgument, "Invalid endDateTime " + args[4] + ", expecting RFC 3339 or 2006-01-02 from startDateTime on")
+	}
+	minSpend := args[5]
+	if minSpend == "" {
+		minSpend = "0"
+	}
+	res.MinSpend, err = ParseMoney(minSpend, res_Merchant.MerchantCurrency)
+	if err != nil || res.MinSpend.Units < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid minSpend " + args[5])
+	}
+	res.MinTier = args[6]
+	if res.MinTier != "" && tierRank(res_Merchant, res.MinTier) < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid minTier " + res.MinTier + ", " + merchantId + " has no such tier")
+	}
+	if args[7] != "" {
+		res.FirstPurchase, err = strconv.ParseBool(args[7])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid firstPurchase " + args[7] + ", expecting true or false")
+		}
+	}
+	res.BonusType = args[8]
+	if res.BonusType == CampaignBonusMultiplier {
+		res.Multiplier, err = ParseRate(args[9])
+		if err != nil || res.Multiplier <= 1000000 {
+			return errorResponse(ErrInvalidArgument, "Invalid multiplier " + args[9] + ", expecting more than 1")
+		}
+	} else if res.BonusType == CampaignBonusFlat {
+		res.FlatBonus, err = ParsePoints(args[9])
+		if err != nil || res.FlatBonus <= 0 {
+			return errorResponse(ErrInvalidArgument, "Invalid flat bonus " + args[9] + ", expecting more than 0 points")
+		}
+	} else {
+		return errorResponse(ErrInvalidArgument, "Invalid bonusType " + res.BonusType + ", expecting " + CampaignBonusMultiplier + " or " + CampaignBonusFlat)
+	}
+
+	err = putCampaign(stub, res)										//store Campaign with campaignId as key
+	if err != nil {
+		return nil, err
+	}
+	err = putIndexEntry(stub, MerchantCampaignIndex, []string{merchantId, campaignId})
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Campaign " + campaignId + " created succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end createCampaign")
+	return nil, nil
+}
+// ============================================================================================================================
+// end Campaign - end a Campaign at the proposal timestamp, bonuses already credited are kept
+// ============================================================================================================================
+func (t *ManageLPM) endCampaign(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'campaignId' as an argument")
+	}
+	fmt.Println("start endCampaign")
+	campaignId := args[0]
+	res, found, err := getCampaign(stub, campaignId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, campaignId + " Not Found.")
+	}
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleMerchant, EntityID: res.MerchantID})
+	if err != nil {
+		return nil, err
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if res.EndDateTime < transactionDateTime {
+		return errorResponse(ErrFailedPrecondition, campaignId + " already ended at " + res.EndDateTime)
+	}
+	res.EndDateTime = transactionDateTime
+	if res.StartDateTime > res.EndDateTime {
+		res.StartDateTime = res.EndDateTime								//a campaign ended before it started never runs
+	}
+
+	err = putCampaign(stub, res)										//store Campaign with campaignId as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: res.MerchantID, Message: "Campaign " + campaignId + " ended succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end endCampaign")
+	return nil, nil
+}
+// ============================================================================================================================
+// update Exchange Fee - update the share of the value exchanged by exchangePoints kept as the network fee
+// ============================================================================================================================
+func (t *ManageLPM) updateExchangeFee(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Exchange - FeeRate")
+	if len(args) != 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'feeRate' as an argument")
+	}
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	feeRate, err := ParseRate(args[0])
+	if err != nil || feeRate < 0 || feeRate >= 1000000 {
+		return errorResponse(ErrInvalidArgument, "Invalid feeRate " + args[0] + ", expecting 0 up to but not including 1")
+	}
+	res, err := getExchangeConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Exchange old feeRate : " + res.FeeRate.String())
+	fmt.Println("Exchange new feeRate : " + feeRate.String())
+	res.FeeRate = feeRate
+	res.UpdatedDateTime, err = getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putRecord(stub, ExchangeConfigKey, res)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{Message: "Exchange fee updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Exchange fee updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// update Merchant's Exchange Settings - opt a Merchant in or out of exchangePoints and set its daily cap
+// ============================================================================================================================
+func (t *ManageLPM) updateMerchantsExchangeSettings(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Merchant - ExchangeSettings")
+	if len(args) != 4 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId', 'exchangeOptIn', 'exchangeDailyCap' and 'merchantCU_date' as arguments")
+	}
+	// set merchantId
+	merchantId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	exchangeOptIn, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid exchangeOptIn " + args[1] + ", expecting true or false")
+	}
+	res, found, err := getMerchant(stub, merchantId)							//get the Merchant for the specified merchant from chaincode state
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res.Status)
+	}
+	exchangeDailyCap := Money{Units: 0, Currency: res.MerchantCurrency}
+	if args[2] != "" {
+		exchangeDailyCap, err = ParseMoney(args[2], res.MerchantCurrency)
+		if err != nil || exchangeDailyCap.Units < 0 {
+			return errorResponse(ErrInvalidArgument, "Invalid exchangeDailyCap " + args[2])
+		}
+	}
+	fmt.Println("Merchant found with merchantId : " + merchantId)
+	fmt.Println("Merchants new exchange settings : " + strconv.FormatBool(exchangeOptIn) + " " + exchangeDailyCap.String())
+	res.ExchangeOptIn = exchangeOptIn
+	res.ExchangeDailyCap = exchangeDailyCap.Units
+	res.MerchantCU_date = args[3]
+
+	err = putMerchant(stub, res)								//store Merchant with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{MerchantID: merchantId, Message: "Merchant exchange settings updated succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Merchant exchange settings updated succcessfully")
+	return nil, nil
+}
+// ============================================================================================================================
+// Delete - deactivate a merchant, its members' points are cashed out or expired per policy and the Merchant is kept,
+// the first invocation deactivates it and settles one bounded batch of members, the others are settled by invoking it
+// again with the bookmark the previous batch returned
+// ============================================================================================================================
+func (t *ManageLPM) deleteMerchant(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var reason string
+	var batchSize = DeactivationBatchSize
+	var bookmark string
+	if len(args) < 2 || len(args) > 5 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId', 'policy' and optionally 'reason', 'batchSize' and 'bookmark' as arguments")
+	}
+	// set merchantId
+	merchantId := args[0]
+	policy := args[1]
+	if len(args) > 2 {
+		reason = args[2]
+	}
+	if len(args) > 3 && args[3] != "" {
+		size, err := strconv.Atoi(args[3])
+		if err != nil || size < 1 || size > MaxDeactivationBatchSize {
+			return errorResponse(ErrInvalidArgument, "Invalid batchSize " + args[3] + ", expecting 1 to " + strconv.Itoa(MaxDeactivationBatchSize))
+		}
+		batchSize = size
+	}
+	if len(args) > 4 {
+		bookmark = args[4]
+	}
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	transactionType := ""
+	if policy == DeactivationCashOut {
+		transactionType = CashOutTransactionType
+	} else if policy == DeactivationExpire {
+		transactionType = ExpiryTransactionType
+	} else {
+		return errorResponse(ErrInvalidArgument, "Invalid policy " + policy + ", expecting " + DeactivationCashOut + " or " + DeactivationExpire)
+	}
+	res, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if bookmark == "" {
+		if !res.isActive() {
+			return errorResponse(ErrFailedPrecondition, merchantId + " is already " + res.Status)
+		}
+		res.Status = StatusDeactivated
+		res.StatusReason = reason
+		res.StatusDateTime = transactionDateTime
+		res.DeactivationPolicy = policy
+		err = putMerchant(stub, res)										//store Merchant with id as key
+		if err != nil {
+			return nil, err
+		}
+		err = setMerchantCountersInactive(stub, merchantId, true)
+		if err != nil {
+			return nil, err
+		}
+	} else if res.Status != StatusDeactivated {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is not being deactivated, invoke without a bookmark first")
+	} else if res.DeactivationPolicy != "" && res.DeactivationPolicy != policy {
+		return errorResponse(ErrInvalidArgument, merchantId + " is being deactivated with policy " + res.DeactivationPolicy)
+	}
+
+	// members keep their holding with the Merchant, its points are cashed out or expired per policy
+	report := DeactivationReport{MerchantID: merchantId}
+	customerIds, nextBookmark, err := getMemberPage(stub, merchantId, bookmark, batchSize)	//only this batch's members are read
+	if err != nil {
+		return nil, err
+	}
+	report.Bookmark = nextBookmark											//more members follow, resume after the last one looked at
+	for _, customerId := range customerIds {
+		report.Customers++
+		res_Customer, found, err := getCustomer(stub, customerId)
+		if err != nil {
+			return nil, err
+		}
+		holdingIndex := getHoldingIndex(res_Customer, merchantId)
+		if !found || holdingIndex < 0 {
+			continue
+		}
+		worthBefore := res_Customer.Holdings[holdingIndex].Worth
+		res_trans := settleHolding(stub, &res_Customer.Holdings[holdingIndex], transactionType, res_Customer.UserName, report.Transactions, transactionDateTime)
+		if res_trans.Debit == 0 {
+			continue
+		}
+		err = moveWalletWorth(stub, &res_Customer, worthBefore, res_Customer.Holdings[holdingIndex].Worth, transactionDateTime)
+		if err != nil {
+			return nil, err
+		}
+		res_trans.CustomerID = customerId
+		err = putCustomer(stub, res_Customer)								//store Customer with id as key
+		if err != nil {
+			return nil, err
+		}
+		err = putTransaction(stub, res_trans)								//store Transaction with id as key
+		if err != nil {
+			return nil, err
+		}
+		report.Transactions++
+		report.Settled += res_trans.Debit
+	}
+
+	report.Message = "Merchant deactivated succcessfully"
+	report.Code = "200"
+	err = setEvent(stub, "evtsender", report)
+	if err != nil {
+		return nil, err
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("Merchant deactivated succcessfully")
+	return jsonResp, nil
+}
+// ============================================================================================================================
+// create Owner - create a Owner, store into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) createOwner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 3")
+	}
+	fmt.Println("start createOwner")
+	ownerId := args[0]
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+
+	_, found, err := getOwner(stub, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return errorResponse(ErrAlreadyExists, "This Owner arleady exists")				//all stop a Owner by this name exists
+	}
+
+	res := Owner{}
+	res.OwnerID = ownerId
+	res.OwnerUserName = args[1]
+	res.OwnerName = args[2]
+	err = putOwner(stub, res)											//store Owner with ownerId as key
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{OwnerID: ownerId, Message: "Owner created succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end createOwner")
+	return nil, nil
+}
+// ============================================================================================================================
+// associate Customer - associate a customer to Merchant, store into chaincode state
+// ============================================================================================================================
+func (t *ManageLPM) associateCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 6 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 6")
+	}
+	fmt.Println("start associateCustomer")
+	customerId := args[0]
+	merchantId := args[1]
+	transactionType, err := checkTransactionType(args[5], OnboardingTransactionType)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleMerchant, EntityID: merchantId}, RoleBinding{Role: RoleCustomer, EntityID: customerId})
+	if err != nil {
+		return nil, err
+	}
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "associateCustomer", args, args[3])
+	if err != nil || replayed {
+		return payload, err
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	if !res_Merchant.isActive() {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is " + res_Merchant.Status)
+	}
+	fmt.Println("Merchant found with merchantId in associateCustomer: " + merchantId)
+	fmt.Println(res_Merchant);
+
+	res, found, err := getCustomer(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, customerId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId + " is " + res.Status)
+	}
+	fmt.Println("Customer found with customerId in associateCustomer: " + customerId)
+	fmt.Println(res);
+	if getHoldingIndex(res, merchantId) >= 0 {
+		return errorResponse(ErrAlreadyExists, customerId + " is already associated with " + merchantId)
+	}
+
+	// Calculation, a startingBalance in another currency is converted at the rate in effect at the proposal timestamp
+	startingBalance, err := parseAmount(args[2], res_Merchant.MerchantCurrency)
+	if err != nil || startingBalance.Units < 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid startingBalance " + args[2])
+	}
+	// a merchant or the customer itself associates from nothing, only the owner may grant a startingBalance
+	if startingBalance.Units != 0 && caller.Role != RoleOwner {
+		return errorResponse(ErrPermissionDenied, caller.Identity + " cannot grant " + customerId + " a startingBalance, only the owner can")
+	}
+	startingBalance, err = convertMoney(stub, startingBalance, res_Merchant.MerchantCurrency, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	walletCredit, err := convertMoney(stub, startingBalance, res.WalletWorth.Currency, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	if res_Merchant.PointsPerDollarSpent <= 0 {
+		return errorResponse(ErrFailedPrecondition, merchantId + " has no pointsPerDollarSpent set")
+	}
+	pointsValue, err := moneyPoints(startingBalance, res_Merchant.PointsPerDollarSpent)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid startingBalance " + args[2] + ": " + err.Error())
+	}
+	pointsToBeCredited := Points(pointsValue)
+	fmt.Println("pointsToBeCredited in associateCustomer: " + pointsToBeCredited.String())
+	res.WalletWorth, err = res.WalletWorth.Add(walletCredit)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Failed to update walletWorth for " + customerId + ": " + err.Error())
+	}
+	res.Holdings = append(res.Holdings, MerchantHolding{
+		MerchantID: res_Merchant.MerchantID,
+		MerchantName: res_Merchant.MerchantName,
+		MerchantColor: res_Merchant.IndustryColor,
+		Currency: res_Merchant.MerchantCurrency,
+		Points: pointsToBeCredited,
+		Worth: startingBalance,
+		JoinedDate: transactionDateTime,
+	})
+
+	res_trans := Transaction{}
+	res_trans.TransactionID = newTransactionId(stub, 0)
+	res_trans.TransactionDateTime = transactionDateTime
+	res_trans.TransactionType = transactionType
+	res_trans.TransactionFrom = res_Merchant.MerchantName
+	res_trans.TransactionTo = res.UserName
+	res_trans.Credit = pointsToBeCredited
+	res_trans.Debit = 0
+	res_trans.CustomerID = customerId
+	res_trans.MerchantID = merchantId
+	res_trans.ExternalReference = args[3]
+
+	err = putCustomer(stub, res)										//store Customer with customerId as key
+	if err != nil {
+		return nil, err
+	}
+	err = putMemberEntry(stub, merchantId, customerId)
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans)								//store Transaction with id as key
+	if err != nil {
+		return nil, err
+	}
+
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal([]Transaction{res_trans})
+	if err != nil {
+		return nil, err
+	}
+	event := Event{CustomerID: customerId, Message: "Customer associated succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "associateCustomer", args, payload, event, args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", event)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end associateCustomer")
+	return payload, nil
+}
+// ============================================================================================================================
+// disassociate Customer - remove a customer from a Merchant, its points there are cashed out or expired per policy
+// ============================================================================================================================
+func (t *ManageLPM) disassociateCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) < 4 || len(args) > 5 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId', 'merchantId', 'policy', 'transactionId' and optionally 'allowNoMerchants' as arguments")
+	}
+	fmt.Println("start disassociateCustomer")
+	customerId := args[0]
+	merchantId := args[1]
+	policy := args[2]
+	allowNoMerchants := false
+	if len(args) > 4 && args[4] != "" {
+		allowNoMerchants, err = strconv.ParseBool(args[4])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid allowNoMerchants " + args[4] + ", expecting true or false")
+		}
+	}
+	caller, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleMerchant, EntityID: merchantId}, RoleBinding{Role: RoleCustomer, EntityID: customerId})
+	if err != nil {
+		return nil, err
+	}
+	transactionType := ""
+	if policy == DeactivationCashOut {
+		transactionType = CashOutTransactionType
+	} else if policy == DeactivationExpire {
+		transactionType = ExpiryTransactionType
+	} else {
+		return errorResponse(ErrInvalidArgument, "Invalid policy " + policy + ", expecting " + DeactivationCashOut + " or " + DeactivationExpire)
+	}
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "disassociateCustomer", args, args[3])
+	if err != nil || replayed {
+		return payload, err
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	res, found, err := getCustomer(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, customerId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId + " is " + res.Status)
+	}
+	holdingIndex := getHoldingIndex(res, merchantId)
+	if holdingIndex < 0 {
+		return errorResponse(ErrNotFound, customerId + " is not associated with " + merchantId)
+	}
+	if len(res.Holdings) == 1 && !allowNoMerchants {
+		return errorResponse(ErrFailedPrecondition, merchantId + " is the only merchant of " + customerId + ", pass allowNoMerchants to remove it")
+	}
+	fmt.Println("Customer found with customerId in disassociateCustomer: " + customerId)
+	fmt.Println(res);
+
+	// the points still held are settled first, then the holding is removed and the Offboarding recorded
+	var transactions []Transaction
+	holding := res.Holdings[holdingIndex]
+	res_trans := settleHolding(stub, &holding, transactionType, res.UserName, len(transactions), transactionDateTime)
+	err = moveWalletWorth(stub, &res, res.Holdings[holdingIndex].Worth, holding.Worth, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	if res_trans.Debit > 0 {
+		res_trans.CustomerID = customerId
+		res_trans.ExternalReference = args[3]
+		transactions = append(transactions, res_trans)
+	}
+	res.Holdings = append(res.Holdings[:holdingIndex], res.Holdings[holdingIndex+1:]...)
+
+	res_trans = Transaction{}
+	res_trans.TransactionID = newTransactionId(stub, len(transactions))
+	res_trans.TransactionDateTime = transactionDateTime
+	res_trans.TransactionType = OffboardingTransactionType
+	res_trans.TransactionFrom = res.UserName
+	res_trans.TransactionTo = holding.MerchantName
+	res_trans.Credit = 0
+	res_trans.Debit = 0
+	res_trans.CustomerID = customerId
+	res_trans.MerchantID = merchantId
+	res_trans.ExternalReference = args[3]
+	transactions = append(transactions, res_trans)
+
+	err = putCustomer(stub, res)										//store Customer with customerId as key
+	if err != nil {
+		return nil, err
+	}
+	err = delMemberEntry(stub, merchantId, customerId)
+	if err != nil {
+		return nil, err
+	}
+	for _, res_trans := range transactions {
+		err = putTransaction(stub, res_trans)							//store Transaction with id as key
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal(transactions)
+	if err != nil {
+		return nil, err
+	}
+	event := Event{CustomerID: customerId, MerchantID: merchantId, Message: "Customer disassociated succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "disassociateCustomer", args, payload, event, args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", event)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end disassociateCustomer")
+	return payload, nil
+}
+// ============================================================================================================================
+// expirePoints - forfeit the lots of a Merchant's customers that its expiry policy says are due at the proposal timestamp
+// and move those whose earnings left the tier window to the tier the rest reach, one bounded batch of customers per
+// invocation, resumed from the bookmark the previous batch returned
+// ============================================================================================================================
+func (t *ManageLPM) expirePoints(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var batchSize = ExpiryBatchSize
+	var bookmark string
+	fmt.Println("start expirePoints")
+	if len(args) < 1 || len(args) > 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'merchantId' and optionally 'batchSize' and 'bookmark' as arguments")
+	}
+	merchantId := args[0]
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size < 1 || size > MaxExpiryBatchSize {
+			return errorResponse(ErrInvalidArgument, "Invalid batchSize " + args[1] + ", expecting 1 to " + strconv.Itoa(MaxExpiryBatchSize))
+		}
+		batchSize = size
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleMerchant, EntityID: merchantId})
+	if err != nil {
+		return nil, err
+	}
+	res_Merchant, found, err := getMerchant(stub, merchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, merchantId + " Not Found.")
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := time.Parse(time.RFC3339, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ExpiryReport{MerchantID: merchantId, AsOf: transactionDateTime}
+	customerIds, nextBookmark, err := getMemberPage(stub, merchantId, bookmark, batchSize)	//only this batch's customers are read
+	if err != nil {
+		return nil, err
+	}
+	report.Bookmark = nextBookmark											//more customers follow, resume after the last one looked at
+	for _, customerId := range customerIds {
+		report.Customers++
+		// a deactivated Merchant's points were settled when it was deactivated, a closed Customer's when it was closed
+		if !res_Merchant.isActive() {
+			continue
+		}
+		res, found, err := getCustomer(stub, customerId)
+		if err != nil {
+			return nil, err
+		}
+		holdingIndex := getHoldingIndex(res, merchantId)
+		if !found || !res.isActive() || holdingIndex < 0 {
+			continue
+		}
+		holding := &res.Holdings[holdingIndex]
+		worthBefore := holding.Worth
+		var transactions []Transaction
+		res_trans, err := expireHolding(stub, res_Merchant, holding, res.UserName, report.Transactions + report.TierChanges, asOf)
+		if err != nil {
+			return nil, newError(ErrFailedPrecondition, "Failed to expire the points of " + customerId + ": " + err.Error())
+		}
+		if res_trans.Debit > 0 {
+			err = moveWalletWorth(stub, &res, worthBefore, holding.Worth, transactionDateTime)
+			if err != nil {
+				return nil, err
+			}
+			transactions = append(transactions, res_trans)
+			report.Transactions++
+			report.Expired += res_trans.Debit
+		}
+		// the tier window rolls on without accumulations, a customer whose earnings left it drops to the tier the rest reach
+		previousTier := holding.Tier
+		if reviewTier(res_Merchant, holding, transactionDateTime) {
+			transactions = append(transactions, newTierChange(stub, *holding, previousTier, report.Transactions + report.TierChanges, transactionDateTime))
+			report.TierChanges++
+		}
+		if len(transactions) == 0 {
+			continue
+		}
+		err = putCustomer(stub, res)										//store Customer with customerId as key
+		if err != nil {
+			return nil, err
+		}
+		for _, res_trans := range transactions {
+			res_trans.CustomerID = customerId
+			err = putTransaction(stub, res_trans)							//store Transaction with id as key
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	report.Message = "Points expired for " + merchantId
+	report.Code = "200"
+	err = setEvent(stub, "evtsender", report)
+	if err != nil {
+		return nil, err
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end expirePoints")
+	return jsonResp, nil
+}
+// ============================================================================================================================
+// exchangePoints - exchange a customer's points with one Merchant for points with another through their value at both
+// merchants' exchangeRate and the FX rate between their currencies, less the network fee paid in the first Merchant's points
+// to the network account, the debit, credit and fee legs net to zero in value in the first Merchant's currency
+// ============================================================================================================================
+func (t *ManageLPM) exchangePoints(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 5 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'customerId', 'fromMerchantId', 'toMerchantId', 'points' and 'transactionId' as arguments")
+	}
+	fmt.Println("start exchangePoints")
+	customerId := args[0]
+	fromMerchantId := args[1]
+	toMerchantId := args[2]
+	externalReference := args[4]
+	caller, err := requireRole(stub, RoleBinding{Role: RoleOwner}, RoleBinding{Role: RoleCustomer, EntityID: customerId})
+	if err != nil {
+		return nil, err
+	}
+	points, err := ParsePoints(args[3])
+	if err != nil || points <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid points " + args[3])
+	}
+	if fromMerchantId == toMerchantId {
+		return errorResponse(ErrInvalidArgument, "Points can only be exchanged between two merchants")
+	}
+	// a retry of a request already applied gets its first result, a different request reusing the id is rejected
+	replayed, payload, err := replayRequest(stub, caller.Identity, "exchangePoints", args, externalReference)
+	if err != nil || replayed {
+		return payload, err
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var merchants []Merchant
+	for _, merchantId := range []string{fromMerchantId, toMerchantId} {
+		res_Merchant, found, err := getMerchant(stub, merchantId)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return errorResponse(ErrNotFound, merchantId + " Not Found.")
+		}
+		if !res_Merchant.isActive() {
+			return errorResponse(ErrFailedPrecondition, merchantId + " is " + res_Merchant.Status)
+		}
+		if !res_Merchant.ExchangeOptIn {
+			return errorResponse(ErrFailedPrecondition, merchantId + " does not take part in points exchange")
+		}
+		if res_Merchant.ExchangeRate <= 0 {
+			return errorResponse(ErrFailedPrecondition, merchantId + " has no exchangeRate set")
+		}
+		merchants = append(merchants, res_Merchant)
+	}
+	fromMerchant := merchants[0]
+	toMerchant := merchants[1]
+
+	res, found, err := getCustomer(stub, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, customerId + " Not Found.")
+	}
+	if !res.isActive() {
+		return errorResponse(ErrFailedPrecondition, customerId + " is " + res.Status)
+	}
+	fromIndex := getHoldingIndex(res, fromMerchantId)
+	toIndex := getHoldingIndex(res, toMerchantId)
+	if fromIndex < 0 || toIndex < 0 {
+		return errorResponse(ErrFailedPrecondition, customerId + " must be associated with " + fromMerchantId + " and " + toMerchantId)
+	}
+	fromHolding := &res.Holdings[fromIndex]
+	toHolding := &res.Holdings[toIndex]
+	if fromHolding.Points < points {
+		return errorResponse(ErrInsufficientPoints, "Insufficient points with " + fromMerchantId + " for " + customerId)
+	}
+
+	// Calculation, the points credited are rounded down and the fee keeps what rounding leaves
+	value, err := pointsWorth(points, fromMerchant.ExchangeRate, fromMerchant.MerchantCurrency)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid points " + args[3] + ": " + err.Error())
+	}
+	res_Config, err := getExchangeConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	feeUnits, err := mulDiv(value.Units, int64(res_Config.FeeRate), 1000000)
+	if err != nil {
+		return nil, err
+	}
+	// the value left after the fee goes into toMerchant's currency at the rate in effect at the proposal timestamp
+	res_FxRate, err := getFxRateAt(stub, fromMerchant.MerchantCurrency, toMerchant.MerchantCurrency, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	netUnits, err := convertUnits(value.Units - feeUnits, res_FxRate.Rate, fromMerchant.MerchantCurrency, toMerchant.MerchantCurrency)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid points " + args[3] + ": " + err.Error())
+	}
+	creditValue, err := moneyPoints(Money{Units: netUnits, Currency: toMerchant.MerchantCurrency}, toMerchant.ExchangeRate)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid points " + args[3] + ": " + err.Error())
+	}
+	credit := Points(creditValue)
+	if credit <= 0 {
+		return errorResponse(ErrInvalidArgument, "Exchanging " + args[3] + " points would credit no points with " + toMerchantId)
+	}
+	creditWorth, err := pointsWorth(credit, toMerchant.ExchangeRate, toMerchant.MerchantCurrency)
+	if err != nil {
+		return nil, err
+	}
+	// back in fromMerchant's currency at the same rate, so the fee keeps what rounding leaves in either currency
+	creditWorthUnits, err := convertUnitsBack(creditWorth.Units, res_FxRate.Rate, fromMerchant.MerchantCurrency, toMerchant.MerchantCurrency)
+	if err != nil {
+		return nil, err
+	}
+	fee := Money{Units: value.Units - creditWorthUnits, Currency: value.Currency}
+
+	// daily caps, the value leaving fromMerchant and the value reaching toMerchant
+	day := transactionDateTime[:len("2006-01-02")]
+	within, err := addExchangeVolume(stub, fromMerchantId, day, value, true, fromMerchant.ExchangeDailyCap)
+	if err != nil {
+		return nil, err
+	}
+	if !within {
+		return errorResponse(ErrLimitExceeded, fromMerchantId + " would exchange out more than its daily cap of " + formatFixed(fromMerchant.ExchangeDailyCap, minorUnits(fromMerchant.MerchantCurrency)))
+	}
+	within, err = addExchangeVolume(stub, toMerchantId, day, creditWorth, false, toMerchant.ExchangeDailyCap)
+	if err != nil {
+		return nil, err
+	}
+	if !within {
+		return errorResponse(ErrLimitExceeded, toMerchantId + " would exchange in more than its daily cap of " + formatFixed(toMerchant.ExchangeDailyCap, minorUnits(toMerchant.MerchantCurrency)))
+	}
+	// the fee is paid in fromMerchant's points, its share of the points is its share of the value
+	feePoints := int64(0)
+	if fee.Units > 0 {
+		feePoints, err = mulDiv(int64(points), fee.Units, value.Units)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the worth left with fromMerchant is in proportion to the points left
+	remaining := fromHolding.Points - points
+	fromWorth, err := mulDiv(fromHolding.Worth.Units, int64(remaining), int64(fromHolding.Points))
+	if err != nil {
+		return nil, err
+	}
+	fromWorthBefore := fromHolding.Worth
+	toWorthBefore := toHolding.Worth
+	fromHolding.Points = remaining
+	fromHolding.Worth = Money{Units: fromWorth, Currency: fromHolding.Worth.Currency}
+	toHolding.Points, err = toHolding.Points.Add(credit)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Failed to credit " + customerId + " with " + toMerchantId + ": " + err.Error())
+	}
+	toHolding.Worth, err = toHolding.Worth.Add(creditWorth)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Failed to credit " + customerId + " with " + toMerchantId + ": " + err.Error())
+	}
+	err = moveWalletWorth(stub, &res, fromWorthBefore, fromHolding.Worth, transactionDateTime)
+	if err == nil {
+		err = moveWalletWorth(stub, &res, toWorthBefore, toHolding.Worth, transactionDateTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+	exchanged := Money{Units: value.Units - fee.Units, Currency: value.Currency}
+
+	res_trans1 := Transaction{}
+	res_trans1.TransactionID = newTransactionId(stub, 0)
+	res_trans1.TransactionDateTime = transactionDateTime
+	res_trans1.TransactionType = ExchangeTransactionType
+	res_trans1.TransactionFrom = res.UserName
+	res_trans1.TransactionTo = fromMerchant.MerchantName
+	res_trans1.Credit = 0
+	res_trans1.Debit = points - Points(feePoints)
+	res_trans1.CustomerID = customerId
+	res_trans1.MerchantID = fromMerchantId
+	res_trans1.ExternalReference = externalReference
+	res_trans1.Amount = &exchanged
+
+	res_trans2 := Transaction{}
+	res_trans2.TransactionID = newTransactionId(stub, 1)
+	res_trans2.TransactionDateTime = transactionDateTime
+	res_trans2.TransactionType = ExchangeTransactionType
+	res_trans2.TransactionFrom = toMerchant.MerchantName
+	res_trans2.TransactionTo = res.UserName
+	res_trans2.Credit = credit
+	res_trans2.Debit = 0
+	res_trans2.CustomerID = customerId
+	res_trans2.MerchantID = toMerchantId
+	res_trans2.ExternalReference = externalReference
+	res_trans2.LinkedTransactionID = res_trans1.TransactionID
+	res_trans2.Amount = &creditWorth
+
+	res_trans3 := Transaction{}
+	res_trans3.TransactionID = newTransactionId(stub, 2)
+	res_trans3.TransactionDateTime = transactionDateTime
+	res_trans3.TransactionType = ExchangeFeeTransactionType
+	res_trans3.TransactionFrom = res.UserName
+	res_trans3.TransactionTo = NetworkName
+	res_trans3.Credit = 0
+	res_trans3.Debit = Points(feePoints)
+	res_trans3.CustomerID = customerId
+	res_trans3.MerchantID = fromMerchantId
+	res_trans3.ExternalReference = externalReference
+	res_trans3.LinkedTransactionID = res_trans1.TransactionID
+	res_trans3.Amount = &fee
+
+	err = checkExchangeLegs(points, value, res_FxRate.Rate, res_trans1, res_trans2, res_trans3)
+	if err != nil {
+		return nil, err
+	}
+	err = addNetworkFee(stub, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putCustomer(stub, res)										//store Customer with customerId as key
+	if err != nil {
+		return nil, err
+	}
+	for _, res_trans := range []Transaction{res_trans1, res_trans2, res_trans3} {
+		err = putTransaction(stub, res_trans)							//store Transaction with id as key
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the Transactions written are the result, kept with the event so a retry gets both again
+	payload, err = json.Marshal([]Transaction{res_trans1, res_trans2, res_trans3})
+	if err != nil {
+		return nil, err
+	}
+	event := Event{CustomerID: customerId, Message: "Points exchanged succcessfully", Code: "200"}
+	err = recordRequest(stub, caller.Identity, "exchangePoints", args, payload, event, externalReference)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", event)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end exchangePoints")
+	return payload, nil
+}
+// ============================================================================================================================
+// setFxRate - add the rate of one currency in another to the FX table, in effect from effectiveDateTime until a later
+// rate for the same pair, the rate of the reverse pair is derived from it when the table has none
+// ============================================================================================================================
+func (t *ManageLPM) setFxRate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) < 3 || len(args) > 4 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'baseCurrency', 'quoteCurrency', 'rate' and optionally 'effectiveDateTime' as arguments")
+	}
+	fmt.Println("start setFxRate")
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	res := FxRate{}
+	res.BaseCurrency, err = parseCurrency(args[0])
+	if err != nil || args[0] == "" {
+		return errorResponse(ErrInvalidArgument, "Invalid baseCurrency " + args[0] + ", expecting an ISO 4217 code")
+	}
+	res.QuoteCurrency, err = parseCurrency(args[1])
+	if err != nil || args[1] == "" {
+		return errorResponse(ErrInvalidArgument, "Invalid quoteCurrency " + args[1] + ", expecting an ISO 4217 code")
+	}
+	if res.BaseCurrency == res.QuoteCurrency {
+		return errorResponse(ErrInvalidArgument, "A currency is always worth itself")
+	}
+	res.Rate, err = ParseRate(args[2])
+	if err != nil || res.Rate <= 0 {
+		return errorResponse(ErrInvalidArgument, "Invalid rate " + args[2])
+	}
+	if len(args) > 3 {
+		res.EffectiveDateTime, err = parseDateTimeBound(args[3], false)
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid effectiveDateTime " + args[3] + ", expecting RFC 3339 or 2006-01-02")
+		}
+	}
+	if res.EffectiveDateTime == "" {
+		res.EffectiveDateTime, err = getTxDateTime(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := getFxRateKey(stub, res.BaseCurrency, res.QuoteCurrency, res.EffectiveDateTime)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Invalid effectiveDateTime " + res.EffectiveDateTime + ": " + err.Error())
+	}
+	err = putRecord(stub, key, res)										//a rate for the same pair and time replaces it
+	if err != nil {
+		return nil, err
+	}
+
+	err = setEvent(stub, "evtsender", Event{Message: "FX rate " + res.BaseCurrency + "/" + res.QuoteCurrency + " set succcessfully", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("end setFxRate")
+	return nil, nil
+}
+// ============================================================================================================================
+// migrate - upgrade records listed in the legacy index arrays to the current schema, one bounded batch per invocation
+// A record that fails is retried once every index is walked, after MaxMigrationAttempts failures or when skipFailed is "true"
+// it moves to FailedRecords so Done is reached without it. The split manageCustomer and manageMerchant chaincodes kept their
+// records in their own namespaces, which no other chaincode can write, so migrate never sees them here: each is upgraded in
+// place to this chaincode, keeping its namespace and index arrays, and migrated there by its own migrate invocations
+// ============================================================================================================================
+func (t *ManageLPM) migrate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var batchSize = MigrationBatchSize
+	var skipFailed = false
+	fmt.Println("start migrate")
+
+	if len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting optional 'batchSize' and 'skipFailed' as arguments")
+	}
+	if len(args) >= 1 && args[0] != "" {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 || size > MaxMigrationBatchSize {
+			return errorResponse(ErrInvalidArgument, "Invalid batchSize " + args[0] + ", expecting 1 to " + strconv.Itoa(MaxMigrationBatchSize))
+		}
+		batchSize = size
+	}
+	if len(args) == 2 && args[1] != "" {
+		skip, err := strconv.ParseBool(args[1])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "Invalid skipFailed " + args[1] + ", expecting true or false")
+		}
+		skipFailed = skip
+	}
+
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	progress := MigrationProgress{}
+	_, err = getRecord(stub, MigrationProgressKey, &progress)
+	if err != nil {
+		return nil, err
+	}
+	if progress.Done {
+		return errorResponse(ErrFailedPrecondition, "Migration already completed at schema version " + strconv.Itoa(SchemaVersion))
+	}
+
+	progress.Batch++
+	report := MigrationReport{Batch: progress.Batch, Failures: []MigrationFailure{}}
+	pending := progress.Pending
+	progress.Pending = nil
+	for report.Records < batchSize && progress.Phase < len(MigrationIndexes) {
+		indexStr := MigrationIndexes[progress.Phase]
+		page, err := getLegacyIndexPage(stub, indexStr, &progress)
+		if err != nil {
+			return nil, err
+		}
+		for report.Records < batchSize && len(page) > 0 {
+			key := page[0]
+			page = page[1:]
+			fmt.Println(strconv.Itoa(progress.Position) + " - looking at " + key + " in " + indexStr + " for migrate")
+			migrated, err := migrateLegacyRecord(stub, indexStr, key)
+			if err != nil {
+				fmt.Println("Could not migrate " + key + ": " + err.Error())
+				failure := MigrationFailure{Index: indexStr, Key: key, Reason: err.Error(), Attempts: 1}
+				report.Failures = append(report.Failures, failure)
+				progress.Pending = append(progress.Pending, failure)
+			} else if migrated {
+				report.Migrated++
+			} else {
+				report.Skipped++											// already stored under the current schema
+			}
+			report.Records++
+			progress.Position++
+		}
+		if progress.Position >= progress.Length {
+			err = deleteLegacyIndexChunks(stub, indexStr, progress.Length)
+			if err != nil {
+				return nil, err
+			}
+			progress.Phase++
+			progress.Position = 0
+			progress.Split = false
+			progress.Length = 0
+		}
+	}
+	// once every index is walked the records that failed are retried, oldest first, until none are left
+	if skipFailed && progress.Phase >= len(MigrationIndexes) {
+		progress.FailedRecords = append(progress.FailedRecords, pending...)
+		pending = nil
+	}
+	for report.Records < batchSize && progress.Phase >= len(MigrationIndexes) && len(pending) > 0 {
+		failure := pending[0]
+		pending = pending[1:]
+		fmt.Println("retrying " + failure.Key + " in " + failure.Index + " for migrate")
+		migrated, err := migrateLegacyRecord(stub, failure.Index, failure.Key)
+		if err != nil {
+			fmt.Println("Could not migrate " + failure.Key + ": " + err.Error())
+			failure.Reason = err.Error()
+			failure.Attempts++
+			report.Failures = append(report.Failures, failure)
+			if failure.Attempts >= MaxMigrationAttempts {
+				progress.FailedRecords = append(progress.FailedRecords, failure)	//skipped past from now on
+			} else {
+				progress.Pending = append(progress.Pending, failure)
+			}
+		} else if migrated {
+			report.Migrated++
+		} else {
+			report.Skipped++
+		}
+		report.Records++
+	}
+	progress.Pending = append(pending, progress.Pending...)
+	progress.Migrated += report.Migrated
+	progress.Failed = len(progress.Pending)
+	if progress.Phase >= len(MigrationIndexes) && len(progress.Pending) == 0 {
+		progress.Done = true
+		err = stub.PutState(SchemaVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = putRecord(stub, MigrationProgressKey, progress)				//resume from here on the next invocation
+	if err != nil {
+		return nil, err
+	}
+	reportKey, err := stub.CreateCompositeKey(MigrationReportObjectType, []string{fmt.Sprintf("%08d", report.Batch)})
+	if err != nil {
+		return nil, err
+	}
+	report.Progress = progress
+	report.Message = "Migration batch " + strconv.Itoa(report.Batch) + " processed"
+	report.Code = "200"
+	err = putRecord(stub, reportKey, report)
+	if err != nil {
+		return nil, err
+	}
+	err = setEvent(stub, "evtsender", report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end migrate")
+	return nil, nil
+}
+// ============================================================================================================================
+// bindRole - bind an identity to the owner, merchant or customer role it may act as
+// ============================================================================================================================
+func (t *ManageLPM) bindRole(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start bindRole")
+	if len(args) != 3 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'identity', 'role' and 'entityId' as arguments")
+	}
+	_, err = requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	res := RoleBinding{Identity: args[0], Role: args[1], EntityID: args[2]}
+	if res.Identity == "" {
+		return errorResponse(ErrInvalidArgument, "Empty identity")
+	}
+
+	// the entity must exist, an owner may be bound before its Owner record is created
+	if res.Role == RoleOwner {
+		if res.EntityID != "" {
+			_, found, err := getOwner(stub, res.EntityID)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return errorResponse(ErrNotFound, res.EntityID + " Not Found.")
+			}
+		}
+	} else if res.Role == RoleMerchant {
+		_, found, err := getMerchant(stub, res.EntityID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return errorResponse(ErrNotFound, res.EntityID + " Not Found.")
+		}
+	} else if res.Role == RoleCustomer {
+		_, found, err := getCustomer(stub, res.EntityID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return errorResponse(ErrNotFound, res.EntityID + " Not Found.")
+		}
+	} else {
+		return errorResponse(ErrInvalidArgument, "Invalid role " + res.Role + ", expecting owner, merchant or customer")
+	}
+
+	err = putRoleBinding(stub, res)										//store RoleBinding with identity as key
+	if err != nil {
+		return nil, err
+	}
+	err = setEvent(stub, "evtsender", Event{Message: res.Identity + " bound as " + res.Role + " " + res.EntityID, Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end bindRole")
+	return nil, nil
+}
+// ============================================================================================================================
+// unbindRole - remove the role bound to an identity
+// ============================================================================================================================
+func (t *ManageLPM) unbindRole(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start unbindRole")
+	if len(args) != 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 'identity' as an argument")
+	}
+	caller, err := requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	identity := args[0]
+	if identity == caller.Identity {
+		return errorResponse(ErrFailedPrecondition, "An owner cannot unbind itself")		//keeps at least one owner bound
+	}
+	_, found, err := getRoleBinding(stub, identity)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return errorResponse(ErrNotFound, identity + " Not Found.")
+	}
+	key, err := getRecordKey(stub, RoleBindingObjectType, identity)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(key)											//remove the RoleBinding from chaincode
+	if err != nil {
+		return errorResponse(ErrInternal, "Failed to delete state")
+	}
+	err = setEvent(stub, "evtsender", Event{Message: identity + " unbound", Code: "200"})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end unbindRole")
+	return nil, nil
+}
+// ============================================================================================================================
+// rebuildCounters - recompute every Merchant's and Campaign's counters from the stored merchants, customers and transactions,
+// one bounded batch of records per invocation, resumed from the bookmark the previous batch returned, meant to run while
+// nothing else writes since a record changed after its batch would be counted twice
+// ============================================================================================================================
+func (t *ManageLPM) rebuildCounters(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var batchSize = RebuildBatchSize
+	var bookmark string
+	fmt.Println("start rebuildCounters")
+	if len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting optionally 'batchSize' and 'bookmark' as arguments")
+	}
+	if len(args) > 0 && args[0] != "" {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 || size > MaxRebuildBatchSize {
+			return errorResponse(ErrInvalidArgument, "Invalid batchSize " + args[0] + ", expecting 1 to " + strconv.Itoa(MaxRebuildBatchSize))
+		}
+		batchSize = size
+	}
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+	// the bookmark is the phase followed by the last key looked at in it, which as a composite key starts with "\x00"
+	phase, lastKey := bookmark, ""
+	if i := strings.Index(bookmark, "\x00"); i >= 0 {
+		phase, lastKey = bookmark[:i], bookmark[i:]
+	}
+	phaseIndex := 0
+	if phase != "" {
+		phaseIndex = -1
+		for i, name := range RebuildPhases {
+			if name == phase {
+				phaseIndex = i
+			}
+		}
+		if phaseIndex < 0 {
+			return errorResponse(ErrInvalidArgument, "Invalid bookmark " + strconv.Quote(bookmark))
+		}
+	}
+
+	report := RebuildReport{}
+	for ; phaseIndex < len(RebuildPhases); phaseIndex++ {
+		lastKey, err = rebuildCountersPhase(stub, RebuildPhases[phaseIndex], lastKey, batchSize, &report)
+		if err != nil {
+			return nil, err
+		}
+		if report.Records == batchSize {
+			report.Bookmark = RebuildPhases[phaseIndex] + lastKey			//more records may follow, resume after the last one looked at
+			break
+		}
+		lastKey = ""
+	}
+
+	report.Message = "Counters rebuilt"
+	if report.Bookmark != "" {
+		report.Message = "Counters rebuilt up to " + strconv.Quote(report.Bookmark)
+	}
+	report.Code = "200"
+	err = setEvent(stub, "evtsender", report)
+	if err != nil {
+		return nil, err
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end rebuildCounters")
+	return jsonResp, nil
+}
+// ============================================================================================================================
+// rebuildCountersPhase - look at the records of one phase of rebuildCounters after lastKey until the report holds batchSize,
+// the stored counters are deleted, merchants get counters again, active customers count their holdings and transactions
+// their points, the last key looked at is returned -- Internal Function
+// ============================================================================================================================
+func rebuildCountersPhase(stub shim.ChaincodeStubInterface, phase string, lastKey string, batchSize int, report *RebuildReport) (string, error) {
+	objectTypes := map[string][]string{
+		"counters": []string{MerchantCountersObjectType, MerchantCountersDeltaObjectType, CampaignCountersObjectType, CampaignCountersDeltaObjectType, NetworkCountersObjectType},
+		"merchants": []string{MerchantObjectType},
+		"customers": []string{CustomerObjectType},
+		"transactions": []string{TransactionObjectType},
+	}[phase]
+	for _, objectType := range objectTypes {
+		resultsIter, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return "", err
+		}
+		defer resultsIter.Close()
+		for resultsIter.HasNext() {
+			if report.Records == batchSize {
+				return lastKey, nil
+			}
+			queryResponse, err := resultsIter.Next()
+			if err != nil {
+				return "", err
+			}
+			if phase != "counters" && queryResponse.Key <= lastKey {		//deleted counters are gone, the others were looked at already
+				continue
+			}
+			report.Records++
+			lastKey = queryResponse.Key
+			if phase == "counters" {
+				err = stub.DelState(queryResponse.Key)
+			} else if phase == "merchants" {
+				res := Merchant{}
+				err = json.Unmarshal(queryResponse.Value, &res)
+				if err == nil {
+					err = setMerchantCountersInactive(stub, res.MerchantID, !res.isActive())
+				}
+			} else if phase == "customers" {
+				res := Customer{}
+				err = json.Unmarshal(queryResponse.Value, &res)
+				if err == nil {
+					err = adjustHoldingCounters(stub, nil, countedHoldings(res))
+				}
+			} else {
+				res := Transaction{}
+				err = json.Unmarshal(queryResponse.Value, &res)
+				if err == nil {
+					err = addTransactionCounters(stub, res)
+				}
+			}
+			if err != nil {
+				return "", errors.New("Failed to rebuild counters from " + strconv.Quote(queryResponse.Key) + ": " + err.Error())
+			}
+		}
+	}
+	return lastKey, nil
+}
+// ============================================================================================================================
+// foldCounters - fold a bounded batch of counter deltas into the Merchant and Campaign counters they add to and delete them,
+// invoked until the report says it is done. Only the days that ended CounterFoldLag before this transaction are read, a day
+// at a time under its own partial key, so transactions counting meanwhile never write into a range this one read
+// ============================================================================================================================
+func (t *ManageLPM) foldCounters(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var batchSize = FoldBatchSize
+	fmt.Println("start foldCounters")
+	if len(args) > 1 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting optionally 'batchSize' as an argument")
+	}
+	if len(args) == 1 && args[0] != "" {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 || size > MaxFoldBatchSize {
+			return errorResponse(ErrInvalidArgument, "Invalid batchSize " + args[0] + ", expecting 1 to " + strconv.Itoa(MaxFoldBatchSize))
+		}
+		batchSize = size
+	}
+	_, err := requireRole(stub, RoleBinding{Role: RoleOwner})
+	if err != nil {
+		return nil, err
+	}
+
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := time.Parse(time.RFC3339, transactionDateTime)
+	if err != nil {
+		return nil, err
+	}
+	report := FoldReport{Done: true}
+	for _, objectType := range []string{MerchantCountersDeltaObjectType, CampaignCountersDeltaObjectType} {
+		day, err := getOldestDeltaDay(stub, objectType)
+		if err != nil {
+			return nil, err
+		}
+		for day != "" {
+			dayStart, err := time.Parse("2006-01-02", day)
+			if err != nil {
+				return nil, errors.New("Malformed day " + strconv.Quote(day) + " in the key of a counter delta")
+			}
+			nextDay := dayStart.AddDate(0, 0, 1)
+			if nextDay.Add(CounterFoldLag).After(now) {
+				break												//still counted into
+			}
+			folded, more, err := foldCountersDay(stub, objectType, day, batchSize - report.Folded)
+			report.Folded += folded
+			if err != nil {
+				return errorResponse(ErrInternal, err.Error())
+			}
+			if more {
+				report.Done = false										//more deltas follow
+				break
+			}
+			day = nextDay.Format("2006-01-02")
+		}
+		if !report.Done {
+			break
+		}
+	}
+
+	report.Message = "Counter deltas folded: " + strconv.Itoa(report.Folded)
+	report.Code = "200"
+	err = setEvent(stub, "evtsender", report)
+	if err != nil {
+		return nil, err
+	}
+	jsonResp, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("jsonResp : " + string(jsonResp))
+	fmt.Println("end foldCounters")
+	return jsonResp, nil
+}
+// ============================================================================================================================
+// getLegacyIndexPage - the entries of indexStr from progress.Position to the end of its chunk. The first call for an index reads
+// the array once and splits it into chunks under migrationIndexChunk~<index>~<chunk>, later batches read only the chunks they
+// walk -- Internal Function
+// ============================================================================================================================
+func getLegacyIndexPage(stub shim.ChaincodeStubInterface, indexStr string, progress *MigrationProgress) ([]string, error) {
+	if !progress.Split {
+		var legacyIndex []string
+		_, err := getRecord(stub, indexStr, &legacyIndex)
+		if err != nil {
+			return nil, err
+		}
+		for start := 0; start < len(legacyIndex); start += MigrationIndexChunkSize {
+			end := start + MigrationIndexChunkSize
+			if end > len(legacyIndex) {
+				end = len(legacyIndex)
+			}
+			chunkKey, err := stub.CreateCompositeKey(MigrationIndexChunkObjectType, []string{indexStr, fmt.Sprintf("%08d", start / MigrationIndexChunkSize)})
+			if err != nil {
+				return nil, err
+			}
+			err = putRecord(stub, chunkKey, legacyIndex[start:end])
+			if err != nil {
+				return nil, err
+			}
+		}
+		progress.Split = true
+		progress.Length = len(legacyIndex)
+	}
+	if progress.Position >= progress.Length {
+		return nil, nil
+	}
+	chunkKey, err := stub.CreateCompositeKey(MigrationIndexChunkObjectType, []string{indexStr, fmt.Sprintf("%08d", progress.Position / MigrationIndexChunkSize)})
+	if err != nil {
+		return nil, err
+	}
+	var chunk []string
+	found, err := getRecord(stub, chunkKey, &chunk)
+	if err != nil {
+		return nil, err
+	}
+	if !found || progress.Position % MigrationIndexChunkSize >= len(chunk) {
+		return nil, errors.New("Missing chunk of " + indexStr + " at position " + strconv.Itoa(progress.Position))
+	}
+	return chunk[progress.Position % MigrationIndexChunkSize:], nil
+}
+// ============================================================================================================================
+// deleteLegacyIndexChunks - delete the chunks getLegacyIndexPage split an index of length entries into -- Internal Function
+// ============================================================================================================================
+func deleteLegacyIndexChunks(stub shim.ChaincodeStubInterface, indexStr string, length int) error {
+	for start := 0; start < length; start += MigrationIndexChunkSize {
+		chunkKey, err := stub.CreateCompositeKey(MigrationIndexChunkObjectType, []string{indexStr, fmt.Sprintf("%08d", start / MigrationIndexChunkSize)})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(chunkKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+// ============================================================================================================================
+// migrateLegacyRecord - move one record from its plain key to its composite key, false when it was already migrated -- Internal Function
+// ============================================================================================================================
+func migrateLegacyRecord(stub shim.ChaincodeStubInterface, indexStr string, key string) (bool, error) {
+	recordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, errors.New("Failed to get state for " + key)
+	}
+	if len(recordAsBytes) == 0 {
+		// listed twice, or moved by an earlier batch that was retried
+		migrated, err := isMigrated(stub, indexStr, key)
+		if err != nil {
+			return false, err
+		}
+		if migrated {
+			return false, nil
+		}
+		return false, errors.New("Record not found")
+	}
+
+	if indexStr == OwnerIndexStr {
+		res := Owner{}
+		err = json.Unmarshal(recordAsBytes, &res)
+		if err != nil {
+			return false, err
+		}
+		if res.OwnerID != key {
+			return false, errors.New("Record is not an Owner")
+		}
+		err = putOwner(stub, res)
+	} else if indexStr == MerchantIndexStr {
+		legacy := LegacyMerchant{}
+		err = json.Unmarshal(recordAsBytes, &legacy)
+		if err != nil {
+			return false, err
+		}
+		if legacy.MerchantID != key {
+			return false, errors.New("Record is not a Merchant")
+		}
+		var res Merchant
+		res, err = merchantFromLegacyMerchant(legacy)
+		if err != nil {
+			return false, err
+		}
+		err = putMerchant(stub, res)
+		if err == nil {
+			err = setMerchantCountersInactive(stub, res.MerchantID, !res.isActive())	//its customers and transactions are counted as they are migrated
+		}
+	} else if indexStr == CustomerIndexStr {
+		legacy := LegacyCustomer{}
+		err = json.Unmarshal(recordAsBytes, &legacy)
+		if err != nil {
+			return false, err
+		}
+		if legacy.CustomerID != key {
+			return false, errors.New("Record is not a Customer")
+		}
+		var res Customer
+		res, err = holdingsFromLegacyCustomer(stub, legacy)
+		if err != nil {
+			return false, err
+		}
+		err = putCustomer(stub, res)
+		if err == nil {
+			err = indexCustomerHoldings(stub, res)
+		}
+	} else if indexStr == TransactionIndexStr {
+		legacy := LegacyTransaction{}
+		err = json.Unmarshal(recordAsBytes, &legacy)
+		if err != nil {
+			return false, err
+		}
+		if legacy.TransactionID != key {
+			return false, errors.New("Record is not a Transaction")
+		}
+		var res Transaction
+		res, err = transactionFromLegacyTransaction(stub, legacy)
+		if err != nil {
+			return false, err
+		}
+		err = putTransaction(stub, res)
+	} else {
+		return false, errors.New("Unknown legacy index " + indexStr)
+	}
+	if err != nil {
+		return false, err
+	}
+	err = stub.DelState(key)											//the plain key is only kept for records that failed
+	if err != nil {
+		return false, errors.New("Failed to delete state for " + key)
+	}
+	return true, nil
+}
+// ============================================================================================================================
+// isMigrated - true when the record listed under key in indexStr is already stored under its composite key -- Internal Function
+// ============================================================================================================================
+func isMigrated(stub shim.ChaincodeStubInterface, indexStr string, key string) (bool, error) {
+	var objectType string
+	if indexStr == OwnerIndexStr {
+		objectType = OwnerObjectType
+	} else if indexStr == MerchantIndexStr {
+		objectType = MerchantObjectType
+	} else if indexStr == CustomerIndexStr {
+		objectType = CustomerObjectType
+	} else {
+		objectType = TransactionObjectType
+	}
+	recordKey, err := getRecordKey(stub, objectType, key)
+	if err != nil {
+		return false, err
+	}
+	recordAsBytes, err := stub.GetState(recordKey)
+	if err != nil {
+		return false, errors.New("Failed to get state for " + key)
+	}
+	return len(recordAsBytes) > 0, nil
+}
+// ============================================================================================================================
+// holdingsFromLegacyCustomer - build a Customer with holdings from the comma separated merchant columns -- Internal Function
+// ============================================================================================================================
+func holdingsFromLegacyCustomer(stub shim.ChaincodeStubInterface, legacy LegacyCustomer) (Customer, error) {
+	res := Customer{}
+	res.CustomerID = legacy.CustomerID
+	res.UserName = legacy.UserName
+	res.CustomerName = legacy.CustomerName
+	walletWorth, err := parseLegacyMoney(legacy.WalletWorth, DefaultCurrency)
+	if err != nil {
+		return res, errors.New("walletWorth: " + err.Error())
+	}
+	res.WalletWorth = walletWorth
+	res.Holdings = []MerchantHolding{}
+	if len(legacy.Holdings) > 0 {
+		// already holdings, only the amounts may still be decimal strings without a currency
+		for _, holding := range legacy.Holdings {
+			if holding.MerchantID == "" {
+				return res, errors.New("holding without a merchantId")
+			}
+			if holding.Worth.Currency == "" {
+				holding.Worth.Currency = holding.Currency
+			}
+			res.Holdings = append(res.Holdings, holding)
+		}
+		return res, nil
+	}
+	if legacy.MerchantIDs == "" {
+		return res, nil												// not associated with any merchant yet
+	}
+
+	// merchantIDs is the only column that can be trusted to split cleanly, names may themselves contain commas
+	merchantIDs := strings.Split(legacy.MerchantIDs, ",")
+	merchantNames := strings.Split(legacy.MerchantNames, ",")
+	merchantColors := strings.Split(legacy.MerchantColors, ",")
+	merchantCurrencies := strings.Split(legacy.MerchantCurrencies, ",")
+	merchantsPointsCount := strings.Split(legacy.MerchantsPointsCount, ",")
+	merchantsPointsWorth := strings.Split(legacy.MerchantsPointsWorth, ",")
+	if len(merchantsPointsCount) != len(merchantIDs) || len(merchantsPointsWorth) != len(merchantIDs) {
+		return res, errors.New("points columns do not line up with merchantIDs")
+	}
+	for i,merchantId := range merchantIDs{
+		points, err := ParsePoints(merchantsPointsCount[i])
+		if err != nil {
+			return res, errors.New("points for " + merchantId + ": " + err.Error())
+		}
+		worth, err := ParseMoney(merchantsPointsWorth[i], "")
+		if err != nil {
+			return res, errors.New("worth for " + merchantId + ": " + err.Error())
+		}
+		holding := MerchantHolding{
+			MerchantID: merchantId,
+			Points: points,
+			Worth: worth,
+		}
+		res_Merchant, found, err := getMerchant(stub, merchantId)
+		if err != nil {
+			return res, err
+		}
+		if found {
+			holding.MerchantName = res_Merchant.MerchantName
+			holding.MerchantColor = res_Merchant.IndustryColor
+			holding.Currency = res_Merchant.MerchantCurrency
+			holding.Worth.Currency = res_Merchant.MerchantCurrency
+		} else {
+			if len(merchantNames) == len(merchantIDs) {
+				holding.MerchantName = merchantNames[i]
+			}
+			if len(merchantColors) == len(merchantIDs) {
+				holding.MerchantColor = merchantColors[i]
+			}
+			if len(merchantCurrencies) == len(merchantIDs) {
+				currency, err := parseCurrency(merchantCurrencies[i])
+				if err != nil {
+					return res, errors.New("currency for " + merchantId + ": " + err.Error())
+				}
+				holding.Currency = currency
+				holding.Worth.Currency = currency
+			}
+		}
+		res.Holdings = append(res.Holdings, holding)
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// merchantFromLegacyMerchant - convert a Merchant whose amounts were stored as decimal strings -- Internal Function
+// ============================================================================================================================
+func merchantFromLegacyMerchant(legacy LegacyMerchant) (Merchant, error) {
+	res := Merchant{}
+	res.MerchantID = legacy.MerchantID
+	res.MerchantUserName = legacy.MerchantUserName
+	res.MerchantName = legacy.MerchantName
+	res.MerchantIndustry = legacy.MerchantIndustry
+	res.IndustryColor = legacy.IndustryColor
+	res.MerchantCU_date = legacy.MerchantCU_date
+	merchantCurrency, err := parseCurrency(legacy.MerchantCurrency)
+	if err != nil {
+		return res, errors.New("merchantCurrency: " + err.Error())
+	}
+	res.MerchantCurrency = merchantCurrency
+	pointsPerDollarSpent, err := ParseRate(legacy.PointsPerDollarSpent)
+	if err != nil {
+		return res, errors.New("pointsPerDollarSpent: " + err.Error())
+	}
+	res.PointsPerDollarSpent = pointsPerDollarSpent
+	exchangeRate, err := ParseRate(legacy.ExchangeRate)
+	if err != nil {
+		return res, errors.New("exchangeRate: " + err.Error())
+	}
+	res.ExchangeRate = exchangeRate
+	purchaseBalance, err := parseLegacyMoney(legacy.PurchaseBalance, res.MerchantCurrency)
+	if err != nil {
+		return res, errors.New("purchaseBalance: " + err.Error())
+	}
+	res.PurchaseBalance = purchaseBalance
+	// merchantInitialBalance from manageLPMOrig is what was left of a fixed float after CustomerOnBoarding grants,
+	// those grants are in the transactions so it is not carried over
+	return res, nil
+}
+// ============================================================================================================================
+// transactionFromLegacyTransaction - convert a Transaction whose points were stored as decimal strings -- Internal Function
+// ============================================================================================================================
+func transactionFromLegacyTransaction(stub shim.ChaincodeStubInterface, legacy LegacyTransaction) (Transaction, error) {
+	res := Transaction{}
+	res.TransactionID = legacy.TransactionID
+	transactionDateTime, err := normalizeLegacyDateTime(legacy.TransactionDateTime)
+	if err != nil {
+		return res, errors.New("transactionDateTime: " + err.Error())
+	}
+	res.TransactionDateTime = transactionDateTime						// the time ordered index sorts on it as text
+	res.TransactionType = legacy.TransactionType
+	res.TransactionFrom = legacy.TransactionFrom
+	res.TransactionTo = legacy.TransactionTo
+	res.CustomerID = legacy.CustomerID
+	credit, err := parseLegacyPoints(legacy.Credit)
+	if err != nil {
+		return res, errors.New("credit: " + err.Error())
+	}
+	res.Credit = credit
+	debit, err := parseLegacyPoints(legacy.Debit)
+	if err != nil {
+		return res, errors.New("debit: " + err.Error())
+	}
+	res.Debit = debit
+
+	// legacy transactions only name the merchant, customers are migrated first so their holdings can resolve it
+	if res.CustomerID != "" {
+		res_Customer, found, err := getCustomer(stub, res.CustomerID)
+		if err != nil {
+			return res, err
+		}
+		if found {
+			res.MerchantID = getMerchantIdByName(res_Customer, res.TransactionFrom, res.TransactionTo)
+		}
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// normalizeLegacyDateTime - a date older clients wrote in a format of their own as RFC 3339 in UTC -- Internal Function
+// ============================================================================================================================
+func normalizeLegacyDateTime(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if i := strings.Index(value, " ("); i > 0 {
+		value = value[:i]												// Date.toString() ends in the zone name, e.g. (UTC)
+	}
+	for _, layout := range LegacyDateTimeLayouts {
+		dateTime, err := time.Parse(layout, value)
+		if err == nil {
+			return dateTime.UTC().Format(time.RFC3339), nil
+		}
+	}
+	return "", errors.New(strconv.Quote(value) + " is not a date in a known format")
+}
+// ============================================================================================================================
+// parseLegacyMoney - ParseMoney, reading the empty string older chaincode versions wrote for nothing as zero -- Internal Function
+// ============================================================================================================================
+func parseLegacyMoney(value string, currency string) (Money, error) {
+	if strings.TrimSpace(value) == "" {
+		value = "0"
+	}
+	return ParseMoney(value, currency)
+}
+// ============================================================================================================================
+// parseLegacyPoints - ParsePoints, reading the empty string older chaincode versions wrote for nothing as zero -- Internal Function
+// ============================================================================================================================
+func parseLegacyPoints(value string) (Points, error) {
+	if strings.TrimSpace(value) == "" {
+		value = "0"
+	}
+	return ParsePoints(value)
+}
+// ============================================================================================================================
+// getSchemaVersion - schema version of the ledger, LegacySchemaVersion when none was ever written -- Internal Function
+// ============================================================================================================================
+func getSchemaVersion(stub shim.ChaincodeStubInterface) (int, error) {
+	versionAsBytes, err := stub.GetState(SchemaVersionKey)
+	if err != nil {
+		return 0, errors.New("Failed to get state for " + SchemaVersionKey)
+	}
+	if len(versionAsBytes) == 0 {
+		return LegacySchemaVersion, nil
+	}
+	version, err := strconv.Atoi(string(versionAsBytes))
+	if err != nil {
+		return 0, errors.New("Malformed record stored for " + SchemaVersionKey + ": " + err.Error())
+	}
+	return version, nil
+}
+// ============================================================================================================================
+// hasLegacyIndexes - true when any index array written by earlier chaincode versions is present -- Internal Function
+// ============================================================================================================================
+func hasLegacyIndexes(stub shim.ChaincodeStubInterface) (bool, error) {
+	for _, indexStr := range MigrationIndexes {
+		indexAsBytes, err := stub.GetState(indexStr)
+		if err != nil {
+			return false, errors.New("Failed to get state for " + indexStr)
+		}
+		if len(indexAsBytes) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+// ============================================================================================================================
+// getHoldingIndex - position of a merchant in the customer's holdings, -1 if the customer is not associated -- Internal Function
+// ============================================================================================================================
+func getHoldingIndex(res Customer, merchantId string) int {
+	for i,holding := range res.Holdings{
+		if holding.MerchantID == merchantId{
+			return i
+		}
+	}
+	return -1
+}
+// ============================================================================================================================
+// creditHolding - add points to a holding with their worth at the Merchant's exchangeRate -- Internal Function
+// ============================================================================================================================
+func creditHolding(stub shim.ChaincodeStubInterface, res_Merchant Merchant, holding *MerchantHolding, points Points, dateTime string) error {
+	worth, err := holdingPointsWorth(stub, res_Merchant, *holding, points, dateTime)
+	if err != nil {
+		return err
+	}
+	holding.Points, err = holding.Points.Add(points)
+	if err != nil {
+		return newError(ErrInvalidArgument, "Failed to credit points with " + holding.MerchantID + ": " + err.Error())
+	}
+	holding.Worth, err = holding.Worth.Add(worth)
+	if err != nil {
+		return newError(ErrInvalidArgument, "Failed to credit points with " + holding.MerchantID + ": " + err.Error())
+	}
+	return nil
+}
+// ============================================================================================================================
+// debitHolding - take points from a holding, the worth left is in proportion to the points left -- Internal Function
+// ============================================================================================================================
+func debitHolding(holding *MerchantHolding, points Points, customerId string) error {
+	if holding.Points < points {
+		return newError(ErrInsufficientPoints, "Insufficient points with " + holding.MerchantID + " for " + customerId)
+	}
+	remaining := holding.Points - points
+	worth, err := mulDiv(holding.Worth.Units, int64(remaining), int64(holding.Points))
+	if err != nil {
+		return err
+	}
+	holding.Points = remaining
+	holding.Worth = Money{Units: worth, Currency: holding.Worth.Currency}
+	return nil
+}
+// ============================================================================================================================
+// moveWalletWorth - move the customer's walletWorth by what the worth of one of its holdings changed by, converted into
+// the wallet's currency at the rate in effect at dateTime -- Internal Function
+// ============================================================================================================================
+func moveWalletWorth(stub shim.ChaincodeStubInterface, res *Customer, before Money, after Money, dateTime string) error {
+	walletCurrency := res.WalletWorth.Currency
+	if walletCurrency == "" {
+		walletCurrency = DefaultCurrency
+	}
+	change := Money{Units: after.Units - before.Units, Currency: after.Currency}
+	if change.Currency == "" {
+		change.Currency = DefaultCurrency
+	}
+	change, err := convertMoney(stub, change, walletCurrency, dateTime)
+	if err != nil {
+		return err
+	}
+	res.WalletWorth, err = res.WalletWorth.Add(change)
+	if err != nil {
+		return newError(ErrInvalidArgument, "Failed to update walletWorth for " + res.CustomerID + ": " + err.Error())
+	}
+	return nil
+}
+// ============================================================================================================================
+// replayRequest - for an externalReference the caller used before, true and the first result when the request is the same
+// retried, its first event is sent again, an ALREADY_EXISTS error when the caller used the reference for a different request,
+// empty references are never matched. Called right after the arguments and role are checked, so a retry is answered
+// whatever the ledger says now -- Internal Function
+// ============================================================================================================================
+func replayRequest(stub shim.ChaincodeStubInterface, caller string, function string, args []string, externalReferences ...string) (bool, []byte, error) {
+	fingerprint, err := requestFingerprint(function, args)
+	if err != nil {
+		return false, nil, err
+	}
+	for _, externalReference := range externalReferences {
+		if externalReference == "" {
+			continue
+		}
+		res := ProcessedRequest{}
+		key, err := stub.CreateCompositeKey(ProcessedRequestObjectType, []string{caller, externalReference})
+		if err != nil {
+			return false, nil, newError(ErrInvalidArgument, "Invalid externalReference " + strconv.Quote(externalReference) + ": " + err.Error())
+		}
+		found, err := getRecord(stub, key, &res)
+		if err != nil {
+			return false, nil, err
+		}
+		if found {
+			if res.Fingerprint != fingerprint {
+				return false, nil, newError(ErrAlreadyExists, "externalReference " + externalReference + " was already used by a different request")
+			}
+			fmt.Println("Replaying " + function + " for externalReference " + externalReference)
+			if res.Event != nil {
+				err = setEvent(stub, "evtsender", *res.Event)
+				if err != nil {
+					return false, nil, err
+				}
+			}
+			return true, res.Result, nil
+		}
+		// Transactions written before ids were generated are stored under the client's id, a retry of one cannot be matched
+		_, found, err = getTransaction(stub, externalReference)
+		if err != nil {
+			return false, nil, err
+		}
+		if found {
+			return false, nil, newError(ErrAlreadyExists, "Transaction " + externalReference + " already exists")
+		}
+	}
+	return false, nil, nil
+}
+// ============================================================================================================================
+// recordRequest - remember the request the caller made with each externalReference, its result and its event, for
+// replayRequest -- Internal Function
+// ============================================================================================================================
+func recordRequest(stub shim.ChaincodeStubInterface, caller string, function string, args []string, result []byte, event Event, externalReferences ...string) error {
+	fingerprint, err := requestFingerprint(function, args)
+	if err != nil {
+		return err
+	}
+	for _, externalReference := range externalReferences {
+		if externalReference == "" {
+			continue
+		}
+		key, err := stub.CreateCompositeKey(ProcessedRequestObjectType, []string{caller, externalReference})
+		if err != nil {
+			return err
+		}
+		err = putRecord(stub, key, ProcessedRequest{ExternalReference: externalReference, Caller: caller, Function: function, Fingerprint: fingerprint, Result: result, Event: &event})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+// ============================================================================================================================
+// requestFingerprint - sha256 of the function name and its arguments, hex encoded -- Internal Function
+// ============================================================================================================================
+func requestFingerprint(function string, args []string) (string, error) {
+	requestAsBytes, err := json.Marshal(append([]string{function}, args...))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(requestAsBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+// ============================================================================================================================
+// newTransactionId - the id of leg n of the Transactions one invocation writes, <Fabric txid>-<n> -- Internal Function
+// ============================================================================================================================
+func newTransactionId(stub shim.ChaincodeStubInterface, leg int) string {
+	return stub.GetTxID() + "-" + strconv.Itoa(leg)
+}
+// ============================================================================================================================
+// checkTransactionType - the transactionType a call writes, what the client passed must be it or empty since the type
+// decides how the Transaction is counted -- Internal Function
+// ============================================================================================================================
+func checkTransactionType(transactionType string, callType string) (string, error) {
+	if transactionType != "" && transactionType != callType {
+		return "", newError(ErrInvalidArgument, "Invalid transactionType " + transactionType + ", expecting " + callType)
+	}
+	return callType, nil
+}
+// ============================================================================================================================
+// getTxDateTime - the proposal timestamp in RFC 3339, the same on every endorsing peer -- Internal Function
+// ============================================================================================================================
+func getTxDateTime(stub shim.ChaincodeStubInterface) (string, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", errors.New("Failed to read the proposal timestamp: " + err.Error())
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC().Format(time.RFC3339), nil
+}
+// ============================================================================================================================
+// settleHolding - empty a holding, the Transaction returned debits the points it held as leg of this invocation
+// -- Internal Function
+// ============================================================================================================================
+func settleHolding(stub shim.ChaincodeStubInterface, holding *MerchantHolding, transactionType string, userName string, leg int, transactionDateTime string) Transaction {
+	res_trans := Transaction{}
+	res_trans.TransactionID = newTransactionId(stub, leg)
+	res_trans.TransactionDateTime = transactionDateTime
+	res_trans.TransactionType = transactionType
+	res_trans.TransactionFrom = userName
+	res_trans.TransactionTo = holding.MerchantName
+	res_trans.Credit = 0
+	res_trans.Debit = holding.Points
+	res_trans.MerchantID = holding.MerchantID
+	holding.Points = 0
+	holding.Worth = Money{Units: 0, Currency: holding.Worth.Currency}
+	return res_trans
+}
+// ============================================================================================================================
+// reconcileLots - bring the lots of a holding in line with its points, points gained are a lot earned at transactionDateTime
+// and points lost are taken from the oldest lots, a holding from before lots starts with one lot of the points it had
+// when it joined -- Internal Function
+// ============================================================================================================================
+func reconcileLots(holding *MerchantHolding, previous *MerchantHolding, transactionDateTime string) {
+	if len(holding.Lots) == 0 {
+		seed := holding.Points
+		if previous != nil {
+			seed = previous.Points
+		}
+		if seed > 0 {
+			holding.Lots = []PointsLot{{EarnedDate: holding.JoinedDate, Points: seed}}
+		}
+	}
+	total := Points(0)
+	for _, lot := range holding.Lots {
+		total += lot.Points
+	}
+	if total == holding.Points {
+		return
+	}
+	holding.LastActivityDate = transactionDateTime
+	if holding.Points > total {
+		holding.Lots = append(holding.Lots, PointsLot{EarnedDate: transactionDateTime, Points: holding.Points - total})
+		return
+	}
+	consumed := total - holding.Points
+	for len(holding.Lots) > 0 && consumed > 0 {
+		if holding.Lots[0].Points > consumed {
+			holding.Lots[0].Points -= consumed
+			break
+		}
+		consumed -= holding.Lots[0].Points
+		holding.Lots = holding.Lots[1:]
+	}
+	if len(holding.Lots) == 0 {
+		holding.Lots = nil
+	}
+}
+// ============================================================================================================================
+// expireHolding - remove the lots of a holding the Merchant's expiry policy says are due at asOf, the Transaction returned
+// debits the points they held as leg of this invocation, it debits nothing when none are due -- Internal Function
+// ============================================================================================================================
+func expireHolding(stub shim.ChaincodeStubInterface, res_Merchant Merchant, holding *MerchantHolding, userName string, leg int, asOf time.Time) (Transaction, error) {
+	if res_Merchant.ExpiryPolicy == "" || res_Merchant.ExpiryPolicy == ExpiryPolicyNone {
+		return Transaction{}, nil
+	}
+	reconcileLots(holding, holding, "")										//a holding from before lots gets its seed lot
+	expired := Points(0)
+	var lots []PointsLot
+	for _, lot := range holding.Lots {
+		expiresAt, expires := lotExpiry(res_Merchant, *holding, lot)
+		if expires && !asOf.Before(expiresAt) {
+			expired += lot.Points
+			continue
+		}
+		lots = append(lots, lot)
+	}
+	if expired <= 0 {
+		return Transaction{}, nil
+	}
+	remaining := holding.Points - expired
+	if remaining < 0 {
+		remaining = 0
+	}
+	// the worth left is in proportion to the points left
+	worthUnits := int64(0)
+	if holding.Points > 0 {
+		var err error
+		worthUnits, err = mulDiv(holding.Worth.Units, int64(remaining), int64(holding.Points))
+		if err != nil {
+			return Transaction{}, err
+		}
+	}
+
+	res_trans := Transaction{}
+	res_trans.TransactionID = newTransactionId(stub, leg)
+	res_trans.TransactionDateTime = asOf.UTC().Format(time.RFC3339)
+	res_trans.TransactionType = ExpiryTransactionType
+	res_trans.TransactionFrom = userName
+	res_trans.TransactionTo = holding.MerchantName
+	res_trans.Credit = 0
+	res_trans.Debit = holding.Points - remaining
+	res_trans.MerchantID = holding.MerchantID
+	holding.Lots = lots
+	holding.Points = remaining
+	holding.Worth = Money{Units: worthUnits, Currency: holding.Worth.Currency}
+	return res_trans, nil
+}
+// ============================================================================================================================
+// lotExpiry - when a lot of a holding expires under the Merchant's expiry policy, false when it never does or its dates
+// cannot be read -- Internal Function
+// ============================================================================================================================
+func lotExpiry(res_Merchant Merchant, holding MerchantHolding, lot PointsLot) (time.Time, bool) {
+	from := lot.EarnedDate
+	if res_Merchant.ExpiryPolicy == ExpiryPolicyInactivity {
+		from = holding.LastActivityDate
+		if from == "" {
+			from = holding.JoinedDate
+		}
+	}
+	fromBound, err := parseDateTimeBound(from, false)
+	if err != nil || fromBound == "" {
+		fmt.Println("Lot of " + holding.MerchantID + " dated " + strconv.Quote(from) + " is not expired, its date cannot be read")
+		return time.Time{}, false
+	}
+	fromTime, _ := time.Parse(time.RFC3339, fromBound)
+	if res_Merchant.ExpiryPolicy == ExpiryPolicyMonths || res_Merchant.ExpiryPolicy == ExpiryPolicyInactivity {
+		return fromTime.AddDate(0, res_Merchant.ExpiryMonths, 0), true
+	}
+	if res_Merchant.ExpiryPolicy == ExpiryPolicyYearEnd {
+		return time.Date(fromTime.Year() + 1, time.January, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+// ============================================================================================================================
+// accrueTier - count credit and the spend it was earned for towards the holding's tier window, move the holding to the tier
+// it now qualifies for and credit it credit times the tier's multiplier, the points credited are returned -- Internal Function
+// ============================================================================================================================
+func accrueTier(stub shim.ChaincodeStubInterface, res_Merchant Merchant, holding *MerchantHolding, credit Points, spend Money, transactionDateTime string) (Points, error) {
+	if len(res_Merchant.Tiers) == 0 {
+		return credit, creditHolding(stub, res_Merchant, holding, credit, transactionDateTime)
+	}
+	if spend.Currency != res_Merchant.MerchantCurrency {
+		return 0, errors.New("Spend in " + spend.Currency + " cannot count towards tiers in " + res_Merchant.MerchantCurrency)
+	}
+	holding.Earnings = tierEarnings(res_Merchant, *holding, transactionDateTime)
+	period := transactionDateTime[:len("2006-01-02")]
+	last := len(holding.Earnings) - 1
+	if last < 0 || holding.Earnings[last].Period != period {
+		holding.Earnings = append(holding.Earnings, EarningPeriod{Period: period, Spend: Money{Units: 0, Currency: res_Merchant.MerchantCurrency}})
+		last++
+	}
+	holding.Earnings[last].Points += credit
+	holding.Earnings[last].Spend.Units += spend.Units
+
+	var multiplier Rate
+	holding.Tier, multiplier = qualifyingTier(res_Merchant, holding.Earnings)
+	total, err := mulDiv(int64(credit), int64(multiplier), 1000000)
+	if err != nil {
+		return 0, err
+	}
+	err = creditHolding(stub, res_Merchant, holding, Points(total), transactionDateTime)
+	if err != nil {
+		return 0, err
+	}
+	return Points(total), nil
+}
+// ============================================================================================================================
+// qualifyingTier - the highest of the Merchant's tiers the earnings reach and its multiplier, no tier and 1 below every tier
+// -- Internal Function
+// ============================================================================================================================
+func qualifyingTier(res_Merchant Merchant, earnings []EarningPeriod) (string, Rate) {
+	qualified := int64(0)
+	for _, earning := range earnings {
+		if res_Merchant.TierBasis == TierBasisSpend {
+			qualified += earning.Spend.Units
+		} else {
+			qualified += int64(earning.Points)
+		}
+	}
+	name := ""
+	multiplier := Rate(1000000)
+	for _, tier := range res_Merchant.Tiers {
+		if qualified >= tier.Threshold {
+			name = tier.Name
+			multiplier = tier.Multiplier
+		}
+	}
+	return name, multiplier
+}
+// ============================================================================================================================
+// tierEarnings - the earnings of a holding within the Merchant's tier window rolling back from transactionDateTime, the days
+// after the same day TierWindowMonths earlier up to the day of transactionDateTime -- Internal Function
+// ============================================================================================================================
+func tierEarnings(res_Merchant Merchant, holding MerchantHolding, transactionDateTime string) []EarningPeriod {
+	asOf, err := time.Parse(time.RFC3339, transactionDateTime)
+	if err != nil || res_Merchant.TierWindowMonths < 1 {
+		return nil
+	}
+	after := asOf.AddDate(0, -res_Merchant.TierWindowMonths, 0).Format("2006-01-02")
+	current := asOf.Format("2006-01-02")
+	var earnings []EarningPeriod
+	for _, earning := range holding.Earnings {
+		day := earning.Period
+		if len(day) == len("2006-01") {
+			day += "-01"
+		}
+		if day > after && day <= current {
+			earnings = append(earnings, earning)
+		}
+	}
+	return earnings
+}
+// ============================================================================================================================
+// reviewTier - move a holding to the tier its earnings within the tier window qualify for at transactionDateTime, dropping
+// the earnings that left the window, false when its tier holds -- Internal Function
+// ============================================================================================================================
+func reviewTier(res_Merchant Merchant, holding *MerchantHolding, transactionDateTime string) bool {
+	if len(res_Merchant.Tiers) == 0 {
+		return false
+	}
+	previousTier := holding.Tier
+	holding.Earnings = tierEarnings(res_Merchant, *holding, transactionDateTime)
+	holding.Tier, _ = qualifyingTier(res_Merchant, holding.Earnings)
+	return holding.Tier != previousTier
+}
+// ============================================================================================================================
+// newTierChange - the Transaction recording a holding moving from previousTier to its tier as leg of this invocation, without
+// customer -- Internal Function
+// ============================================================================================================================
+func newTierChange(stub shim.ChaincodeStubInterface, holding MerchantHolding, previousTier string, leg int, transactionDateTime string) Transaction {
+	res_tier := Transaction{}
+	res_tier.TransactionID = newTransactionId(stub, leg)
+	res_tier.TransactionDateTime = transactionDateTime
+	res_tier.TransactionType = TierChangeTransactionType
+	res_tier.TransactionFrom = previousTier
+	res_tier.TransactionTo = holding.Tier
+	res_tier.Credit = 0
+	res_tier.Debit = 0
+	res_tier.MerchantID = holding.MerchantID
+	return res_tier
+}
+// ============================================================================================================================
+// tierRank - position of a tier in the Merchant's tiers, -1 below every tier or when it has no such tier -- Internal Function
+// ============================================================================================================================
+func tierRank(res_Merchant Merchant, tierName string) int {
+	for i, tier := range res_Merchant.Tiers {
+		if tier.Name == tierName {
+			return i
+		}
+	}
+	return -1
+}
+// ============================================================================================================================
+// applyCampaigns - credit the holding the bonus of every Merchant Campaign an accumulation of credit for spend qualifies for
+// at transactionDateTime, a CampaignBonus Transaction for each, without id, customer and link, is returned -- Internal Function
+// ============================================================================================================================
+func applyCampaigns(stub shim.ChaincodeStubInterface, res_Merchant Merchant, holding *MerchantHolding, credit Points, spend Money, transactionDateTime string) ([]Transaction, error) {
+	var bonuses []Transaction
+	campaignIds, err := getIndexedIds(stub, MerchantCampaignIndex, []string{res_Merchant.MerchantID})
+	if err != nil || len(campaignIds) == 0 {
+		return nil, err
+	}
+	if spend.Currency != res_Merchant.MerchantCurrency {
+		return nil, errors.New("Spend in " + spend.Currency + " cannot qualify for campaigns in " + res_Merchant.MerchantCurrency)
+	}
+	for _, campaignId := range campaignIds {
+		res, found, err := getCampaign(stub, campaignId)
+		if err != nil {
+			return nil, err
+		}
+		if !found || transactionDateTime < res.StartDateTime || transactionDateTime > res.EndDateTime {
+			continue
+		}
+		if spend.Units < res.MinSpend.Units || (res.FirstPurchase && holding.Accruals > 0) {
+			continue
+		}
+		if res.MinTier != "" && (tierRank(res_Merchant, res.MinTier) < 0 || tierRank(res_Merchant, holding.Tier) < tierRank(res_Merchant, res.MinTier)) {
+			continue
+		}
+		bonus := res.FlatBonus
+		if res.BonusType == CampaignBonusMultiplier {
+			total, err := mulDiv(int64(credit), int64(res.Multiplier), 1000000)
+			if err != nil {
+				return nil, err
+			}
+			bonus = Points(total) - credit
+		}
+		if bonus <= 0 {
+			continue
+		}
+		err = creditHolding(stub, res_Merchant, holding, bonus, transactionDateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		res_trans := Transaction{}
+		res_trans.TransactionDateTime = transactionDateTime
+		res_trans.TransactionType = CampaignBonusTransactionType
+		res_trans.TransactionFrom = res_Merchant.MerchantName
+		res_trans.Credit = bonus
+		res_trans.Debit = 0
+		res_trans.MerchantID = res_Merchant.MerchantID
+		res_trans.CampaignID = campaignId
+		bonuses = append(bonuses, res_trans)
+	}
+	return bonuses, nil
+}
+// ============================================================================================================================
+// parseCurrency - an ISO 4217 currency code in upper case, DefaultCurrency when empty -- Internal Function
+// ============================================================================================================================
+func parseCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	if len(code) != 3 {
+		return "", errors.New(strconv.Quote(code) + " is not an ISO 4217 currency code")
+	}
+	for _, letter := range code {
+		if letter < 'A' || letter > 'Z' {
+			return "", errors.New(strconv.Quote(code) + " is not an ISO 4217 currency code")
+		}
+	}
+	return code, nil
+}
+// ============================================================================================================================
+// parseAmount - an amount optionally followed by its ISO 4217 currency code, as in "12.50 EUR", in currency when it has
+// none -- Internal Function
+// ============================================================================================================================
+func parseAmount(value string, currency string) (Money, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 2 {
+		var err error
+		currency, err = parseCurrency(fields[1])
+		if err != nil {
+			return Money{}, err
+		}
+		value = fields[0]
+	} else if len(fields) > 2 {
+		return Money{}, errors.New(strconv.Quote(value) + " is not an amount")
+	}
+	return ParseMoney(value, currency)
+}
+// ============================================================================================================================
+// getFxRateAt - the rate of baseCurrency in quoteCurrency in effect at dateTime, the latest one of the pair or the reverse
+// of the latest one of the reverse pair, whichever took effect last by then, the pair itself on the same effectiveDateTime
+// -- Internal Function
+// ============================================================================================================================
+func getFxRateAt(stub shim.ChaincodeStubInterface, baseCurrency string, quoteCurrency string, dateTime string) (FxRate, error) {
+	if baseCurrency == quoteCurrency {
+		return FxRate{BaseCurrency: baseCurrency, QuoteCurrency: quoteCurrency, Rate: 1000000}, nil
+	}
+	res, found, err := getLatestFxRate(stub, baseCurrency, quoteCurrency, dateTime)
+	if err != nil {
+		return FxRate{}, err
+	}
+	reverse, reverseFound, err := getLatestFxRate(stub, quoteCurrency, baseCurrency, dateTime)
+	if err != nil {
+		return FxRate{}, err
+	}
+	if found && (!reverseFound || res.EffectiveDateTime >= reverse.EffectiveDateTime) {
+		return res, nil
+	}
+	if !reverseFound {
+		return FxRate{}, newError(ErrFailedPrecondition, "No FX rate from " + baseCurrency + " to " + quoteCurrency + " is in effect at " + dateTime)
+	}
+	// millionths * millionths / millionths of the reverse rate
+	rate, err := mulDiv(1000000, 1000000, int64(reverse.Rate))
+	if err != nil || rate <= 0 {
+		return FxRate{}, newError(ErrFailedPrecondition, "The FX rate from " + quoteCurrency + " to " + baseCurrency + " cannot be reversed")
+	}
+	return FxRate{BaseCurrency: baseCurrency, QuoteCurrency: quoteCurrency, Rate: Rate(rate), EffectiveDateTime: reverse.EffectiveDateTime}, nil
+}
+// ============================================================================================================================
+// getLatestFxRate - the last rate of the pair effective at or before dateTime in the FX table, rates are keyed newest first
+// so it is the first one ranged from the key of dateTime on and only that one is read -- Internal Function
+// ============================================================================================================================
+func getLatestFxRate(stub shim.ChaincodeStubInterface, baseCurrency string, quoteCurrency string, dateTime string) (FxRate, bool, error) {
+	prefix, err := getFxRateKey(stub, baseCurrency, quoteCurrency, "")
+	if err != nil {
+		return FxRate{}, false, err
+	}
+	startKey, err := getFxRateKey(stub, baseCurrency, quoteCurrency, dateTime)
+	if err != nil {
+		return FxRate{}, false, err
+	}
+	ratesIter, err := stub.GetStateByRange(startKey, prefix[:len(prefix)-1] + "\x01")
+	if err != nil {
+		return FxRate{}, false, err
+	}
+	defer ratesIter.Close()
+	if !ratesIter.HasNext() {
+		return FxRate{}, false, nil
+	}
+	queryResponse, err := ratesIter.Next()
+	if err != nil {
+		return FxRate{}, false, err
+	}
+	res := FxRate{}
+	err = json.Unmarshal(queryResponse.Value, &res)
+	if err != nil {
+		return FxRate{}, false, errors.New("Malformed record stored for " + strconv.Quote(queryResponse.Key) + ": " + err.Error())
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// getFxRateKey - key of the rate of a pair effective at dateTime, or of the whole pair when dateTime is empty, a composite key
+// without its leading null byte whose last attribute counts down the seconds to the end of year 9999 so later rates sort
+// first, GetStateByRange takes simple keys only -- Internal Function
+// ============================================================================================================================
+func getFxRateKey(stub shim.ChaincodeStubInterface, baseCurrency string, quoteCurrency string, dateTime string) (string, error) {
+	attributes := []string{baseCurrency, quoteCurrency}
+	if dateTime != "" {
+		effective, err := time.Parse(time.RFC3339, dateTime)
+		if err != nil {
+			return "", err
+		}
+		// 9999-12-31T23:59:59Z, so the countdown of any date in years 1 to 9999 has 12 digits
+		attributes = append(attributes, fmt.Sprintf("%012d", int64(253402300799) - effective.Unix()))
+	}
+	key, err := stub.CreateCompositeKey(FxRateObjectType, attributes)
+	if err != nil {
+		return "", err
+	}
+	return key[1:], nil
+}
+// ============================================================================================================================
+// convertMoney - an amount in currency at the FX rate in effect at dateTime -- Internal Function
+// ============================================================================================================================
+func convertMoney(stub shim.ChaincodeStubInterface, amount Money, currency string, dateTime string) (Money, error) {
+	if amount.Currency == currency {
+		return amount, nil
+	}
+	res_FxRate, err := getFxRateAt(stub, amount.Currency, currency, dateTime)
+	if err != nil {
+		return Money{}, err
+	}
+	units, err := convertUnits(amount.Units, res_FxRate.Rate, amount.Currency, currency)
+	if err != nil {
+		return Money{}, newError(ErrInvalidArgument, "Cannot convert " + amount.String() + " to " + currency + ": " + err.Error())
+	}
+	return Money{Units: units, Currency: currency}, nil
+}
+// ============================================================================================================================
+// holdingPointsWorth - worth of points of a Merchant at its exchangeRate in the currency of the holding -- Internal Function
+// ============================================================================================================================
+func holdingPointsWorth(stub shim.ChaincodeStubInterface, res_Merchant Merchant, holding MerchantHolding, points Points, dateTime string) (Money, error) {
+	worth, err := pointsWorth(points, res_Merchant.ExchangeRate, res_Merchant.MerchantCurrency)
+	if err != nil {
+		return Money{}, err
+	}
+	currency := holding.Worth.Currency
+	if currency == "" {
+		currency = res_Merchant.MerchantCurrency
+	}
+	return convertMoney(stub, worth, currency, dateTime)
+}
+// ============================================================================================================================
+// getAsOf - the optional asOf argument at index i of a query as RFC 3339, the proposal timestamp when not given
+// -- Internal Function
+// ============================================================================================================================
+func getAsOf(stub shim.ChaincodeStubInterface, args []string, i int) (string, error) {
+	if len(args) <= i || args[i] == "" {
+		return getTxDateTime(stub)
+	}
+	asOf, err := parseDateTimeBound(args[i], true)
+	if err != nil {
+		return "", newError(ErrInvalidArgument, "Invalid asOf " + args[i] + ", expecting RFC 3339 or 2006-01-02")
+	}
+	return asOf, nil
+}
+// ============================================================================================================================
+// isActive - false once a Customer is closed, records from before statuses are active -- Internal Function
+// ============================================================================================================================
+func (res Customer) isActive() bool {
+	return res.Status == "" || res.Status == StatusActive
+}
+// ============================================================================================================================
+// isActive - false once a Merchant is deactivated, records from before statuses are active -- Internal Function
+// ============================================================================================================================
+func (res Merchant) isActive() bool {
+	return res.Status == "" || res.Status == StatusActive
+}
+// ============================================================================================================================
+// getIncludeInactive - the optional includeInactive argument at index i of a query, false when not given -- Internal Function
+// ============================================================================================================================
+func getIncludeInactive(args []string, i int) (bool, error) {
+	if len(args) <= i || args[i] == "" {
+		return false, nil
+	}
+	includeInactive, err := strconv.ParseBool(args[i])
+	if err != nil {
+		return false, newError(ErrInvalidArgument, "Invalid includeInactive " + args[i] + ", expecting true or false")
+	}
+	return includeInactive, nil
+}
+// ============================================================================================================================
+// getCaller - the invoking identity from the creator certificate, with the role bound to it on the ledger -- Internal Function
+// ============================================================================================================================
+func getCaller(stub shim.ChaincodeStubInterface) (RoleBinding, error) {
+	identity, err := cid.New(stub)
+	if err != nil {
+		return RoleBinding{}, errors.New("Failed to read the creator certificate: " + err.Error())
+	}
+	mspId, err := identity.GetMSPID()
+	if err != nil {
+		return RoleBinding{}, err
+	}
+	// Fabric CA puts the enrollment id in the certificate, otherwise fall back to the subject and issuer
+	id, found, err := identity.GetAttributeValue(EnrollmentIDAttribute)
+	if err != nil {
+		return RoleBinding{}, err
+	}
+	if !found || id == "" {
+		id, err = identity.GetID()
+		if err != nil {
+			return RoleBinding{}, err
+		}
+	}
+	caller := RoleBinding{Identity: mspId + "/" + id}
+	res, found, err := getRoleBinding(stub, caller.Identity)
+	if err != nil {
+		return RoleBinding{}, err
+	}
+	if found {
+		caller = res
+	}
+	return caller, nil
+}
+// ============================================================================================================================
+// requireRole - the caller, when its bound role matches one of allowed, an owner matches any owner -- Internal Function
+// ============================================================================================================================
+func requireRole(stub shim.ChaincodeStubInterface, allowed ...RoleBinding) (RoleBinding, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return caller, err
+	}
+	for _, binding := range allowed {
+		if caller.Role != binding.Role {
+			continue
+		}
+		if binding.Role == RoleOwner || caller.EntityID == binding.EntityID {
+			return caller, nil
+		}
+	}
+	if caller.Role == "" {
+		return caller, newError(ErrPermissionDenied, caller.Identity + " has no role bound")
+	}
+	return caller, newError(ErrPermissionDenied, caller.Identity + " (" + strings.TrimSpace(caller.Role + " " + caller.EntityID) + ") is not permitted to do this")
+}
+// ============================================================================================================================
+// getRoleBinding - read the role bound to an identity -- Internal Function
+// ============================================================================================================================
+func getRoleBinding(stub shim.ChaincodeStubInterface, identity string) (RoleBinding, bool, error) {
+	res := RoleBinding{}
+	key, err := getRecordKey(stub, RoleBindingObjectType, identity)
+	if err != nil {
+		return res, false, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil || !found {
+		return RoleBinding{}, false, err
+	}
+	if res.Identity != identity {
+		return RoleBinding{}, false, errors.New("Record stored for " + identity + " is not a RoleBinding")
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// putRoleBinding - store a role binding under its identity -- Internal Function
+// ============================================================================================================================
+func putRoleBinding(stub shim.ChaincodeStubInterface, res RoleBinding) error {
+	key, err := getRecordKey(stub, RoleBindingObjectType, res.Identity)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// hasRoleBindings - true once any identity has been bound -- Internal Function
+// ============================================================================================================================
+func hasRoleBindings(stub shim.ChaincodeStubInterface) (bool, error) {
+	bindingsIter, err := stub.GetStateByPartialCompositeKey(RoleBindingObjectType, []string{})
+	if err != nil {
+		return false, err
+	}
+	defer bindingsIter.Close()
+	return bindingsIter.HasNext(), nil
+}
+// ============================================================================================================================
+// newWriteBatch - a stub that stages PutState, DelState and SetEvent until commit -- Internal Function
+// ============================================================================================================================
+func newWriteBatch(stub shim.ChaincodeStubInterface) *writeBatch {
+	return &writeBatch{ChaincodeStubInterface: stub, values: map[string][]byte{}, deleted: map[string]bool{}}
+}
+// ============================================================================================================================
+// GetState - the staged value of key when this invocation wrote it, the ledger value otherwise
+// ============================================================================================================================
+func (b *writeBatch) GetState(key string) ([]byte, error) {
+	if b.deleted[key] {
+		return nil, nil
+	}
+	if value, ok := b.values[key]; ok {
+		return value, nil
+	}
+	return b.ChaincodeStubInterface.GetState(key)
+}
+// ============================================================================================================================
+// PutState - stage a write, a later write to the same key replaces it
+// ============================================================================================================================
+func (b *writeBatch) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("Empty key")
+	}
+	b.stage(key)
+	b.values[key] = value
+	delete(b.deleted, key)
+	return nil
+}
+// ============================================================================================================================
+// DelState - stage a delete
+// ============================================================================================================================
+func (b *writeBatch) DelState(key string) error {
+	if key == "" {
+		return errors.New("Empty key")
+	}
+	b.stage(key)
+	delete(b.values, key)
+	b.deleted[key] = true
+	return nil
+}
+// ============================================================================================================================
+// GetStateByRange - the ledger range with the values this invocation staged in it, in key order
+// ============================================================================================================================
+func (b *writeBatch) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	resultsIter, err := b.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	// composite keys are never part of a range of simple keys
+	return b.merge(resultsIter, func(key string) bool {
+		return !strings.HasPrefix(key, "\x00") && key >= startKey && (endKey == "" || key < endKey)
+	})
+}
+// ============================================================================================================================
+// GetStateByPartialCompositeKey - the ledger keys under the partial key with the values this invocation staged under it,
+// in key order
+// ============================================================================================================================
+func (b *writeBatch) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := b.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	resultsIter, err := b.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return b.merge(resultsIter, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+// ============================================================================================================================
+// merge - the results of a ledger range read with the writes staged in the range applied, the ledger iterator itself when
+// nothing staged falls in the range -- Internal Function
+// ============================================================================================================================
+func (b *writeBatch) merge(resultsIter shim.StateQueryIteratorInterface, inRange func(key string) bool) (shim.StateQueryIteratorInterface, error) {
+	staged := false
+	for _, key := range b.keys {
+		if inRange(key) {
+			staged = true
+			break
+		}
+	}
+	if !staged {
+		return resultsIter, nil
+	}
+	defer resultsIter.Close()
+	var results []*queryresult.KV
+	for resultsIter.HasNext() {
+		queryResponse, err := resultsIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := b.values[queryResponse.Key]; ok || b.deleted[queryResponse.Key] {
+			continue
+		}
+		results = append(results, queryResponse)
+	}
+	for key, value := range b.values {
+		if inRange(key) {
+			results = append(results, &queryresult.KV{Key: key, Value: value})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return &stagedIterator{results: results}, nil
+}
+// ============================================================================================================================
+// HasNext - true while merged results are left
+// ============================================================================================================================
+func (it *stagedIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+// ============================================================================================================================
+// Next - the next merged result
+// ============================================================================================================================
+func (it *stagedIterator) Next() (*queryresult.KV, error) {
+	if it.next >= len(it.results) {
+		return nil, errors.New("No more results")
+	}
+	it.next++
+	return it.results[it.next - 1], nil
+}
+// ============================================================================================================================
+// Close - nothing to release, the ledger iterator was closed when the results were merged
+// ============================================================================================================================
+func (it *stagedIterator) Close() error {
+	return nil
+}
+// ============================================================================================================================
+// SetEvent - stage an event, it is only sent if the batch commits
+// ============================================================================================================================
+func (b *writeBatch) SetEvent(name string, payload []byte) error {
+	b.events = append(b.events, stagedEvent{name: name, payload: payload})
+	return nil
+}
+// ============================================================================================================================
+// stage - remember the order keys were first written in -- Internal Function
+// ============================================================================================================================
+func (b *writeBatch) stage(key string) {
+	if _, ok := b.values[key]; ok {
+		return
+	}
+	if b.deleted[key] {
+		return
+	}
+	b.keys = append(b.keys, key)
+}
+// ============================================================================================================================
+// commit - apply every staged write and event to the real stub -- Internal Function
+// ============================================================================================================================
+func (b *writeBatch) commit() error {
+	for _, key := range b.keys {
+		var err error
+		if b.deleted[key] {
+			err = b.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = b.ChaincodeStubInterface.PutState(key, b.values[key])
+		}
+		if err != nil {
+			return errors.New("Failed to commit " + strconv.Quote(key) + ": " + err.Error())
+		}
+	}
+	for _, event := range b.events {
+		err := b.ChaincodeStubInterface.SetEvent(event.name, event.payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+// ============================================================================================================================
+// setEvent - marshal an event payload and send it under eventName -- Internal Function
+// ============================================================================================================================
+func setEvent(stub shim.ChaincodeStubInterface, eventName string, payload interface{}) error {
+	payloadAsBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(eventName, payloadAsBytes)
+}
+// ============================================================================================================================
+// putRecord - marshal a ledger record with encoding/json and store it under key, every record write goes through here -- Internal Function
+// ============================================================================================================================
+func putRecord(stub shim.ChaincodeStubInterface, key string, record interface{}) error {
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("Failed to marshal record for " + key + ": " + err.Error())
+	}
+	fmt.Println("putRecord " + key + ": " + string(recordAsBytes))
+	return stub.PutState(key, recordAsBytes)
+}
+// ============================================================================================================================
+// getRecord - unmarshal the record stored under key, found is false when nothing is stored there -- Internal Function
+// ============================================================================================================================
+func getRecord(stub shim.ChaincodeStubInterface, key string, record interface{}) (bool, error) {
+	recordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, errors.New("Failed to get state for " + key)
+	}
+	if len(recordAsBytes) == 0 {
+		return false, nil
+	}
+	err = json.Unmarshal(recordAsBytes, record)
+	if err != nil {
+		return false, errors.New("Malformed record stored for " + key + ": " + err.Error())
+	}
+	return true, nil
+}
+// ============================================================================================================================
+// putIndexEntry - write an index key, the key carries everything so the value is a single null byte -- Internal Function
+// ============================================================================================================================
+func putIndexEntry(stub shim.ChaincodeStubInterface, indexName string, attributes []string) error {
+	key, err := stub.CreateCompositeKey(indexName, attributes)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
+// ============================================================================================================================
+// delIndexEntry - remove an index key written by putIndexEntry -- Internal Function
+// ============================================================================================================================
+func delIndexEntry(stub shim.ChaincodeStubInterface, indexName string, attributes []string) error {
+	key, err := stub.CreateCompositeKey(indexName, attributes)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+// ============================================================================================================================
+// getMemberKey - key listing customerId under merchantId in MerchantCustomerIndex, a composite key without its leading null
+// byte, an invocation can only read a partial composite key from its start and GetStateByRange takes simple keys, so batches
+// over a Merchant's members read from their bookmark on -- Internal Function
+// ============================================================================================================================
+func getMemberKey(stub shim.ChaincodeStubInterface, merchantId string, customerId string) (string, error) {
+	attributes := []string{merchantId}
+	if customerId != "" {
+		attributes = append(attributes, customerId)
+	}
+	key, err := stub.CreateCompositeKey(MerchantCustomerIndex, attributes)
+	if err != nil {
+		return "", err
+	}
+	return key[1:], nil
+}
+// ============================================================================================================================
+// putMemberEntry - list a customer under a Merchant it holds points with -- Internal Function
+// ============================================================================================================================
+func putMemberEntry(stub shim.ChaincodeStubInterface, merchantId string, customerId string) error {
+	key, err := getMemberKey(stub, merchantId, customerId)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
+// ============================================================================================================================
+// delMemberEntry - remove a customer listed by putMemberEntry -- Internal Function
+// ============================================================================================================================
+func delMemberEntry(stub shim.ChaincodeStubInterface, merchantId string, customerId string) error {
+	key, err := getMemberKey(stub, merchantId, customerId)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+// ============================================================================================================================
+// getMemberPage - customerIds listed under a Merchant after the bookmark customerId in order, at most limit of them unless
+// limit is 0, with the customerId to resume after when more follow -- Internal Function
+// ============================================================================================================================
+func getMemberPage(stub shim.ChaincodeStubInterface, merchantId string, bookmark string, limit int) ([]string, string, error) {
+	var ids []string
+	prefix, err := getMemberKey(stub, merchantId, "")
+	if err != nil {
+		return nil, "", err
+	}
+	startKey := prefix
+	if bookmark != "" {
+		startKey, err = getMemberKey(stub, merchantId, bookmark)
+		if err != nil {
+			return nil, "", newError(ErrInvalidArgument, "Invalid bookmark " + strconv.Quote(bookmark) + ": " + err.Error())
+		}
+		startKey += "\x00"													//the first key after the bookmark's
+	}
+	keysIter, err := stub.GetStateByRange(startKey, prefix[:len(prefix) - 1] + "\x01")
+	if err != nil {
+		return nil, "", err
+	}
+	defer keysIter.Close()
+	for keysIter.HasNext() {
+		if limit > 0 && len(ids) == limit {
+			return ids, ids[len(ids) - 1], nil
+		}
+		queryResponse, err := keysIter.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, strings.TrimSuffix(queryResponse.Key[len(prefix):], "\x00"))
+	}
+	return ids, "", nil
+}
+// ============================================================================================================================
+// getIndexedIds - the last attribute of every key in an index under the given leading attributes, attributes in between
+// only order the keys -- Internal Function
+// ============================================================================================================================
+func getIndexedIds(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]string, error) {
+	var ids []string
+	keysIter, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIter.Close()
+	for keysIter.HasNext() {
+		queryResponse, err := keysIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		key := queryResponse.Key
+		_, keyParts, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) < len(attributes) + 1 {
+			return nil, errors.New("Malformed " + indexName + " index key " + strconv.Quote(key))
+		}
+		ids = append(ids, keyParts[len(keyParts)-1])
+	}
+	return ids, nil
+}
+// ============================================================================================================================
+// getRecordKey - composite key a record of objectType is stored under -- Internal Function
+// ============================================================================================================================
+func getRecordKey(stub shim.ChaincodeStubInterface, objectType string, id string) (string, error) {
+	if id == "" {
+		return "", errors.New("Empty " + objectType + " id")
+	}
+	return stub.CreateCompositeKey(objectType, []string{id})
+}
+// ============================================================================================================================
+// getCustomer - read a Customer, rejecting a record under customerId that is not a Customer -- Internal Function
+// ============================================================================================================================
+func getCustomer(stub shim.ChaincodeStubInterface, customerId string) (Customer, bool, error) {
+	res := Customer{}
+	key, err := getRecordKey(stub, CustomerObjectType, customerId)
+	if err != nil {
+		return res, false, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil || !found {
+		return Customer{}, false, err
+	}
+	if res.CustomerID != customerId {
+		return Customer{}, false, errors.New("Record stored for " + customerId + " is not a Customer")
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// getMerchant - read a Merchant, rejecting a record under merchantId that is not a Merchant -- Internal Function
+// ============================================================================================================================
+func getMerchant(stub shim.ChaincodeStubInterface, merchantId string) (Merchant, bool, error) {
+	res := Merchant{}
+	key, err := getRecordKey(stub, MerchantObjectType, merchantId)
+	if err != nil {
+		return res, false, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil || !found {
+		return Merchant{}, false, err
+	}
+	if res.MerchantID != merchantId {
+		return Merchant{}, false, errors.New("Record stored for " + merchantId + " is not a Merchant")
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// getOwner - read an Owner, rejecting a record under ownerId that is not an Owner -- Internal Function
+// ============================================================================================================================
+func getOwner(stub shim.ChaincodeStubInterface, ownerId string) (Owner, bool, error) {
+	res := Owner{}
+	key, err := getRecordKey(stub, OwnerObjectType, ownerId)
+	if err != nil {
+		return res, false, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil || !found {
+		return Owner{}, false, err
+	}
+	if res.OwnerID != ownerId {
+		return Owner{}, false, errors.New("Record stored for " + ownerId + " is not an Owner")
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// getTransaction - read a Transaction, rejecting a record under transactionId that is not a Transaction -- Internal Function
+// ============================================================================================================================
+func getTransaction(stub shim.ChaincodeStubInterface, transactionId string) (Transaction, bool, error) {
+	res := Transaction{}
+	key, err := getRecordKey(stub, TransactionObjectType, transactionId)
+	if err != nil {
+		return res, false, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil || !found {
+		return Transaction{}, false, err
+	}
+	if res.TransactionID != transactionId {
+		return Transaction{}, false, errors.New("Record stored for " + transactionId + " is not a Transaction")
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// getCustomerRecords - every Customer, keyed by customerId -- Internal Function
+// ============================================================================================================================
+func getCustomerRecords(stub shim.ChaincodeStubInterface) (map[string]Customer, error) {
+	customers := map[string]Customer{}
+	customersIter, err := stub.GetStateByPartialCompositeKey(CustomerObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer customersIter.Close()
+	for customersIter.HasNext() {
+		queryResponse, err := customersIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, valueAsBytes := queryResponse.Key, queryResponse.Value
+		res := Customer{}
+		err = json.Unmarshal(valueAsBytes, &res)
+		if err != nil {
+			return nil, errors.New("Malformed record stored for " + strconv.Quote(key) + ": " + err.Error())
+		}
+		customers[res.CustomerID] = res
+	}
+	return customers, nil
+}
+// ============================================================================================================================
+// getMerchantRecords - every Merchant, keyed by merchantId -- Internal Function
+// ============================================================================================================================
+func getMerchantRecords(stub shim.ChaincodeStubInterface) (map[string]Merchant, error) {
+	merchants := map[string]Merchant{}
+	merchantsIter, err := stub.GetStateByPartialCompositeKey(MerchantObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer merchantsIter.Close()
+	for merchantsIter.HasNext() {
+		queryResponse, err := merchantsIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, valueAsBytes := queryResponse.Key, queryResponse.Value
+		res := Merchant{}
+		err = json.Unmarshal(valueAsBytes, &res)
+		if err != nil {
+			return nil, errors.New("Malformed record stored for " + strconv.Quote(key) + ": " + err.Error())
+		}
+		merchants[res.MerchantID] = res
+	}
+	return merchants, nil
+}
+// ============================================================================================================================
+// getCustomersOfMerchant - every Customer holding points with merchantId, keyed by customerId -- Internal Function
+// ============================================================================================================================
+func getCustomersOfMerchant(stub shim.ChaincodeStubInterface, merchantId string) (map[string]Customer, error) {
+	customers := map[string]Customer{}
+	customerIds, _, err := getMemberPage(stub, merchantId, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, customerId := range customerIds {
+		res, found, err := getCustomer(stub, customerId)
+		if err != nil {
+			return nil, err
+		}
+		if found && getHoldingIndex(res, merchantId) >= 0 {
+			customers[customerId] = res
+		}
+	}
+	return customers, nil
+}
+// ============================================================================================================================
+// getTransactionPage - up to pageSize Transactions listed in a txn index under id that match filter, in time order from
+// bookmark or the fromDateTime of filter, index keys are read a page at a time from there until toDateTime so a long
+// history is never loaded whole -- Internal Function
+// ============================================================================================================================
+func getTransactionPage(stub shim.ChaincodeStubInterface, indexName string, id string, pageSize int, bookmark string, filter TransactionFilter) (TransactionPage, error) {
+	page := TransactionPage{Transactions: []Transaction{}}
+	prefix, err := stub.CreateCompositeKey(indexName, []string{id})
+	if err != nil {
+		return page, newError(ErrInvalidArgument, "Invalid id " + strconv.Quote(id) + ": " + err.Error())
+	}
+	if bookmark != "" && !strings.HasPrefix(bookmark, prefix) {
+		return page, newError(ErrInvalidArgument, "bookmark is not from a history of " + id)
+	}
+	// the bookmark is the key a page starts at, the scan starts at the key of fromDateTime and never before it
+	if filter.From != "" {
+		start, err := stub.CreateCompositeKey(indexName, []string{id, filter.From})
+		if err != nil {
+			return page, newError(ErrInvalidArgument, "Invalid fromDateTime " + strconv.Quote(filter.From) + ": " + err.Error())
+		}
+		if bookmark < start {
+			bookmark = start
+		}
+	}
+	for {
+		if pastTransactionRange(stub, bookmark, filter) {
+			return page, nil											//keys are in time order, nothing after this can match
+		}
+		// only as many keys as the page still needs are read, so the bookmark never skips past a match
+		keysIter, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(indexName, []string{id}, int32(pageSize - len(page.Transactions)), bookmark)
+		if err != nil {
+			return page, err
+		}
+		bookmark = metadata.Bookmark
+		for keysIter.HasNext() {
+			queryResponse, err := keysIter.Next()
+			if err != nil {
+				keysIter.Close()
+				return page, err
+			}
+			_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil || len(keyParts) < 2 {
+				keysIter.Close()
+				return page, errors.New("Malformed " + indexName + " index key " + strconv.Quote(queryResponse.Key))
+			}
+			if len(keyParts) == 3 && filter.To != "" && keyParts[1] > filter.To {
+				keysIter.Close()
+				return page, nil										//keys are in time order, nothing after this can match
+			}
+			res, found, err := getTransaction(stub, keyParts[len(keyParts)-1])
+			if err != nil {
+				keysIter.Close()
+				return page, err
+			}
+			if found && filter.matches(res) {
+				page.Transactions = append(page.Transactions, res)
+			}
+		}
+		keysIter.Close()
+		if bookmark == "" || len(page.Transactions) == pageSize {
+			break
+		}
+	}
+	// a bookmark past toDateTime would only lead to an empty page
+	if !pastTransactionRange(stub, bookmark, filter) {
+		page.Bookmark = bookmark
+	}
+	return page, nil
+}
+// ============================================================================================================================
+// pastTransactionRange - true when a txn index key is after the toDateTime of filter -- Internal Function
+// ============================================================================================================================
+func pastTransactionRange(stub shim.ChaincodeStubInterface, key string, filter TransactionFilter) bool {
+	if key == "" || filter.To == "" {
+		return false
+	}
+	_, keyParts, err := stub.SplitCompositeKey(key)
+	return err == nil && len(keyParts) == 3 && keyParts[1] > filter.To
+}
+// ============================================================================================================================
+// add - append a Transaction to the statement, carrying the running balance -- Internal Function
+// ============================================================================================================================
+func (s *MerchantStatement) add(res Transaction) error {
+	balance := s.OpeningBalance
+	if len(s.Entries) > 0 {
+		balance = s.Entries[len(s.Entries)-1].Balance
+	}
+	balance, err := addCreditDebit(balance, res)
+	if err != nil {
+		return err
+	}
+	s.Entries = append(s.Entries, StatementEntry{
+		TransactionID: res.TransactionID,
+		TransactionDateTime: res.TransactionDateTime,
+		TransactionType: res.TransactionType,
+		Counterparty: res.CustomerID,
+		Credit: res.Credit,
+		Debit: res.Debit,
+		Balance: balance,
+	})
+	return nil
+}
+// ============================================================================================================================
+// addCreditDebit - balance moved by the credit and debit of a Transaction -- Internal Function
+// ============================================================================================================================
+func addCreditDebit(balance Points, res Transaction) (Points, error) {
+	balance, err := balance.Add(res.Credit)
+	if err != nil {
+		return balance, errors.New("Balance overflow at Transaction " + res.TransactionID)
+	}
+	balance, err = balance.Add(-res.Debit)
+	if err != nil {
+		return balance, errors.New("Balance overflow at Transaction " + res.TransactionID)
+	}
+	return balance, nil
+}
+// ============================================================================================================================
+// matches - true when res passes every filter that is set -- Internal Function
+// ============================================================================================================================
+func (f TransactionFilter) matches(res Transaction) bool {
+	if f.From != "" && res.TransactionDateTime < f.From {
+		return false
+	}
+	if f.To != "" && res.TransactionDateTime > f.To {
+		return false
+	}
+	if f.TransactionType != "" && res.TransactionType != f.TransactionType {
+		return false
+	}
+	if f.MerchantID != "" && res.MerchantID != f.MerchantID {
+		return false
+	}
+	if f.HoldingsOnly && !movesHolding(res) {
+		return false
+	}
+	return true
+}
+// ============================================================================================================================
+// movesHolding - false for the leg of a purchase that records the Merchant taking the points as payment, every other leg
+// credits or debits a customer's holding -- Internal Function
+// ============================================================================================================================
+func movesHolding(res Transaction) bool {
+	return res.TransactionType != PurchaseTransactionType || res.LinkedTransactionID == ""
+}
+// ============================================================================================================================
+// getStatementSums - the balance a page of a Merchant's statement opens with and the totals by transactionType of the whole
+// range of filter, the balance is the statement balance of the Merchant's counters less every leg from the page on, so only
+// the legs from fromDateTime on are read, a page at a time -- Internal Function
+// ============================================================================================================================
+func getStatementSums(stub shim.ChaincodeStubInterface, merchantId string, filter TransactionFilter, pageStart string) (Points, map[string]StatementTotal, error) {
+	totals := map[string]StatementTotal{}
+	res_Counters, err := getMerchantCounters(stub, merchantId)
+	if err != nil {
+		return 0, nil, err
+	}
+	balance := res_Counters.StatementBalance
+	bookmark := ""
+	if filter.From != "" {
+		bookmark, err = stub.CreateCompositeKey(MerchantTxnIndex, []string{merchantId, filter.From})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	legs := TransactionFilter{MerchantID: merchantId, HoldingsOnly: true}
+	for {
+		keysIter, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(MerchantTxnIndex, []string{merchantId}, int32(MaxHistoryPageSize), bookmark)
+		if err != nil {
+			return 0, nil, err
+		}
+		for keysIter.HasNext() {
+			queryResponse, err := keysIter.Next()
+			if err != nil {
+				keysIter.Close()
+				return 0, nil, err
+			}
+			_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil || len(keyParts) < 2 {
+				keysIter.Close()
+				return 0, nil, errors.New("Malformed " + MerchantTxnIndex + " index key " + strconv.Quote(queryResponse.Key))
+			}
+			res_trans, found, err := getTransaction(stub, keyParts[len(keyParts)-1])
+			if err != nil {
+				keysIter.Close()
+				return 0, nil, err
+			}
+			if !found || !legs.matches(res_trans) {
+				continue
+			}
+			if pageStart == "" || queryResponse.Key >= pageStart {		//after the page opens
+				balance, err = addCreditDebit(balance, Transaction{TransactionID: res_trans.TransactionID, Credit: res_trans.Debit, Debit: res_trans.Credit})
+				if err != nil {
+					keysIter.Close()
+					return 0, nil, err
+				}
+			}
+			if filter.To != "" && res_trans.TransactionDateTime > filter.To {
+				continue
+			}
+			total := totals[res_trans.TransactionType]
+			total.Count++
+			total.Credit, err = total.Credit.Add(res_trans.Credit)
+			if err == nil {
+				total.Debit, err = total.Debit.Add(res_trans.Debit)
+			}
+			if err != nil {
+				keysIter.Close()
+				return 0, nil, errors.New("Totals overflow at Transaction " + res_trans.TransactionID)
+			}
+			totals[res_trans.TransactionType] = total
+		}
+		keysIter.Close()
+		bookmark = metadata.Bookmark
+		if bookmark == "" {
+			return balance, totals, nil
+		}
+	}
+}
+// ============================================================================================================================
+// parseDateTimeBound - a date range bound as RFC 3339 in UTC, a bare YYYY-MM-DD means the start of the day, or its last
+// second when endOfDay -- Internal Function
+// ============================================================================================================================
+func parseDateTimeBound(value string, endOfDay bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	bound, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		day, dayErr := time.Parse("2006-01-02", value)
+		if dayErr != nil {
+			return "", err
+		}
+		bound = day
+		if endOfDay {
+			bound = day.Add(24 * time.Hour - time.Second)
+		}
+	}
+	return bound.UTC().Format(time.RFC3339), nil
+}
+// ============================================================================================================================
+// countedHoldings - the holdings a Customer counts towards its Merchants' counters with, none once it is closed
+// -- Internal Function
+// ============================================================================================================================
+func countedHoldings(res Customer) []MerchantHolding {
+	if !res.isActive() {
+		return nil
+	}
+	return res.Holdings
+}
+// ============================================================================================================================
+// putCustomer - store a Customer under customer~<customerId>, bringing the lots of its holdings in line with their points
+// and moving the counters of every Merchant whose holding changed -- Internal Function
+// ============================================================================================================================
+func putCustomer(stub shim.ChaincodeStubInterface, res Customer) error {
+	key, err := getRecordKey(stub, CustomerObjectType, res.CustomerID)
+	if err != nil {
+		return err
+	}
+	previous := Customer{}
+	_, err = getRecord(stub, key, &previous)
+	if err != nil {
+		return err
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return err
+	}
+	for i := range res.Holdings {
+		var previousHolding *MerchantHolding
+		holdingIndex := getHoldingIndex(previous, res.Holdings[i].MerchantID)
+		if holdingIndex >= 0 {
+			previousHolding = &previous.Holdings[holdingIndex]
+		}
+		reconcileLots(&res.Holdings[i], previousHolding, transactionDateTime)
+	}
+	err = adjustHoldingCounters(stub, countedHoldings(previous), countedHoldings(res))
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// adjustHoldingCounters - move the counters of each Merchant a customer's holdings changed with from before to after,
+// membership, the customer count of its first merchant and the points liability -- Internal Function
+// ============================================================================================================================
+func adjustHoldingCounters(stub shim.ChaincodeStubInterface, before []MerchantHolding, after []MerchantHolding) error {
+	var merchantIds []string											//in the order first seen, so writes are the same on every peer
+	members := map[string]int64{}
+	customers := map[string]int64{}
+	liabilities := map[string]Points{}
+	see := func(merchantId string) {
+		if _, ok := members[merchantId]; !ok {
+			merchantIds = append(merchantIds, merchantId)
+			members[merchantId] = 0
+		}
+	}
+	for i, holding := range before {
+		see(holding.MerchantID)
+		members[holding.MerchantID]--
+		liabilities[holding.MerchantID] -= holding.Points
+		if i == 0 {
+			customers[holding.MerchantID]--
+		}
+	}
+	for i, holding := range after {
+		see(holding.MerchantID)
+		members[holding.MerchantID]++
+		liabilities[holding.MerchantID] += holding.Points
+		if i == 0 {
+			customers[holding.MerchantID]++
+		}
+	}
+	for _, merchantId := range merchantIds {
+		if members[merchantId] == 0 && customers[merchantId] == 0 && liabilities[merchantId] == 0 {
+			continue
+		}
+		delta := MerchantCounters{MerchantID: merchantId, ActiveMembers: members[merchantId], Customers: customers[merchantId], PointsLiability: liabilities[merchantId]}
+		err := addMerchantCountersDelta(stub, delta)
+		if err != nil {
+			return errors.New("Failed to update the counters of " + merchantId + ": " + err.Error())
+		}
+	}
+	return nil
+}
+// ============================================================================================================================
+// countTransaction - points a Transaction issued and redeemed for its Merchant, a purchase or cash out redeems its debit,
+// a transfer only moves points between customers, an expiry forfeits them, an offboarding or tier change moves none, an exchange
+// leg redeems its debit and issues its credit, an exchange fee redeems its debit and anything else issues its credit
+// -- Internal Function
+// ============================================================================================================================
+func countTransaction(res Transaction) (Points, Points) {
+	if res.TransactionType == ExchangeTransactionType {
+		return res.Credit, res.Debit
+	}
+	if res.TransactionType == PurchaseTransactionType || res.TransactionType == CashOutTransactionType || res.TransactionType == ExchangeFeeTransactionType {
+		return 0, res.Debit
+	}
+	if res.TransactionType == TransferTransactionType || res.TransactionType == ExpiryTransactionType || res.TransactionType == OffboardingTransactionType || res.TransactionType == TierChangeTransactionType {
+		return 0, 0
+	}
+	return res.Credit, 0
+}
+// ============================================================================================================================
+// addTransactionCounters - add the points a Transaction issued and redeemed and what it moves the statement by to its Merchant's
+// counters -- Internal Function
+// ============================================================================================================================
+func addTransactionCounters(stub shim.ChaincodeStubInterface, res Transaction) error {
+	if res.CampaignID != "" {
+		delta := CampaignCounters{CampaignID: res.CampaignID}
+		err := delta.addBonus(res)
+		if err != nil {
+			return err
+		}
+		err = addCampaignCountersDelta(stub, delta)
+		if err != nil {
+			return errors.New("Failed to count Transaction " + res.TransactionID + ": " + err.Error())
+		}
+	}
+	if res.MerchantID == "" {
+		return nil
+	}
+	issued, redeemed := countTransaction(res)
+	delta := MerchantCounters{MerchantID: res.MerchantID}
+	if movesHolding(res) {
+		var err error
+		delta.StatementBalance, err = addCreditDebit(0, res)
+		if err != nil {
+			return err
+		}
+	}
+	if issued == 0 && redeemed == 0 && delta.StatementBalance == 0 {
+		return nil
+	}
+	err := delta.addPoints(issued, redeemed)
+	if err == nil {
+		err = addMerchantCountersDelta(stub, delta)
+	}
+	if err != nil {
+		return errors.New("Failed to count Transaction " + res.TransactionID + ": " + err.Error())
+	}
+	return nil
+}
+// ============================================================================================================================
+// addPoints - add to the points issued and redeemed -- Internal Function
+// ============================================================================================================================
+func (c *MerchantCounters) addPoints(issued Points, redeemed Points) error {
+	var err error
+	c.PointsIssued, err = c.PointsIssued.Add(issued)
+	if err != nil {
+		return err
+	}
+	c.PointsRedeemed, err = c.PointsRedeemed.Add(redeemed)
+	return err
+}
+// ============================================================================================================================
+// add - add a delta to the counters, the delta's inactive flag is not one -- Internal Function
+// ============================================================================================================================
+func (c *MerchantCounters) add(delta MerchantCounters) error {
+	var err error
+	c.Customers += delta.Customers
+	c.ActiveMembers += delta.ActiveMembers
+	c.PointsLiability, err = c.PointsLiability.Add(delta.PointsLiability)
+	if err != nil {
+		return err
+	}
+	c.StatementBalance, err = c.StatementBalance.Add(delta.StatementBalance)
+	if err != nil {
+		return err
+	}
+	return c.addPoints(delta.PointsIssued, delta.PointsRedeemed)
+}
+// ============================================================================================================================
+// add - add a Merchant's counters to the network totals -- Internal Function
+// ============================================================================================================================
+func (n *NetworkCounters) add(res MerchantCounters) error {
+	var err error
+	n.UserCount += res.Customers
+	n.MemberCount += res.ActiveMembers
+	n.PointsIssued, err = n.PointsIssued.Add(res.PointsIssued)
+	if err == nil {
+		n.PointsRedeemed, err = n.PointsRedeemed.Add(res.PointsRedeemed)
+	}
+	if err == nil {
+		n.PointsLiability, err = n.PointsLiability.Add(res.PointsLiability)
+	}
+	return err
+}
+// ============================================================================================================================
+// addTotals - add the totals of a shard of the network counters -- Internal Function
+// ============================================================================================================================
+func (n *NetworkCounters) addTotals(res NetworkCounters) error {
+	n.MerchantCount += res.MerchantCount
+	return n.add(MerchantCounters{Customers: res.UserCount, ActiveMembers: res.MemberCount, PointsIssued: res.PointsIssued, PointsRedeemed: res.PointsRedeemed, PointsLiability: res.PointsLiability})
+}
+// ============================================================================================================================
+// getTxShard - shard out of shards this transaction writes to, a hash of the transaction id so every write of the
+// transaction lands in the same one -- Internal Function
+// ============================================================================================================================
+func getTxShard(stub shim.ChaincodeStubInterface, shards int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(stub.GetTxID()))
+	return int(hash.Sum32() % uint32(shards))
+}
+// ============================================================================================================================
+// addNetworkCountersDelta - add to the network totals in the shard of this transaction, networkCounters~<shard> -- Internal Function
+// ============================================================================================================================
+func addNetworkCountersDelta(stub shim.ChaincodeStubInterface, delta NetworkCounters) error {
+	key, err := stub.CreateCompositeKey(NetworkCountersObjectType, []string{strconv.Itoa(getTxShard(stub, NetworkCounterShards))})
+	if err != nil {
+		return err
+	}
+	res := NetworkCounters{}
+	_, err = getRecord(stub, key, &res)
+	if err != nil {
+		return err
+	}
+	err = res.addTotals(delta)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// getCounterDeltaKey - key of what this transaction adds to the counters of a Merchant or Campaign, <objectType>~<day>~<id>~<txId>
+// with the UTC day of the transaction -- Internal Function
+// ============================================================================================================================
+func getCounterDeltaKey(stub shim.ChaincodeStubInterface, objectType string, id string) (string, error) {
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return stub.CreateCompositeKey(objectType, []string{transactionDateTime[:len("2006-01-02")], id, stub.GetTxID()})
+}
+// ============================================================================================================================
+// getOldestDeltaDay - day of the oldest counter delta of objectType not folded yet, "" when none are left, only its key is read
+// so the range read ends there -- Internal Function
+// ============================================================================================================================
+func getOldestDeltaDay(stub shim.ChaincodeStubInterface, objectType string) (string, error) {
+	deltasIter, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return "", err
+	}
+	defer deltasIter.Close()
+	if !deltasIter.HasNext() {
+		return "", nil
+	}
+	queryResponse, err := deltasIter.Next()
+	if err != nil {
+		return "", err
+	}
+	_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+	if err != nil || len(keyParts) != 3 {
+		return "", errors.New("Malformed key " + strconv.Quote(queryResponse.Key) + " of a counter delta")
+	}
+	return keyParts[0], nil
+}
+// ============================================================================================================================
+// foldCountersDay - fold at most limit deltas of objectType counted on day into their counters and delete them, more is true
+// when deltas of the day are left -- Internal Function
+// ============================================================================================================================
+func foldCountersDay(stub shim.ChaincodeStubInterface, objectType string, day string, limit int) (int, bool, error) {
+	folded := 0
+	deltasIter, err := stub.GetStateByPartialCompositeKey(objectType, []string{day})
+	if err != nil {
+		return folded, false, err
+	}
+	defer deltasIter.Close()
+	for deltasIter.HasNext() {
+		if folded == limit {
+			return folded, true, nil
+		}
+		queryResponse, err := deltasIter.Next()
+		if err != nil {
+			return folded, false, err
+		}
+		if objectType == MerchantCountersDeltaObjectType {
+			delta := MerchantCounters{}
+			err = json.Unmarshal(queryResponse.Value, &delta)
+			if err != nil {
+				return folded, false, errors.New("Malformed record stored for " + strconv.Quote(queryResponse.Key) + ": " + err.Error())
+			}
+			res_Counters, err := getMerchantCountersRecord(stub, delta.MerchantID)
+			if err == nil {
+				err = res_Counters.add(delta)
+			}
+			if err == nil {
+				err = putMerchantCounters(stub, res_Counters)
+			}
+			if err != nil {
+				return folded, false, errors.New("Failed to fold the counters of " + delta.MerchantID + ": " + err.Error())
+			}
+		} else {
+			delta := CampaignCounters{}
+			err = json.Unmarshal(queryResponse.Value, &delta)
+			if err != nil {
+				return folded, false, errors.New("Malformed record stored for " + strconv.Quote(queryResponse.Key) + ": " + err.Error())
+			}
+			res_Counters, err := getCampaignCountersRecord(stub, delta.CampaignID)
+			if err == nil {
+				err = res_Counters.add(delta)
+			}
+			if err == nil {
+				err = putCampaignCounters(stub, res_Counters)
+			}
+			if err != nil {
+				return folded, false, errors.New("Failed to fold the counters of " + delta.CampaignID + ": " + err.Error())
+			}
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return folded, false, err
+		}
+		folded++
+	}
+	return folded, false, nil
+}
+// ============================================================================================================================
+// forEachCounterDelta - call add with every delta of objectType not folded yet for the Merchant or Campaign id, a day at a time
+// from the oldest delta to the day after this transaction's, for queries -- Internal Function
+// ============================================================================================================================
+func forEachCounterDelta(stub shim.ChaincodeStubInterface, objectType string, id string, add func(value []byte) error) error {
+	day, err := getOldestDeltaDay(stub, objectType)
+	if err != nil || day == "" {
+		return err
+	}
+	dayStart, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return errors.New("Malformed day " + strconv.Quote(day) + " in the key of a counter delta")
+	}
+	transactionDateTime, err := getTxDateTime(stub)
+	if err != nil {
+		return err
+	}
+	now, err := time.Parse(time.RFC3339, transactionDateTime)
+	if err != nil {
+		return err
+	}
+	for ; !dayStart.After(now.AddDate(0, 0, 1)); dayStart = dayStart.AddDate(0, 0, 1) {
+		deltasIter, err := stub.GetStateByPartialCompositeKey(objectType, []string{dayStart.Format("2006-01-02"), id})
+		if err != nil {
+			return err
+		}
+		for deltasIter.HasNext() {
+			queryResponse, err := deltasIter.Next()
+			if err != nil {
+				deltasIter.Close()
+				return err
+			}
+			err = add(queryResponse.Value)
+			if err != nil {
+				deltasIter.Close()
+				return errors.New("Malformed record stored for " + strconv.Quote(queryResponse.Key) + ": " + err.Error())
+			}
+		}
+		deltasIter.Close()
+	}
+	return nil
+}
+// ============================================================================================================================
+// getMerchantCounters - read a Merchant's counters with every delta not folded yet, all zero when none are stored yet,
+// for queries, an invocation reading every delta would conflict with each transaction that counts -- Internal Function
+// ============================================================================================================================
+func getMerchantCounters(stub shim.ChaincodeStubInterface, merchantId string) (MerchantCounters, error) {
+	res, err := getMerchantCountersRecord(stub, merchantId)
+	if err != nil {
+		return MerchantCounters{}, err
+	}
+	err = forEachCounterDelta(stub, MerchantCountersDeltaObjectType, merchantId, func(value []byte) error {
+		delta := MerchantCounters{}
+		err := json.Unmarshal(value, &delta)
+		if err != nil {
+			return err
+		}
+		return res.add(delta)
+	})
+	if err != nil {
+		return MerchantCounters{}, errors.New("Failed to total the counters of " + merchantId + ": " + err.Error())
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// getMerchantCountersRecord - read the counters stored under merchantCounters~<merchantId> without the deltas not folded yet,
+// all zero when none are stored yet -- Internal Function
+// ============================================================================================================================
+func getMerchantCountersRecord(stub shim.ChaincodeStubInterface, merchantId string) (MerchantCounters, error) {
+	res := MerchantCounters{}
+	key, err := getRecordKey(stub, MerchantCountersObjectType, merchantId)
+	if err != nil {
+		return res, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil {
+		return MerchantCounters{}, err
+	}
+	if !found {
+		return MerchantCounters{MerchantID: merchantId}, nil
+	}
+	if res.MerchantID != merchantId {
+		return MerchantCounters{}, errors.New("Record stored for " + merchantId + " is not a MerchantCounters")
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// putMerchantCounters - store a Merchant's counters under merchantCounters~<merchantId> -- Internal Function
+// ============================================================================================================================
+func putMerchantCounters(stub shim.ChaincodeStubInterface, res MerchantCounters) error {
+	key, err := getRecordKey(stub, MerchantCountersObjectType, res.MerchantID)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// setMerchantCountersInactive - mark a Merchant's counters as those of an inactive merchant or an active one, the network
+// counts the active ones -- Internal Function
+// ============================================================================================================================
+func setMerchantCountersInactive(stub shim.ChaincodeStubInterface, merchantId string, inactive bool) error {
+	key, err := getRecordKey(stub, MerchantCountersObjectType, merchantId)
+	if err != nil {
+		return err
+	}
+	found, err := getRecord(stub, key, &MerchantCounters{})
+	if err != nil {
+		return err
+	}
+	res, err := getMerchantCountersRecord(stub, merchantId)
+	if err != nil {
+		return err
+	}
+	if found && !res.Inactive && inactive {
+		err = addNetworkCountersDelta(stub, NetworkCounters{MerchantCount: -1})
+	} else if (!found || res.Inactive) && !inactive {
+		err = addNetworkCountersDelta(stub, NetworkCounters{MerchantCount: 1})
+	}
+	if err != nil {
+		return errors.New("Failed to count merchant " + merchantId + ": " + err.Error())
+	}
+	res.Inactive = inactive
+	return putMerchantCounters(stub, res)
+}
+// ============================================================================================================================
+// addMerchantCountersDelta - add to what this transaction adds to a Merchant's counters, stored under
+// merchantCountersDelta~<day>~<merchantId>~<txId> so concurrent transactions never write the same key and only write
+// into the day foldCounters leaves alone, the network totals in the shard of the transaction with it -- Internal Function
+// ============================================================================================================================
+func addMerchantCountersDelta(stub shim.ChaincodeStubInterface, delta MerchantCounters) error {
+	key, err := getCounterDeltaKey(stub, MerchantCountersDeltaObjectType, delta.MerchantID)
+	if err != nil {
+		return err
+	}
+	res := MerchantCounters{MerchantID: delta.MerchantID}
+	_, err = getRecord(stub, key, &res)								//counted earlier in this transaction
+	if err != nil {
+		return err
+	}
+	err = res.add(delta)
+	if err != nil {
+		return err
+	}
+	err = putRecord(stub, key, res)
+	if err != nil {
+		return err
+	}
+	network := NetworkCounters{}
+	err = network.add(delta)
+	if err != nil {
+		return err
+	}
+	return addNetworkCountersDelta(stub, network)
+}
+// ============================================================================================================================
+// addBonus - count a bonus Transaction of the Campaign -- Internal Function
+// ============================================================================================================================
+func (c *CampaignCounters) addBonus(res Transaction) error {
+	bonusPoints, err := c.BonusPoints.Add(res.Credit)
+	if err != nil {
+		return errors.New("Failed to count Transaction " + res.TransactionID + ": " + err.Error())
+	}
+	c.Bonuses++
+	c.BonusPoints = bonusPoints
+	return nil
+}
+// ============================================================================================================================
+// add - add a delta to the counters of the Campaign -- Internal Function
+// ============================================================================================================================
+func (c *CampaignCounters) add(delta CampaignCounters) error {
+	bonusPoints, err := c.BonusPoints.Add(delta.BonusPoints)
+	if err != nil {
+		return err
+	}
+	c.Bonuses += delta.Bonuses
+	c.BonusPoints = bonusPoints
+	return nil
+}
+// ============================================================================================================================
+// getCampaignCounters - read the counters of a Campaign with every delta not folded yet, all zero before its first bonus,
+// for queries like getMerchantCounters -- Internal Function
+// ============================================================================================================================
+func getCampaignCounters(stub shim.ChaincodeStubInterface, campaignId string) (CampaignCounters, error) {
+	res, err := getCampaignCountersRecord(stub, campaignId)
+	if err != nil {
+		return CampaignCounters{}, err
+	}
+	err = forEachCounterDelta(stub, CampaignCountersDeltaObjectType, campaignId, func(value []byte) error {
+		delta := CampaignCounters{}
+		err := json.Unmarshal(value, &delta)
+		if err != nil {
+			return err
+		}
+		return res.add(delta)
+	})
+	if err != nil {
+		return CampaignCounters{}, errors.New("Failed to total the counters of " + campaignId + ": " + err.Error())
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// getCampaignCountersRecord - read the counters stored under campaignCounters~<campaignId> without the deltas not folded yet
+// -- Internal Function
+// ============================================================================================================================
+func getCampaignCountersRecord(stub shim.ChaincodeStubInterface, campaignId string) (CampaignCounters, error) {
+	res := CampaignCounters{}
+	key, err := getRecordKey(stub, CampaignCountersObjectType, campaignId)
+	if err != nil {
+		return res, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil {
+		return CampaignCounters{}, err
+	}
+	if !found {
+		return CampaignCounters{CampaignID: campaignId}, nil
+	}
+	if res.CampaignID != campaignId {
+		return CampaignCounters{}, errors.New("Record stored for " + campaignId + " is not a CampaignCounters")
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// putCampaignCounters - store the counters of a Campaign under campaignCounters~<campaignId> -- Internal Function
+// ============================================================================================================================
+func putCampaignCounters(stub shim.ChaincodeStubInterface, res CampaignCounters) error {
+	key, err := getRecordKey(stub, CampaignCountersObjectType, res.CampaignID)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// addCampaignCountersDelta - add to what this transaction adds to the counters of a Campaign, stored under
+// campaignCountersDelta~<day>~<campaignId>~<txId> like addMerchantCountersDelta -- Internal Function
+// ============================================================================================================================
+func addCampaignCountersDelta(stub shim.ChaincodeStubInterface, delta CampaignCounters) error {
+	key, err := getCounterDeltaKey(stub, CampaignCountersDeltaObjectType, delta.CampaignID)
+	if err != nil {
+		return err
+	}
+	res := CampaignCounters{CampaignID: delta.CampaignID}
+	_, err = getRecord(stub, key, &res)								//counted earlier in this transaction
+	if err != nil {
+		return err
+	}
+	err = res.add(delta)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// getExchangeConfig - read the ExchangeConfig of the network, no fee before the owner sets one -- Internal Function
+// ============================================================================================================================
+func getExchangeConfig(stub shim.ChaincodeStubInterface) (ExchangeConfig, error) {
+	res := ExchangeConfig{}
+	_, err := getRecord(stub, ExchangeConfigKey, &res)
+	if err != nil {
+		return ExchangeConfig{}, err
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// getExchangeVolume - read what a Merchant exchanged on day in one shard, nothing when it exchanged nothing there yet
+// -- Internal Function
+// ============================================================================================================================
+func getExchangeVolume(stub shim.ChaincodeStubInterface, merchantId string, day string, shard int, currency string) (ExchangeVolume, error) {
+	res := ExchangeVolume{}
+	key, err := stub.CreateCompositeKey(ExchangeVolumeObjectType, []string{merchantId, day, strconv.Itoa(shard)})
+	if err != nil {
+		return res, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil {
+		return ExchangeVolume{}, err
+	}
+	if !found {
+		return ExchangeVolume{MerchantID: merchantId, Day: day, Shard: shard, Out: Money{Units: 0, Currency: currency}, In: Money{Units: 0, Currency: currency}}, nil
+	}
+	return res, nil
+}
+// ============================================================================================================================
+// putExchangeVolume - store what a Merchant exchanged on a day in one shard under exchangeVolume~<merchantId>~<day>~<shard>
+// -- Internal Function
+// ============================================================================================================================
+func putExchangeVolume(stub shim.ChaincodeStubInterface, res ExchangeVolume) error {
+	key, err := stub.CreateCompositeKey(ExchangeVolumeObjectType, []string{res.MerchantID, res.Day, strconv.Itoa(res.Shard)})
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// addExchangeVolume - add value to what a Merchant exchanged out or in on day, false when that would pass dailyCap, 0 is no
+// cap. Every shard holds up to its share of the cap, the value goes to the shard of this transaction when it has room, else
+// every shard is read and the value spread over those with room, so concurrent exchanges only contend near the cap
+// -- Internal Function
+// ============================================================================================================================
+func addExchangeVolume(stub shim.ChaincodeStubInterface, merchantId string, day string, value Money, out bool, dailyCap int64) (bool, error) {
+	share := func(shard int) int64 {
+		if shard == 0 {
+			return dailyCap / int64(ExchangeVolumeShards) + dailyCap % int64(ExchangeVolumeShards)
+		}
+		return dailyCap / int64(ExchangeVolumeShards)
+	}
+	exchanged := func(res *ExchangeVolume) *Money {
+		if out {
+			return &res.Out
+		}
+		return &res.In
+	}
+	shard := getTxShard(stub, ExchangeVolumeShards)
+	res, err := getExchangeVolume(stub, merchantId, day, shard, value.Currency)
+	if err != nil {
+		return false, err
+	}
+	if dailyCap == 0 || exchanged(&res).Units + value.Units <= share(shard) {
+		*exchanged(&res), err = exchanged(&res).Add(value)
+		if err != nil {
+			return false, err
+		}
+		return true, putExchangeVolume(stub, res)
+	}
+	volumes := make([]ExchangeVolume, ExchangeVolumeShards)
+	room := int64(0)
+	for i := range volumes {
+		volumes[i], err = getExchangeVolume(stub, merchantId, day, i, value.Currency)
+		if err != nil {
+			return false, err
+		}
+		if left := share(i) - exchanged(&volumes[i]).Units; left > 0 {
+			room += left
+		}
+	}
+	if room < value.Units {
+		return false, nil
+	}
+	// this transaction's shard first, then the others in order, so every peer writes the same
+	order := []int{shard}
+	for i := range volumes {
+		if i != shard {
+			order = append(order, i)
+		}
+	}
+	remaining := value.Units
+	for _, i := range order {
+		take := share(i) - exchanged(&volumes[i]).Units
+		if take > remaining {
+			take = remaining
+		}
+		if take <= 0 {
+			continue
+		}
+		exchanged(&volumes[i]).Units += take
+		err = putExchangeVolume(stub, volumes[i])
+		if err != nil {
+			return false, err
+		}
+		remaining -= take
+		if remaining == 0 {
+			break
+		}
+	}
+	return true, nil
+}
+// ============================================================================================================================
+// checkExchangeLegs - fail unless the legs of an exchange net to zero, the points debited are the points given up and in the
+// first Merchant's currency the value given up is what the credit is worth at rate and the fee -- Internal Function
+// ============================================================================================================================
+func checkExchangeLegs(points Points, value Money, rate Rate, debit Transaction, credit Transaction, fee Transaction) error {
+	creditValue, err := convertUnitsBack(credit.Amount.Units, rate, value.Currency, credit.Amount.Currency)
+	if err != nil {
+		return err
+	}
+	if debit.Debit + fee.Debit != points || fee.Debit < 0 || fee.Amount.Units < 0 ||
+		debit.Amount.Units + fee.Amount.Units != value.Units || creditValue != debit.Amount.Units ||
+		debit.Amount.Currency != value.Currency || fee.Amount.Currency != value.Currency {
+		return newError(ErrInternal, "The legs of exchange " + debit.TransactionID + " do not net to zero")
+	}
+	return nil
+}
+// ============================================================================================================================
+// addNetworkFee - book an exchange fee to the network account in its currency, in the shard of this transaction
+// -- Internal Function
+// ============================================================================================================================
+func addNetworkFee(stub shim.ChaincodeStubInterface, fee Money) error {
+	key, err := stub.CreateCompositeKey(NetworkAccountObjectType, []string{fee.Currency, strconv.Itoa(getTxShard(stub, NetworkCounterShards))})
+	if err != nil {
+		return err
+	}
+	res := NetworkAccount{Currency: fee.Currency, Fees: Money{Units: 0, Currency: fee.Currency}}
+	_, err = getRecord(stub, key, &res)
+	if err != nil {
+		return err
+	}
+	res.Fees, err = res.Fees.Add(fee)
+	if err != nil {
+		return errors.New("Failed to book the network fee: " + err.Error())
+	}
+	res.Exchanges++
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// getCampaign - read a Campaign by campaignId -- Internal Function
+// ============================================================================================================================
+func getCampaign(stub shim.ChaincodeStubInterface, campaignId string) (Campaign, bool, error) {
+	res := Campaign{}
+	key, err := getRecordKey(stub, CampaignObjectType, campaignId)
+	if err != nil {
+		return res, false, err
+	}
+	found, err := getRecord(stub, key, &res)
+	if err != nil || !found {
+		return Campaign{}, false, err
+	}
+	if res.CampaignID != campaignId {
+		return Campaign{}, false, errors.New("Record stored for " + campaignId + " is not a Campaign")
+	}
+	return res, true, nil
+}
+// ============================================================================================================================
+// putCampaign - store a Campaign under campaign~<campaignId> -- Internal Function
+// ============================================================================================================================
+func putCampaign(stub shim.ChaincodeStubInterface, res Campaign) error {
+	key, err := getRecordKey(stub, CampaignObjectType, res.CampaignID)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// putMerchant - store a Merchant under merchant~<merchantId> -- Internal Function
+// ============================================================================================================================
+func putMerchant(stub shim.ChaincodeStubInterface, res Merchant) error {
+	key, err := getRecordKey(stub, MerchantObjectType, res.MerchantID)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// putOwner - store an Owner under owner~<ownerId> -- Internal Function
+// ============================================================================================================================
+func putOwner(stub shim.ChaincodeStubInterface, res Owner) error {
+	key, err := getRecordKey(stub, OwnerObjectType, res.OwnerID)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, key, res)
+}
+// ============================================================================================================================
+// putTransaction - store a Transaction under txn~<transactionId>, index it by customer and merchant and count its points
+// -- Internal Function
+// ============================================================================================================================
+func putTransaction(stub shim.ChaincodeStubInterface, res Transaction) error {
+	key, err := getRecordKey(stub, TransactionObjectType, res.TransactionID)
+	if err != nil {
+		return err
+	}
+	err = putRecord(stub, key, res)
+	if err != nil {
+		return err
+	}
+	if res.CustomerID != "" {
+		err = putIndexEntry(stub, CustomerTxnIndex, []string{res.CustomerID, res.TransactionDateTime, res.TransactionID})
+		if err != nil {
+			return err
+		}
+	}
+	if res.MerchantID != "" {
+		err = putIndexEntry(stub, MerchantTxnIndex, []string{res.MerchantID, res.TransactionDateTime, res.TransactionID})
+		if err != nil {
+			return err
+		}
+	}
+	return addTransactionCounters(stub, res)
+}
+// ============================================================================================================================
+// indexCustomerHoldings - list the customer under every merchant it holds points with -- Internal Function
+// ============================================================================================================================
+func indexCustomerHoldings(stub shim.ChaincodeStubInterface, res Customer) error {
+	for _, holding := range res.Holdings {
+		err := putMemberEntry(stub, holding.MerchantID, res.CustomerID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+// ============================================================================================================================
+// getMerchantIdByName - merchantId of the one holding of the customer with the given merchant name, "" when none or more
+// than one holding has it, only for legacy records that name their merchant and nothing else -- Internal Function
+// ============================================================================================================================
+func getMerchantIdByName(res Customer, merchantNames ...string) string {
+	for _, merchantName := range merchantNames {
+		merchantId := ""
+		for _, holding := range res.Holdings {
+			if holding.MerchantName != merchantName {
+				continue
+			}
+			if merchantId != "" && merchantId != holding.MerchantID {
+				return ""											//two merchants by this name, neither can be picked
+			}
+			merchantId = holding.MerchantID
+		}
+		if merchantId != "" {
+			return merchantId
+		}
+	}
+	return ""
+}
+// ============================================================================================================================
+// Error - the JSON form of the error, so the peer's error message carries the code as well
+// ============================================================================================================================
+func (e *ChaincodeError) Error() string {
+	errorAsBytes, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+	return string(errorAsBytes)
+}
+// ============================================================================================================================
+// newError - a ChaincodeError with one of the Err codes -- Internal Function
+// ============================================================================================================================
+func newError(code string, message string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: message}
+}
+// ============================================================================================================================
+// errorResponse - reject the request with code, the way every invoke and query reports bad input -- Internal Function
+// ============================================================================================================================
+func errorResponse(code string, message string) ([]byte, error) {
+	fmt.Println(code + ": " + message)
+	return nil, newError(code, message)
+}
+// ============================================================================================================================
+// toResponse - shim.Success for a result, or a ChaincodeError as both message and payload so the peer rejects it -- Internal Function
+// ============================================================================================================================
+func toResponse(payload []byte, err error) pb.Response {
+	if err == nil {
+		return shim.Success(payload)
+	}
+	chaincodeErr, ok := err.(*ChaincodeError)
+	if !ok {
+		chaincodeErr = newError(ErrInternal, err.Error())				// storage and marshalling failures
+	}
+	errorAsBytes := []byte(chaincodeErr.Error())
+	return pb.Response{Status: shim.ERROR, Message: string(errorAsBytes), Payload: errorAsBytes}
+}
+// ============================================================================================================================
+// parseFixed - parse a decimal string such as "12.5" into an integer count of 10^-scale units, rejecting anything else -- Internal Function
+// ============================================================================================================================
+func parseFixed(value string, scale int) (int64, error) {
+	if value == "" {
+		return 0, errors.New("empty amount")
+	}
+	negative := false
+	digits := value
+	if digits[0] == '-' || digits[0] == '+' {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	wholePart := digits
+	fractionPart := ""
+	if dot := strings.Index(digits, "."); dot >= 0 {
+		wholePart = digits[:dot]
+		fractionPart = digits[dot+1:]
+	}
+	if wholePart == "" && fractionPart == "" {
+		return 0, errors.New("invalid amount " + value)
+	}
+	if len(fractionPart) > scale {
+		return 0, errors.New("amount " + value + " has more than " + strconv.Itoa(scale) + " decimal places")
+	}
+	for _, c := range wholePart + fractionPart {
+		if c < '0' || c > '9' {
+			return 0, errors.New("invalid amount " + value)
+		}
+	}
+	fractionPart = fractionPart + strings.Repeat("0", scale - len(fractionPart))
+	if wholePart == "" {
+		wholePart = "0"
+	}
+	result, err := strconv.ParseInt(wholePart + fractionPart, 10, 64)
+	if err != nil {
+		return 0, errors.New("amount " + value + " is out of range")
+	}
+	if negative {
+		result = -result
+	}
+	return result, nil
+}
+// ============================================================================================================================
+// formatFixed - format an integer count of 10^-scale units as a decimal string -- Internal Function
+// ============================================================================================================================
+func formatFixed(value int64, scale int) string {
+	sign := ""
+	magnitude := strconv.FormatInt(value, 10)
+	if value < 0 {
+		sign = "-"
+		magnitude = magnitude[1:]
+	}
+	if scale == 0 {
+		return sign + magnitude
+	}
+	if len(magnitude) <= scale {
+		magnitude = strings.Repeat("0", scale - len(magnitude) + 1) + magnitude
+	}
+	return sign + magnitude[:len(magnitude)-scale] + "." + magnitude[len(magnitude)-scale:]
+}
+// ============================================================================================================================
+// mulDiv - a * b / c truncated toward zero, failing instead of overflowing -- Internal Function
+// ============================================================================================================================
+func mulDiv(a int64, b int64, c int64) (int64, error) {
+	if c == 0 {
+		return 0, errors.New("division by zero")
+	}
+	result := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	result.Quo(result, big.NewInt(c))
+	if !result.IsInt64() {
+		return 0, errors.New("amount out of range")
+	}
+	return result.Int64(), nil
+}
+// ============================================================================================================================
+// addInt64 - a + b, failing instead of overflowing -- Internal Function
+// ============================================================================================================================
+func addInt64(a int64, b int64) (int64, error) {
+	result := a + b
+	if (b > 0 && result < a) || (b < 0 && result > a) {
+		return 0, errors.New("amount out of range")
+	}
+	return result, nil
+}
+// ============================================================================================================================
+// ParseMoney - parse a decimal amount such as "10.25" in the given currency
+// ============================================================================================================================
+func ParseMoney(value string, currency string) (Money, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	units, err := parseFixed(strings.TrimSpace(value), minorUnits(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Units: units, Currency: currency}, nil
+}
+// ============================================================================================================================
+// ParsePoints - parse a decimal points value such as "120.50"
+// ============================================================================================================================
+func ParsePoints(value string) (Points, error) {
+	points, err := parseFixed(strings.TrimSpace(value), PointsScale)
+	return Points(points), err
+}
+// ============================================================================================================================
+// ParseRate - parse a decimal rate such as "0.015"
+// ============================================================================================================================
+func ParseRate(value string) (Rate, error) {
+	rate, err := parseFixed(strings.TrimSpace(value), RateScale)
+	return Rate(rate), err
+}
+func (m Money) String() string {
+	return formatFixed(m.Units, minorUnits(m.Currency)) + " " + m.Currency
+}
+func (p Points) String() string {
+	return formatFixed(int64(p), PointsScale)
+}
+func (r Rate) String() string {
+	return formatFixed(int64(r), RateScale)
+}
+// ============================================================================================================================
+// Add - sum of two amounts in the same currency
+// ============================================================================================================================
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency == "" {
+		m.Currency = other.Currency
+	}
+	if other.Currency != "" && other.Currency != m.Currency {
+		return Money{}, errors.New("cannot add " + other.Currency + " to " + m.Currency)
+	}
+	units, err := addInt64(m.Units, other.Units)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Units: units, Currency: m.Currency}, nil
+}
+// ============================================================================================================================
+// Add - sum of two points values
+// ============================================================================================================================
+func (p Points) Add(other Points) (Points, error) {
+	points, err := addInt64(int64(p), int64(other))
+	return Points(points), err
+}
+// ============================================================================================================================
+// pointsWorth - worth of points at an exchange rate given in dollars per point -- Internal Function
+// ============================================================================================================================
+func pointsWorth(points Points, exchangeRate Rate, currency string) (Money, error) {
+	// hundredths of a point * millionths of a dollar per point * minor units per dollar / 10^8 = minor units
+	units, err := mulDiv(int64(points), int64(exchangeRate) * minorScale(currency), 100000000)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Units: units, Currency: currency}, nil
+}
+// ============================================================================================================================
+// moneyPoints - hundredths of a point an amount is worth at a rate given in dollars per point, the inverse of pointsWorth
+// -- Internal Function
+// ============================================================================================================================
+func moneyPoints(amount Money, rate Rate) (int64, error) {
+	// minor units * 10^8 / (millionths of a dollar per point * minor units per dollar) = hundredths of a point
+	return mulDiv(amount.Units, 100000000, int64(rate) * minorScale(amount.Currency))
+}
+// ============================================================================================================================
+// convertUnits - minor units of fromCurrency in toCurrency at rate, toCurrency per fromCurrency -- Internal Function
+// ============================================================================================================================
+func convertUnits(units int64, rate Rate, fromCurrency string, toCurrency string) (int64, error) {
+	return mulDiv(units, int64(rate) * minorScale(toCurrency), 1000000 * minorScale(fromCurrency))
+}
+// ============================================================================================================================
+// convertUnitsBack - minor units of toCurrency back in fromCurrency at rate, toCurrency per fromCurrency -- Internal Function
+// ============================================================================================================================
+func convertUnitsBack(units int64, rate Rate, fromCurrency string, toCurrency string) (int64, error) {
+	return mulDiv(units, 1000000 * minorScale(fromCurrency), int64(rate) * minorScale(toCurrency))
+}
+// ============================================================================================================================
+// minorUnits - decimal places of the minor unit of currency, MoneyScale unless CurrencyMinorUnits has it -- Internal Function
+// ============================================================================================================================
+func minorUnits(currency string) int {
+	if scale, ok := CurrencyMinorUnits[currency]; ok {
+		return scale
+	}
+	return MoneyScale
+}
+// ============================================================================================================================
+// minorScale - minor units in one unit of currency -- Internal Function
+// ============================================================================================================================
+func minorScale(currency string) int64 {
+	scale := int64(1)
+	for i := 0; i < minorUnits(currency); i++ {
+		scale *= 10
+	}
+	return scale
+}
+// ============================================================================================================================
+// UnmarshalJSON - read Points stored as an integer, or as a decimal string by chaincode versions before fixed point
+// ============================================================================================================================
+func (p *Points) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		points, err := ParsePoints(value)
+		if err != nil {
+			return err
+		}
+		*p = points
+		return nil
+	}
+	var points int64
+	if err := json.Unmarshal(data, &points); err != nil {
+		return err
+	}
+	*p = Points(points)
+	return nil
+}
+// ============================================================================================================================
+// UnmarshalJSON - read a Rate stored as an integer, or as a decimal string by chaincode versions before fixed point
+// ============================================================================================================================
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		rate, err := ParseRate(value)
+		if err != nil {
+			return err
+		}
+		*r = rate
+		return nil
+	}
+	var rate int64
+	if err := json.Unmarshal(data, &rate); err != nil {
+		return err
+	}
+	*r = Rate(rate)
+	return nil
+}
+// ============================================================================================================================
+// UnmarshalJSON - read Money stored as {units, currency}, or as a bare decimal string by chaincode versions before fixed point
+// ============================================================================================================================
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		units, err := parseFixed(strings.TrimSpace(value), minorUnits(DefaultCurrency))
+		if err != nil {
+			return err
+		}
+		*m = Money{Units: units}
+		return nil
+	}
+	type moneyFields Money
+	var fields moneyFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*m = Money(fields)
+	return nil
+}
@@ -0,0 +1,2153 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ============================================================================================================================
+// testStub - a MockStub invoked by an enrolled identity at a set proposal timestamp, with the paginated query MockStub
+// leaves unimplemented
+// ============================================================================================================================
+type testStub struct {
+	*shim.MockStub
+	t *testing.T
+	args []string
+	now time.Time
+	creators map[string][]byte
+	txn int
+}
+
+var testMspID = "Org1MSP"
+var testEpoch = time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+// ============================================================================================================================
+// newTestStub - a ledger initialized by "admin", which becomes its owner
+// ============================================================================================================================
+func newTestStub(t *testing.T) *testStub {
+	s := newEmptyTestStub(t)
+	s.as("admin")
+	mustSucceed(t, s.invoke("init", "x"))
+	return s
+}
+// ============================================================================================================================
+// newEmptyTestStub - a ledger nobody has initialized yet
+// ============================================================================================================================
+func newEmptyTestStub(t *testing.T) *testStub {
+	return &testStub{MockStub: shim.NewMockStub("manageLPM", new(ManageLPM)), t: t, now: testEpoch, creators: map[string][]byte{}}
+}
+// ============================================================================================================================
+// put - write value under key outside of any chaincode function, as an earlier chaincode version would have
+// ============================================================================================================================
+func (s *testStub) put(key string, value string) {
+	s.MockTransactionStart("seed")
+	defer s.MockTransactionEnd("seed")
+	err := s.PutState(key, []byte(value))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+// ============================================================================================================================
+// as - invoke as the identity Fabric CA enrolled as enrollmentId, its certificate carries the id as an attribute
+// ============================================================================================================================
+func (s *testStub) as(enrollmentId string) *testStub {
+	creator, ok := s.creators[enrollmentId]
+	if !ok {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		attrs, _ := json.Marshal(map[string]map[string]string{"attrs": {EnrollmentIDAttribute: enrollmentId}})
+		template := x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(s.creators) + 1)),
+			Subject: pkix.Name{CommonName: enrollmentId},
+			NotBefore: testEpoch.AddDate(-1, 0, 0),
+			NotAfter: testEpoch.AddDate(10, 0, 0),
+			ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrs}},
+		}
+		certAsBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		identity := &msp.SerializedIdentity{Mspid: testMspID, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes})}
+		creator, err = proto.Marshal(identity)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		s.creators[enrollmentId] = creator
+	}
+	s.Creator = creator
+	return s
+}
+// ============================================================================================================================
+// invoke - run function as one transaction, init goes to Init and everything else to Invoke
+// ============================================================================================================================
+func (s *testStub) invoke(function string, args ...string) pb.Response {
+	s.txn++
+	txId := fmt.Sprintf("tx%04d", s.txn)
+	s.args = append([]string{function}, args...)
+	for len(s.ChaincodeEventsChannel) > 0 {
+		<-s.ChaincodeEventsChannel
+	}
+	s.MockTransactionStart(txId)
+	defer s.MockTransactionEnd(txId)
+	cc := new(ManageLPM)
+	if function == "init" {
+		return cc.Init(s)
+	}
+	return cc.Invoke(s)
+}
+// ============================================================================================================================
+// event - the payload of the last event the last invocation sent, empty when it sent none
+// ============================================================================================================================
+func (s *testStub) event() string {
+	payload := ""
+	for len(s.ChaincodeEventsChannel) > 0 {
+		payload = string((<-s.ChaincodeEventsChannel).Payload)
+	}
+	return payload
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	var args [][]byte
+	for _, arg := range s.args {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	return s.args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	if len(s.args) == 0 {
+		return "", nil
+	}
+	return s.args[0], s.args[1:]
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}, nil
+}
+
+func (s *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIter, err := s.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIter.Close()
+	page := &pageIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for resultsIter.HasNext() {
+		queryResponse, err := resultsIter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if queryResponse.Key < bookmark {
+			continue
+		}
+		if len(page.keys) == int(pageSize) {
+			metadata.Bookmark = queryResponse.Key								//the next page starts at the first key left out
+			break
+		}
+		page.keys = append(page.keys, queryResponse.Key)
+		page.values = append(page.values, queryResponse.Value)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.keys))
+	return page, metadata, nil
+}
+
+type pageIterator struct {
+	keys []string
+	values [][]byte
+}
+
+func (p *pageIterator) HasNext() bool {
+	return len(p.keys) > 0
+}
+
+func (p *pageIterator) Next() (*queryresult.KV, error) {
+	res := &queryresult.KV{Key: p.keys[0], Value: p.values[0]}
+	p.keys, p.values = p.keys[1:], p.values[1:]
+	return res, nil
+}
+
+func (p *pageIterator) Close() error {
+	return nil
+}
+
+// ============================================================================================================================
+// mustSucceed - fail the test unless the response is a success, its payload is returned
+// ============================================================================================================================
+func mustSucceed(t *testing.T, r pb.Response) []byte {
+	t.Helper()
+	if r.Status != shim.OK {
+		t.Fatalf("expected success, got %d %s", r.Status, r.Message)
+	}
+	return r.Payload
+}
+// ============================================================================================================================
+// errorCode - the code of the ChaincodeError a rejected request returned, empty for a success
+// ============================================================================================================================
+func errorCode(t *testing.T, r pb.Response) string {
+	t.Helper()
+	if r.Status == shim.OK {
+		return ""
+	}
+	res := ChaincodeError{}
+	err := json.Unmarshal([]byte(r.Message), &res)
+	if err != nil {
+		t.Fatalf("malformed error %q: %s", r.Message, err)
+	}
+	return res.Code
+}
+// ============================================================================================================================
+// expectCode - invoke function as caller, failing the test unless it returns code, empty for a success, the response is
+// returned
+// ============================================================================================================================
+func (s *testStub) expectCode(t *testing.T, caller string, function string, args []string, code string) pb.Response {
+	t.Helper()
+	s.as(caller)
+	r := s.invoke(function, args...)
+	if got := errorCode(t, r); got != code {
+		t.Fatalf("expected %q from %s, got %q: %s", code, function, got, r.Message)
+	}
+	return r
+}
+// ============================================================================================================================
+// invokeCase - one row of a table of invocations and the error code each should return, empty for a success
+// ============================================================================================================================
+type invokeCase struct {
+	name string
+	caller string
+	function string
+	args []string
+	code string
+}
+// ============================================================================================================================
+// runInvokeCases - run each case as a subtest through expectCode, then hand its response to check when that is set
+// ============================================================================================================================
+func runInvokeCases(t *testing.T, s *testStub, cases []invokeCase, check func(t *testing.T, tt invokeCase, r pb.Response)) {
+	t.Helper()
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := s.expectCode(t, tt.caller, tt.function, tt.args, tt.code)
+			if check != nil {
+				check(t, tt, r)
+			}
+		})
+	}
+}
+// ============================================================================================================================
+// stateForTest - a copy of the world state to compare against after invoking
+// ============================================================================================================================
+func stateForTest(s *testStub) map[string]string {
+	state := map[string]string{}
+	for key, value := range s.State {
+		state[key] = string(value)
+	}
+	return state
+}
+// ============================================================================================================================
+// expectStateUnchanged - fail the test when the world state differs from the copy stateForTest took, what names the writer
+// ============================================================================================================================
+func expectStateUnchanged(t *testing.T, s *testStub, state map[string]string, what string) {
+	t.Helper()
+	if len(s.State) != len(state) {
+		t.Fatalf("%s changed the number of keys from %d to %d", what, len(state), len(s.State))
+	}
+	for key, value := range s.State {
+		if state[key] != string(value) {
+			t.Fatalf("%s changed %s", what, key)
+		}
+	}
+}
+// ============================================================================================================================
+// getCustomerForTest - the stored Customer, failing the test when it is missing
+// ============================================================================================================================
+func getCustomerForTest(t *testing.T, s *testStub, customerId string) Customer {
+	t.Helper()
+	res := Customer{}
+	err := json.Unmarshal(mustSucceed(t, s.invoke("getCustomerByID", customerId, "true")), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+// ============================================================================================================================
+// holdingForTest - the customer's holding with merchantId, failing the test when there is none
+// ============================================================================================================================
+func holdingForTest(t *testing.T, s *testStub, customerId string, merchantId string) MerchantHolding {
+	t.Helper()
+	res := getCustomerForTest(t, s, customerId)
+	holdingIndex := getHoldingIndex(res, merchantId)
+	if holdingIndex < 0 {
+		t.Fatalf("%s has no holding with %s", customerId, merchantId)
+	}
+	return res.Holdings[holdingIndex]
+}
+// ============================================================================================================================
+// holdingIdsForTest - the merchantIds of the customer's holdings in order, comma separated
+// ============================================================================================================================
+func holdingIdsForTest(t *testing.T, s *testStub, customerId string) string {
+	t.Helper()
+	var merchantIds []string
+	for _, holding := range getCustomerForTest(t, s, customerId).Holdings {
+		merchantIds = append(merchantIds, holding.MerchantID)
+	}
+	return strings.Join(merchantIds, ",")
+}
+// ============================================================================================================================
+// walletWorthForTest - the customer's walletWorth, failing the test when it is not what its holdings are worth together,
+// for customers whose holdings are all in the wallet's currency
+// ============================================================================================================================
+func walletWorthForTest(t *testing.T, s *testStub, customerId string) int64 {
+	t.Helper()
+	res := getCustomerForTest(t, s, customerId)
+	worth := int64(0)
+	for _, holding := range res.Holdings {
+		worth += holding.Worth.Units
+	}
+	if res.WalletWorth.Units != worth {
+		t.Fatalf("walletWorth of %s is %d, its holdings are worth %d", customerId, res.WalletWorth.Units, worth)
+	}
+	return res.WalletWorth.Units
+}
+// ============================================================================================================================
+// setupMerchant - create a merchant at pointsPerDollarSpent and exchangeRate in currency and bind "<merchantId>user" to it
+// ============================================================================================================================
+func setupMerchant(t *testing.T, s *testStub, merchantId string, pointsPerDollarSpent string, exchangeRate string, currency string) {
+	t.Helper()
+	s.as("admin")
+	mustSucceed(t, s.invoke("createMerchant", merchantId, merchantId + "user", "Merchant " + merchantId, "food", "red", pointsPerDollarSpent, exchangeRate, "0", currency, "2020-01-01"))
+	mustSucceed(t, s.invoke("bindRole", testMspID + "/" + merchantId + "user", RoleMerchant, merchantId))
+}
+// ============================================================================================================================
+// setupCustomer - create a customer of merchantId holding points worth worth and bind "<customerId>user" to it
+// ============================================================================================================================
+func setupCustomer(t *testing.T, s *testStub, customerId string, merchantId string, currency string, points string, worth string) {
+	t.Helper()
+	s.as("admin")
+	mustSucceed(t, s.invoke("createCustomer", customerId, customerId + "user", "Customer " + customerId, worth, merchantId, "Merchant " + merchantId, "red", currency, points, worth, "", "", ""))
+	mustSucceed(t, s.invoke("bindRole", testMspID + "/" + customerId + "user", RoleCustomer, customerId))
+}
+
+func TestAccumulationComputesPoints(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	tests := []struct {
+		name string
+		amountSpent string
+		code string
+		points Points
+	}{
+		{"a point for every two dollars", "100.00", "", 5000},
+		{"fractions of a point round down", "0.55", "", 5027},
+		{"not a number", "abc", ErrInvalidArgument, 5027},
+		{"zero", "0", ErrInvalidArgument, 5027},
+		{"negative", "-5", ErrInvalidArgument, 5027},
+		{"too many decimals", "1.001", ErrInvalidArgument, 5027},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.expectCode(t, "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", tt.amountSpent, fmt.Sprintf("acc%d", i), ""}, tt.code)
+			if holding := holdingForTest(t, s, "c1", "m1"); holding.Points != tt.points {
+				t.Fatalf("expected %d points, got %d", tt.points, holding.Points)
+			}
+		})
+	}
+}
+
+func TestPurchaseRedeemsPoints(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	tests := []struct {
+		name string
+		purchaseAmount string
+		code string
+		points Points
+		purchaseBalance int64
+	}{
+		{"at the exchange rate", "0.30", "", 7000, 30},
+		{"less than a cent", "0.005", ErrInvalidArgument, 7000, 30},
+		{"one cent", "0.01", "", 6900, 31},
+		{"more than held", "0.70", ErrInsufficientPoints, 6900, 31},
+		{"not a number", "x", ErrInvalidArgument, 6900, 31},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.expectCode(t, "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", tt.purchaseAmount, fmt.Sprintf("p%da", i), fmt.Sprintf("p%db", i), ""}, tt.code)
+			if holding := holdingForTest(t, s, "c1", "m1"); holding.Points != tt.points {
+				t.Fatalf("expected %d points, got %d", tt.points, holding.Points)
+			}
+			res_Merchant := Merchant{}
+			json.Unmarshal(mustSucceed(t, s.invoke("getMerchantByID", "m1")), &res_Merchant)
+			if res_Merchant.PurchaseBalance.Units != tt.purchaseBalance {
+				t.Fatalf("expected a purchaseBalance of %d, got %d", tt.purchaseBalance, res_Merchant.PurchaseBalance.Units)
+			}
+		})
+	}
+}
+
+func TestTransferMovesPoints(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c3", "m2", "USD", "0", "0")
+	tests := []struct {
+		name string
+		to string
+		points string
+		code string
+		from Points
+		received Points
+	}{
+		{"between members", "c2", "10", "", 9000, 1000},
+		{"hundredths", "c2", "0.25", "", 8975, 1025},
+		{"to itself", "c1", "1", ErrInvalidArgument, 8975, 1025},
+		{"more than held", "c2", "90", ErrInsufficientPoints, 8975, 1025},
+		{"negative", "c2", "-1", ErrInvalidArgument, 8975, 1025},
+		{"to a customer of another merchant", "c3", "1", ErrFailedPrecondition, 8975, 1025},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.expectCode(t, "c1user", "updateCustomerTransferSC", []string{"c1", tt.to, "m1", tt.points, fmt.Sprintf("t%da", i), fmt.Sprintf("t%db", i), ""}, tt.code)
+			if holding := holdingForTest(t, s, "c1", "m1"); holding.Points != tt.from {
+				t.Fatalf("expected %d points left, got %d", tt.from, holding.Points)
+			}
+			if holding := holdingForTest(t, s, "c2", "m1"); holding.Points != tt.received {
+				t.Fatalf("expected %d points received, got %d", tt.received, holding.Points)
+			}
+		})
+	}
+}
+
+func TestAssociateAddsHoldings(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.02", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	tests := []struct {
+		name string
+		merchantId string
+		startingBalance string
+		code string
+		holdings string
+	}{
+		{"a second merchant", "m2", "10.00", "", "m1,m2"},
+		{"the same merchant again", "m2", "0", ErrAlreadyExists, "m1,m2"},
+		{"an unknown merchant", "m9", "0", ErrNotFound, "m1,m2"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.expectCode(t, "admin", "associateCustomer", []string{"c1", tt.merchantId, tt.startingBalance, fmt.Sprintf("a%d", i), "", ""}, tt.code)
+			if holdings := holdingIdsForTest(t, s, "c1"); holdings != tt.holdings {
+				t.Fatalf("expected holdings with %s, got %s", tt.holdings, holdings)
+			}
+		})
+	}
+	// 10.00 at one point for every dollar
+	if holding := holdingForTest(t, s, "c1", "m2"); holding.Points != 1000 || holding.Worth.Units != 1000 || holding.Currency != "USD" {
+		t.Fatalf("unexpected holding %+v", holding)
+	}
+}
+
+func TestParseFixedPoint(t *testing.T) {
+	tests := []struct {
+		value string
+		points Points
+		money int64
+		rate Rate
+		err bool													// points and money reject it
+		rateErr bool
+	}{
+		{"120.50", 12050, 12050, 120500000, false, false},
+		{"7", 700, 700, 7000000, false, false},
+		{".5", 50, 50, 500000, false, false},
+		{" 1.2 ", 120, 120, 1200000, false, false},
+		{"-3.25", -325, -325, -3250000, false, false},
+		{"0.015", 0, 0, 15000, true, false},
+		{"1.0000001", 0, 0, 0, true, true},
+		{"", 0, 0, 0, true, true},
+		{".", 0, 0, 0, true, true},
+		{"1e3", 0, 0, 0, true, true},
+		{"1,5", 0, 0, 0, true, true},
+		{"99999999999999999999", 0, 0, 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			points, err := ParsePoints(tt.value)
+			if (err != nil) != tt.err || (err == nil && points != tt.points) {
+				t.Fatalf("ParsePoints: expected %d, got %d, %v", tt.points, points, err)
+			}
+			money, err := ParseMoney(tt.value, "EUR")
+			if (err != nil) != tt.err || (err == nil && (money.Units != tt.money || money.Currency != "EUR")) {
+				t.Fatalf("ParseMoney: expected %d EUR, got %+v, %v", tt.money, money, err)
+			}
+			rate, err := ParseRate(tt.value)
+			if (err != nil) != tt.rateErr || (err == nil && rate != tt.rate) {
+				t.Fatalf("ParseRate: expected %d, got %d, %v", tt.rate, rate, err)
+			}
+		})
+	}
+}
+
+func TestFixedPointArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got string
+		expected string
+	}{
+		{"points format with two decimals", Points(12050).String(), "120.50"},
+		{"negative points", Points(-5).String(), "-0.05"},
+		{"money carries its currency", Money{Units: 1999, Currency: "USD"}.String(), "19.99 USD"},
+		{"yen have no minor unit", Money{Units: 1999, Currency: "JPY"}.String(), "1999 JPY"},
+		{"dinars have three decimals", Money{Units: 1999, Currency: "BHD"}.String(), "1.999 BHD"},
+		{"rates format with six decimals", Rate(15000).String(), "0.015000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, tt.got)
+			}
+		})
+	}
+	if _, err := Points(9223372036854775807).Add(1); err == nil {
+		t.Fatal("points overflowed")
+	}
+	if _, err := (Money{Units: 1, Currency: "USD"}).Add(Money{Units: 1, Currency: "EUR"}); err == nil {
+		t.Fatal("added money in two currencies")
+	}
+	worth := func(points Points, rate Rate, currency string) func() (int64, error) {
+		return func() (int64, error) {
+			money, err := pointsWorth(points, rate, currency)
+			return money.Units, err
+		}
+	}
+	// 12.34 points at 0.015 per point is 18.51 cents rounded down, 0 yen and 185 fils, 2 yen buy 200 points at 0.01 yen
+	// per point, and 10.00 USD is 1500 JPY at 150 and 3.760 BHD at 0.376
+	amounts := []struct {
+		name string
+		units func() (int64, error)
+		expected int64
+	}{
+		{"points worth cents", worth(1234, 15000, "USD"), 18},
+		{"points worth yen", worth(1234, 15000, "JPY"), 0},
+		{"points worth fils", worth(1234, 15000, "BHD"), 185},
+		{"yen buy points", func() (int64, error) { return moneyPoints(Money{Units: 2, Currency: "JPY"}, 10000) }, 20000},
+		{"dollars to yen", func() (int64, error) { return convertUnits(1000, 150000000, "USD", "JPY") }, 1500},
+		{"dollars to dinars", func() (int64, error) { return convertUnits(1000, 376000, "USD", "BHD") }, 3760},
+		{"dinars back to dollars", func() (int64, error) { return convertUnitsBack(3760, 376000, "USD", "BHD") }, 1000},
+		{"dinars with three decimals", func() (int64, error) { money, err := ParseMoney("1.5", "KWD"); return money.Units, err }, 1500},
+	}
+	for _, tt := range amounts {
+		t.Run(tt.name, func(t *testing.T) {
+			if units, err := tt.units(); err != nil || units != tt.expected {
+				t.Fatalf("expected %d, got %d, %v", tt.expected, units, err)
+			}
+		})
+	}
+	if money, err := ParseMoney("1.5", "JPY"); err == nil {
+		t.Fatalf("expected fractional yen to be rejected, got %+v", money)
+	}
+	legacy := struct{ Points Points `json:"points"` }{}
+	if err := json.Unmarshal([]byte(`{"points":"12.5"}`), &legacy); err != nil || legacy.Points != 1250 {
+		t.Fatalf("expected legacy points to read as 1250, got %d, %v", legacy.Points, err)
+	}
+}
+
+func TestRecordsRoundTripAsJSON(t *testing.T) {
+	s := newTestStub(t)
+	tests := []struct {
+		name string
+		merchantName string
+	}{
+		{"plain", "Corner Shop"},
+		{"quotes", `Joe's "Best" Deli`},
+		{"backslash and braces", `C:\shop {1}`},
+		{"comma separated", "Fish, Chips"},
+		{"unicode", "Café Zoë ☕"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merchantId := fmt.Sprintf("m%d", i)
+			s.as("admin")
+			mustSucceed(t, s.invoke("createMerchant", merchantId, "user", tt.merchantName, "food", "red", "1", "0.01", "0", "USD", "2020-01-01"))
+			res := Merchant{}
+			err := json.Unmarshal(mustSucceed(t, s.invoke("getMerchantByID", merchantId)), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.MerchantName != tt.merchantName || res.PointsPerDollarSpent != 1000000 || res.ExchangeRate != 10000 {
+				t.Fatalf("unexpected Merchant %+v", res)
+			}
+		})
+	}
+}
+
+// ============================================================================================================================
+// recordIdsForTest - the ids of the records a listing query returns keyed by id, sorted and comma separated
+// ============================================================================================================================
+func recordIdsForTest(t *testing.T, s *testStub, function string, args ...string) string {
+	t.Helper()
+	var records map[string]json.RawMessage
+	err := json.Unmarshal(mustSucceed(t, s.invoke(function, args...)), &records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestRecordsUnderCompositeKeys(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.02", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c2", "m2", "USD", "0", "0")
+	setupCustomer(t, s, "c3", "m1", "USD", "0", "0")
+	for _, key := range []string{"_Customerindex", "_Merchantindex", "_Transactionindex", "c1", "m1"} {
+		if _, ok := s.State[key]; ok {
+			t.Fatalf("%s is stored under a plain key", key)
+		}
+	}
+	tests := []struct {
+		function string
+		args []string
+		ids string
+	}{
+		{"getCustomersByMerchantID", []string{"m1"}, "c1,c3"},
+		{"getCustomersByMerchantID", []string{"m2"}, "c2"},
+		{"getCustomersByMerchantID", []string{"m9"}, ""},
+		{"getAllCustomers", nil, "c1,c2,c3"},
+		{"getAllMerchants", nil, "m1,m2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.function + strings.Join(tt.args, ","), func(t *testing.T) {
+			if ids := recordIdsForTest(t, s, tt.function, tt.args...); ids != tt.ids {
+				t.Fatalf("expected %s, got %s", tt.ids, ids)
+			}
+		})
+	}
+}
+
+func TestMigrateUpgradesLegacyRecords(t *testing.T) {
+	defer func(size int) { MigrationIndexChunkSize = size }(MigrationIndexChunkSize)
+	MigrationIndexChunkSize = 1												// every batch crosses chunks
+	s := newEmptyTestStub(t)
+	s.put(MerchantIndexStr, `["m1"]`)
+	s.put("m1", `{"merchantId":"m1","merchantUserName":"m1user","merchantName":"Merchant m1","pointsPerDollarSpent":"2","exchangeRate":"0.01","purchaseBalance":"","merchantCurrency":"USD","merchantInitialBalance":"1000"}`)
+	s.put(CustomerIndexStr, `["c1","c2","c3"]`)
+	s.put("c1", `{"customerId":"c1","userName":"c1user","walletWorth":"0.5","merchantIDs":"m1","merchantNames":"Merchant m1","merchantColors":"red","merchantCurrencies":"USD","merchantsPointsCount":"50","merchantsPointsWorth":"0.5"}`)
+	s.put("c2", `{"customerId":`)
+	s.put("c3", `{"customerId":`)
+	s.put(TransactionIndexStr, `["t1"]`)
+	s.put("t1", `{"transactionId":"t1","transactionDateTime":"2018-05-01 10:00:00","transactionType":"Accumulation","transactionFrom":"Merchant m1","transactionTo":"c1","credit":"50","debit":"0","customerId":"c1"}`)
+	s.as("admin")
+	mustSucceed(t, s.invoke("init", "x"))
+	if string(s.State[SchemaVersionKey]) != "" {
+		t.Fatalf("schema version %s set before migrating", s.State[SchemaVersionKey])
+	}
+
+	batches := []struct {
+		name string
+		fix func()
+		records int
+		migrated int
+		failures []string
+		done bool
+	}{
+		{"merchants then customers", nil, 2, 2, nil, false},
+		{"unreadable customers reported", nil, 2, 0, []string{"c2", "c3"}, false},
+		{"transactions then the first retry", nil, 2, 1, []string{"c2"}, false},
+		{"retried while unreadable", nil, 2, 0, []string{"c3", "c2"}, false},
+		// c2 failed MaxMigrationAttempts times and is skipped past
+		{"retried once repaired", func() { s.put("c3", `{"customerId":"c3","userName":"c3user"}`) }, 1, 1, nil, true},
+	}
+	for _, tt := range batches {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fix != nil {
+				tt.fix()
+			}
+			s.as("admin")
+			mustSucceed(t, s.invoke("migrate", "2"))
+			report := MigrationReport{}
+			err := json.Unmarshal([]byte(s.event()), &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failures []string
+			for _, failure := range report.Failures {
+				failures = append(failures, failure.Key)
+			}
+			if report.Records != tt.records || report.Migrated != tt.migrated || report.Progress.Done != tt.done || strings.Join(failures, ",") != strings.Join(tt.failures, ",") {
+				t.Fatalf("unexpected report %+v", report)
+			}
+		})
+	}
+
+	s.expectCode(t, "admin", "migrate", nil, ErrFailedPrecondition)						// once done
+	status := struct {
+		SchemaVersion int `json:"schemaVersion"`
+		Progress MigrationProgress `json:"progress"`
+		Reports []MigrationReport `json:"reports"`
+	}{}
+	err := json.Unmarshal(mustSucceed(t, s.invoke("getMigrationStatus")), &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SchemaVersion != SchemaVersion || len(status.Reports) != len(batches) || len(status.Progress.FailedRecords) != 1 || status.Progress.FailedRecords[0].Key != "c2" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	for _, key := range []string{"m1", "c1", "c3", "t1"} {
+		if _, ok := s.State[key]; ok {
+			t.Fatalf("%s is still stored under its plain key", key)
+		}
+	}
+	for key := range s.State {
+		if objectType, _, _ := s.SplitCompositeKey(key); objectType == MigrationIndexChunkObjectType {
+			t.Fatalf("index chunk %q left after migrating", key)
+		}
+	}
+	holding := holdingForTest(t, s, "c1", "m1")
+	if holding.Points != 5000 {
+		t.Fatalf("expected 5000 hundredths of a point, got %d", holding.Points)
+	}
+}
+
+func TestMigrateSkipsFailedRecords(t *testing.T) {
+	s := newEmptyTestStub(t)
+	s.put(CustomerIndexStr, `["c1"]`)
+	s.put("c1", `{"customerId":`)
+	s.as("admin")
+	mustSucceed(t, s.invoke("init", "x"))
+	tests := []struct {
+		name string
+		args []string
+		code string
+		done bool
+	}{
+		{"unreadable customer", []string{"10"}, "", false},
+		{"skipFailed that does not parse", []string{"10", "maybe"}, ErrInvalidArgument, false},
+		{"skipped past", []string{"", "true"}, "", true},
+		{"done", nil, ErrFailedPrecondition, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.expectCode(t, "admin", "migrate", tt.args, tt.code)
+			if version := string(s.State[SchemaVersionKey]) == strconv.Itoa(SchemaVersion); version != tt.done {
+				t.Fatalf("expected done %t, got schema version %q", tt.done, s.State[SchemaVersionKey])
+			}
+		})
+	}
+	if _, ok := s.State["c1"]; !ok {
+		t.Fatal("the skipped record was removed from its plain key")
+	}
+}
+
+func TestErrorsCarryCodes(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "1", "0.01")
+	customerKey, err := getRecordKey(s, CustomerObjectType, "c9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.put(customerKey, "{")
+	tests := []invokeCase{
+		{"unknown customer", "admin", "getCustomerByID", []string{"c8"}, ErrNotFound},
+		{"unknown merchant", "admin", "getMerchantByID", []string{"m8"}, ErrNotFound},
+		{"existing merchant", "admin", "createMerchant", []string{"m1", "m1user", "Merchant m1", "food", "red", "2", "0.01", "0", "USD", "2020-01-01"}, ErrAlreadyExists},
+		{"customer of an unknown merchant", "admin", "createCustomer", []string{"c7", "c7user", "Customer c7", "0", "m8", "Merchant m8", "red", "USD", "0", "0", "", "", ""}, ErrNotFound},
+		{"missing arguments", "admin", "getCustomerByID", nil, ErrInvalidArgument},
+		{"amount that does not parse", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "ten", "e1", ""}, ErrInvalidArgument},
+		{"more points than held", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "1.00", "e2a", "e2b", ""}, ErrInsufficientPoints},
+		{"malformed record", "admin", "getCustomerByID", []string{"c9"}, ErrInternal},
+	}
+	runInvokeCases(t, s, tests, func(t *testing.T, tt invokeCase, r pb.Response) {
+		if r.Status != shim.ERROR || string(r.Payload) != r.Message {
+			t.Fatalf("expected the error as both message and payload, got %d %q %q", r.Status, r.Message, r.Payload)
+		}
+		if event := s.event(); event != "" {
+			t.Fatalf("rejected request sent event %s", event)
+		}
+	})
+}
+
+func TestDispatcherRoutesFunctions(t *testing.T) {
+	// every function the pre-1.0 Invoke and Query handled, clients still call them by these names
+	legacyFunctions := []string{"associateCustomer", "createCustomer", "createMerchant", "createOwner", "deleteCustomer", "deleteMerchant",
+		"getActivityHistory", "getActivityHistoryForMerchant", "getAllCustomers", "getAllMerchants", "getCustomerByID", "getCustomerDetailsByID",
+		"getCustomersByMerchantID", "getMerchantByID", "getMerchantByName", "getMerchantDetailsByID", "getMerchantsAccountBalance",
+		"getMerchantsByIndustry", "getMerchantsUserCount", "getOwnerByID", "getOwnersMerchantUserCount", "updateCustomerAccumulation",
+		"updateCustomerPurchase", "updateCustomerTransfer", "updateMerchant", "updateMerchantsExchangeRate", "updateMerchantsPPDS"}
+	for _, function := range legacyFunctions {
+		_, query := queryFunctions[function]
+		_, invoke := invokeFunctions[function]
+		if query == invoke {
+			t.Fatalf("%s should be routed by exactly one table", function)
+		}
+	}
+
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "1", "0.01")
+	tests := []invokeCase{
+		{"unknown function", "admin", "query", []string{"c1"}, ErrInvalidArgument},
+		{"empty function", "admin", "", nil, ErrInvalidArgument},
+		{"read only", "admin", "getMerchantByID", []string{"m1"}, ""},
+		{"renamed read only", "admin", "getActivityHistoryForMerchant", []string{"m1"}, ""},
+		{"mutating", "m1user", "updateMerchantsPPDS", []string{"m1", "4", "2020-02-01"}, ""},
+		{"pre-1.0 accumulation", "m1user", "updateCustomerAccumulation", []string{"c1", "1.00", "100", "1.00", "l1", "2020-02-01", "Accumulation", "Merchant m1", "c1", "100", "0"}, ErrDeprecated},
+		{"pre-1.0 purchase", "c1user", "updateCustomerPurchase", make([]string, 20), ErrDeprecated},
+		{"pre-1.0 transfer", "c1user", "updateCustomerTransfer", make([]string, 21), ErrDeprecated},
+		{"pre-1.0 name with another arity", "m1user", "updateCustomerAccumulation", []string{"c1", "m1", "1.00", "l2", ""}, ErrInvalidArgument},
+	}
+	runInvokeCases(t, s, tests, nil)
+
+	// read-only functions see the ledger as it is and leave it that way
+	state := stateForTest(s)
+	for function := range queryFunctions {
+		for _, args := range [][]string{nil, {"c1"}, {"m1"}, {"c1", "m1"}} {
+			s.as("admin")
+			s.invoke(function, args...)
+			if event := s.event(); event != "" {
+				t.Fatalf("%s sent event %s", function, event)
+			}
+		}
+	}
+	expectStateUnchanged(t, s, state, "read-only functions")
+}
+
+func TestRolesGuardFunctions(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	tests := []invokeCase{
+		{"unbound identity creates a merchant", "stranger", "createMerchant", []string{"m3", "m3user", "Merchant m3", "food", "red", "2", "0.01", "0", "USD", "2020-01-01"}, ErrPermissionDenied},
+		{"merchant creates a merchant", "m1user", "createMerchant", []string{"m3", "m3user", "Merchant m3", "food", "red", "2", "0.01", "0", "USD", "2020-01-01"}, ErrPermissionDenied},
+		{"owner creates a merchant", "admin", "createMerchant", []string{"m3", "m3user", "Merchant m3", "food", "red", "2", "0.01", "0", "USD", "2020-01-01"}, ""},
+		{"customer creates an owner", "c1user", "createOwner", []string{"o2", "o2user", "Owner o2"}, ErrPermissionDenied},
+		{"merchant changes another merchant's PPDS", "m2user", "updateMerchantsPPDS", []string{"m1", "4", "2020-02-01"}, ErrPermissionDenied},
+		{"owner changes a merchant's PPDS", "admin", "updateMerchantsPPDS", []string{"m1", "4", "2020-02-01"}, ErrPermissionDenied},
+		{"merchant changes its own PPDS", "m1user", "updateMerchantsPPDS", []string{"m1", "4", "2020-02-01"}, ""},
+		{"merchant changes another merchant's exchange rate", "m2user", "updateMerchantsExchangeRate", []string{"m1", "0.02", "2020-02-01"}, ErrPermissionDenied},
+		{"merchant changes its own exchange rate", "m1user", "updateMerchantsExchangeRate", []string{"m1", "0.02", "2020-02-01"}, ""},
+		{"customer spends another customer's points", "c2user", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "1", "r1a", "r1b", ""}, ErrPermissionDenied},
+		{"other merchant spends a customer's points", "m2user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r2a", "r2b", ""}, ErrPermissionDenied},
+		{"merchant acts for its customer", "m1user", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "1", "r3a", "r3b", ""}, ""},
+		{"customer spends its own points", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r4a", "r4b", ""}, ""},
+		{"customer deletes another customer", "c1user", "deleteCustomer", []string{"c2"}, ErrPermissionDenied},
+		{"merchant deletes itself", "m1user", "deleteMerchant", []string{"m1", DeactivationCashOut}, ErrPermissionDenied},
+		{"customer binds a role", "c1user", "bindRole", []string{testMspID + "/c1user", RoleOwner, ""}, ErrPermissionDenied},
+		{"merchant unbinds a role", "m1user", "unbindRole", []string{testMspID + "/m2user"}, ErrPermissionDenied},
+		{"customer reads the bindings", "c1user", "getRoleBindings", nil, ErrPermissionDenied},
+		{"owner unbinds a merchant", "admin", "unbindRole", []string{testMspID + "/m1user"}, ""},
+		{"unbound merchant changes its PPDS", "m1user", "updateMerchantsPPDS", []string{"m1", "3", "2020-02-02"}, ErrPermissionDenied},
+	}
+	runInvokeCases(t, s, tests, nil)
+}
+
+func TestCallerIdentityFromCertificate(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	tests := []struct {
+		enrollmentId string
+		binding RoleBinding
+	}{
+		{"admin", RoleBinding{Identity: testMspID + "/admin", Role: RoleOwner}},
+		{"m1user", RoleBinding{Identity: testMspID + "/m1user", Role: RoleMerchant, EntityID: "m1"}},
+		{"stranger", RoleBinding{Identity: testMspID + "/stranger"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.enrollmentId, func(t *testing.T) {
+			s.as(tt.enrollmentId)
+			res := RoleBinding{}
+			err := json.Unmarshal(mustSucceed(t, s.invoke("getCallerIdentity")), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Identity != tt.binding.Identity || res.Role != tt.binding.Role || res.EntityID != tt.binding.EntityID {
+				t.Fatalf("expected %+v, got %+v", tt.binding, res)
+			}
+		})
+	}
+}
+
+func TestFailedInvocationsWriteNothing(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "m2", "USD", "0", "0")
+	invokeFunctions["writeThenFail"] = func(t *ManageLPM, stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+		err := stub.PutState("abc", []byte("changed"))
+		if err == nil {
+			err = setEvent(stub, "evtsender", Event{Message: "written", Code: "200"})
+		}
+		if err == nil {
+			err = newError(ErrInternal, "failed after writing")
+		}
+		return nil, err
+	}
+	defer delete(invokeFunctions, "writeThenFail")
+	// m2 is removed from under its customers, so anything that reaches it fails after reading the customer
+	merchantKey, err := getRecordKey(s, MerchantObjectType, "m2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MockTransactionStart("seed")
+	s.DelState(merchantKey)
+	s.MockTransactionEnd("seed")
+
+	tests := []invokeCase{
+		{"function that writes then fails", "admin", "writeThenFail", nil, ErrInternal},
+		{"purchase from a missing merchant", "c2user", "updateCustomerPurchaseSC", []string{"c2", "m2", "0.01", "f1a", "f1b", ""}, ErrNotFound},
+		{"transfer to a missing customer", "c1user", "updateCustomerTransferSC", []string{"c1", "c9", "m1", "1", "f2a", "f2b", ""}, ErrNotFound},
+		{"associate with a missing merchant", "admin", "associateCustomer", []string{"c1", "m2", "10.00", "f4", "", ""}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := stateForTest(s)
+			s.expectCode(t, tt.caller, tt.function, tt.args, tt.code)
+			if event := s.event(); event != "" {
+				t.Fatalf("failed invocation sent event %s", event)
+			}
+			expectStateUnchanged(t, s, state, "failed invocation")
+		})
+	}
+}
+
+func TestWriteBatchReadsItsOwnWrites(t *testing.T) {
+	s := newEmptyTestStub(t)
+	keyA, _ := s.CreateCompositeKey("thing", []string{"a"})
+	keyB, _ := s.CreateCompositeKey("thing", []string{"b"})
+	keyC, _ := s.CreateCompositeKey("thing", []string{"c"})
+	s.put(keyA, "a0")
+	s.put(keyB, "b0")
+	s.MockTransactionStart("batch")
+	defer s.MockTransactionEnd("batch")
+	batch := newWriteBatch(s)
+	batch.PutState(keyA, []byte("a1"))
+	batch.DelState(keyB)
+	batch.PutState(keyC, []byte("c1"))
+	batch.PutState("plain", []byte("p1"))
+
+	tests := []struct {
+		name string
+		stub shim.ChaincodeStubInterface
+		values string
+	}{
+		{"staged", batch, "thing:a=a1,thing:c=c1"},
+		{"ledger before commit", s, "thing:a=a0,thing:b=b0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resultsIter, err := tt.stub.GetStateByPartialCompositeKey("thing", []string{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resultsIter.Close()
+			var values []string
+			for resultsIter.HasNext() {
+				queryResponse, err := resultsIter.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, attributes, _ := s.SplitCompositeKey(queryResponse.Key)
+				values = append(values, "thing:" + attributes[0] + "=" + string(queryResponse.Value))
+			}
+			if strings.Join(values, ",") != tt.values {
+				t.Fatalf("expected %s, got %s", tt.values, strings.Join(values, ","))
+			}
+		})
+	}
+	if value, _ := batch.GetState(keyB); value != nil {
+		t.Fatalf("deleted key read as %s", value)
+	}
+	if value, _ := s.GetState("plain"); value != nil {
+		t.Fatalf("staged key reached the ledger before commit as %s", value)
+	}
+	err := batch.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := s.GetState(keyB); value != nil {
+		t.Fatalf("deleted key committed as %s", value)
+	}
+	if value, _ := s.GetState("plain"); string(value) != "p1" {
+		t.Fatalf("expected p1 committed, got %s", value)
+	}
+}
+// ============================================================================================================================
+// historyForTest - every Transaction of the customer, failing the test when the history cannot be read
+// ============================================================================================================================
+func historyForTest(t *testing.T, s *testStub, customerId string, args ...string) TransactionPage {
+	t.Helper()
+	res := TransactionPage{}
+	err := json.Unmarshal(mustSucceed(t, s.invoke("getActivityHistory", append([]string{customerId}, args...)...)), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRetriesReplayTheFirstResult(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	transactionKey, err := getRecordKey(s, TransactionObjectType, "legacy1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.put(transactionKey, `{"transactionId":"legacy1","customerId":"c1"}`)
+	// the CustomerOnBoarding Transaction createCustomer wrote is the first in the history
+	tests := []struct {
+		name string
+		caller string
+		function string
+		args []string
+		code string
+		replays int
+		points Points
+		transactions int
+	}{
+		{"accumulation", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "10.00", "r1", ""}, "", -1, 500, 2},
+		{"accumulation retried", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "10.00", "r1", ""}, "", 0, 500, 2},
+		{"accumulation retried by another client", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "10.00", "r1", "Accumulation"}, ErrAlreadyExists, -1, 500, 2},
+		{"reference reused for another amount", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "20.00", "r1", ""}, ErrAlreadyExists, -1, 500, 2},
+		{"purchase", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r2a", "r2b", ""}, "", -1, 400, 4},
+		{"purchase retried", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r2a", "r2b", ""}, "", 4, 400, 4},
+		{"purchase reusing a reference as its second leg", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r3a", "r2a", ""}, ErrAlreadyExists, -1, 400, 4},
+		{"reference another caller used", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r1", "", ""}, "", -1, 300, 6},
+		{"no reference is never a retry", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "2.00", "", ""}, "", -1, 400, 7},
+		{"no reference again", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "2.00", "", ""}, "", -1, 500, 8},
+		{"transaction stored under the client's id", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "2.00", "legacy1", ""}, ErrAlreadyExists, -1, 500, 8},
+		// a retry is answered before the state it was checked against, even once the customer is closed
+		{"customer closed", "admin", "deleteCustomer", []string{"c1"}, "", -1, 0, 9},
+		{"purchase retried once closed", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r2a", "r2b", ""}, "", 4, 0, 9},
+		{"new purchase once closed", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "r4a", "r4b", ""}, ErrFailedPrecondition, -1, 0, 9},
+	}
+	payloads := make([]string, len(tests))
+	events := make([]string, len(tests))
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := s.expectCode(t, tt.caller, tt.function, tt.args, tt.code)
+			payloads[i], events[i] = string(r.Payload), s.event()
+			if tt.replays >= 0 && (payloads[i] == "" || events[i] == "" || payloads[i] != payloads[tt.replays] || events[i] != events[tt.replays]) {
+				t.Fatalf("expected %s and event %s again, got %s and event %s", payloads[tt.replays], events[tt.replays], payloads[i], events[i])
+			}
+			if holding := holdingForTest(t, s, "c1", "m1"); holding.Points != tt.points {
+				t.Fatalf("expected %d points, got %d", tt.points, holding.Points)
+			}
+			if history := historyForTest(t, s, "c1"); len(history.Transactions) != tt.transactions {
+				t.Fatalf("expected %d transactions, got %d", tt.transactions, len(history.Transactions))
+			}
+		})
+	}
+}
+
+// ============================================================================================================================
+// transactionsForTest - the Transactions an invocation returned, one per leg
+// ============================================================================================================================
+func transactionsForTest(t *testing.T, payload []byte) []Transaction {
+	t.Helper()
+	var transactions []Transaction
+	err := json.Unmarshal(payload, &transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transactions
+}
+
+func TestTransactionsTakeIdsAndTimesFromTheProposal(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	tests := []struct {
+		name string
+		caller string
+		now time.Time
+		function string
+		args []string
+		references []string
+		dateTime string
+	}{
+		{"accumulation", "m1user", testEpoch.Add(time.Hour), "updateCustomerAccumulationSC", []string{"c1", "m1", "1.00", "client-1", ""}, []string{"client-1"}, "2023-11-14T23:13:20Z"},
+		{"purchase in another zone", "c1user", time.Date(2024, 2, 29, 8, 30, 0, 500, time.FixedZone("CET", 3600)), "updateCustomerPurchaseSC", []string{"c1", "m1", "0.05", "client-2", "", ""}, []string{"client-2", ""}, "2024-02-29T07:30:00Z"},
+		{"transfer", "c1user", testEpoch.AddDate(1, 0, 0), "updateCustomerTransferSC", []string{"c1", "c2", "m1", "1", "", "client-3", ""}, []string{"", "client-3"}, "2024-11-14T22:13:20Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = tt.now
+			s.as(tt.caller)
+			transactions := transactionsForTest(t, mustSucceed(t, s.invoke(tt.function, tt.args...)))
+			if len(transactions) != len(tt.references) {
+				t.Fatalf("expected %d legs, got %d", len(tt.references), len(transactions))
+			}
+			for leg, res := range transactions {
+				transactionId := fmt.Sprintf("tx%04d-%d", s.txn, leg)
+				if res.TransactionID != transactionId || res.TransactionDateTime != tt.dateTime || res.ExternalReference != tt.references[leg] {
+					t.Fatalf("expected %s at %s with reference %q, got %+v", transactionId, tt.dateTime, tt.references[leg], res)
+				}
+				if leg > 0 && res.LinkedTransactionID != transactions[0].TransactionID {
+					t.Fatalf("expected leg %d linked to %s, got %q", leg, transactions[0].TransactionID, res.LinkedTransactionID)
+				}
+			}
+		})
+	}
+}
+
+func TestActivityHistoryPages(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	mustSucceed(t, s.invoke("associateCustomer", "c1", "m2", "0", "", "", ""))
+	// one request a day from 2023-11-16 on, each labelled by its legs
+	requests := []struct {
+		labels []string
+		caller string
+		function string
+		args []string
+	}{
+		{[]string{"a1"}, "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "1.00", "", ""}},
+		{[]string{"other"}, "m1user", "updateCustomerAccumulationSC", []string{"c2", "m1", "1.00", "", ""}},
+		{[]string{"a2"}, "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "2.00", "", ""}},
+		{[]string{"b1"}, "m2user", "updateCustomerAccumulationSC", []string{"c1", "m2", "10.00", "", ""}},
+		{[]string{"a3"}, "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "3.00", "", ""}},
+		{[]string{"p0", "p1"}, "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "", "", ""}},
+	}
+	labels := map[string]string{}
+	for day, request := range requests {
+		s.now = time.Date(2023, 11, 16 + day, 12, 0, 0, 0, time.UTC)
+		s.as(request.caller)
+		for leg, res := range transactionsForTest(t, mustSucceed(t, s.invoke(request.function, request.args...))) {
+			labels[res.TransactionID] = request.labels[leg]
+		}
+	}
+
+	tests := []struct {
+		name string
+		pageSize string
+		from string
+		to string
+		transactionType string
+		merchantId string
+		code string
+		pages string
+	}{
+		{"pages of two", "2", "2023-11-16", "", "", "", "", "a1 a2|b1 a3|p0 p1"},
+		{"pages of four", "4", "2023-11-16", "", "", "", "", "a1 a2 b1 a3|p0 p1"},
+		{"one page", "", "2023-11-16", "", "", "", "", "a1 a2 b1 a3 p0 p1"},
+		{"date range", "2", "2023-11-18", "2023-11-20", "", "", "", "a2 b1|a3"},
+		{"to a time", "2", "2023-11-16", "2023-11-19T11:59:59Z", "", "", "", "a1 a2"},
+		{"transaction type", "3", "2023-11-16", "", AccumulationTransactionType, "", "", "a1 a2 b1|a3"},
+		{"merchant", "1", "2023-11-16", "", "", "m2", "", "b1"},
+		{"type and merchant", "1", "2023-11-16", "", PurchaseTransactionType, "m1", "", "p0|p1"},
+		{"nothing in range", "2", "2024-01-01", "", "", "", "", ""},
+		{"zero page size", "0", "", "", "", "", ErrInvalidArgument, ""},
+		{"page size over the limit", strconv.Itoa(MaxHistoryPageSize + 1), "", "", "", "", ErrInvalidArgument, ""},
+		{"not a date", "2", "yesterday", "", "", "", ErrInvalidArgument, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages []string
+			bookmark := ""
+			for {
+				r := s.expectCode(t, "c1user", "getActivityHistory", []string{"c1", tt.pageSize, bookmark, tt.from, tt.to, tt.transactionType, tt.merchantId}, tt.code)
+				if tt.code != "" {
+					return
+				}
+				page := TransactionPage{}
+				err := json.Unmarshal(r.Payload, &page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var pageLabels []string
+				for _, res := range page.Transactions {
+					pageLabels = append(pageLabels, labels[res.TransactionID])
+				}
+				if len(pageLabels) > 0 {
+					pages = append(pages, strings.Join(pageLabels, " "))
+				}
+				if page.Bookmark == "" {
+					break
+				}
+				if len(pages) > 10 || page.Bookmark == bookmark {
+					t.Fatalf("bookmark %q does not advance", page.Bookmark)
+				}
+				bookmark = page.Bookmark
+			}
+			if strings.Join(pages, "|") != tt.pages {
+				t.Fatalf("expected %s, got %s", tt.pages, strings.Join(pages, "|"))
+			}
+		})
+	}
+
+	// a bookmark only continues the history it came from
+	other := historyForTest(t, s, "c2", "1")
+	if other.Bookmark == "" {
+		t.Fatal("expected a bookmark to c2's second page")
+	}
+	s.expectCode(t, "c1user", "getActivityHistory", []string{"c1", "1", other.Bookmark}, ErrInvalidArgument)
+}
+
+func TestMerchantStatementPages(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	mustSucceed(t, s.invoke("createMerchant", "m2", "m2user", "Merchant m1", "food", "red", "2", "0.01", "0", "USD", "2020-01-01"))
+	mustSucceed(t, s.invoke("bindRole", testMspID + "/m2user", RoleMerchant, "m2"))
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c3", "m2", "USD", "0", "0")
+	// one request a day from 2023-11-16 on, m1 is renamed half way and m2 went by m1's old name all along
+	requests := []struct {
+		caller string
+		function string
+		args []string
+	}{
+		{"m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "10.00", "", ""}},
+		{"m1user", "updateCustomerAccumulationSC", []string{"c2", "m1", "4.00", "", ""}},
+		{"m1user", "updateMerchant", []string{"m1", "m1user", "Renamed m1", "food", "red", "2", "0.01", "0", "USD", "2023-11-18"}},
+		{"m2user", "updateCustomerAccumulationSC", []string{"c3", "m2", "8.00", "", ""}},
+		{"c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "", "", ""}},
+		{"c1user", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "1", "", "", ""}},
+	}
+	for day, request := range requests {
+		s.now = time.Date(2023, 11, 16 + day, 12, 0, 0, 0, time.UTC)
+		s.as(request.caller)
+		mustSucceed(t, s.invoke(request.function, request.args...))
+	}
+
+	tests := []struct {
+		name string
+		merchantId string
+		from string
+		to string
+		pageSize string
+		code string
+		pages string
+		totals string
+	}{
+		{"one page", "m1", "2023-11-16", "", "", "", "0 c1+500=500 c2+200=700 c1-100=600 c1-100=500 c2+100=600 600",
+			"Accumulation 2 +700 -0, Purchase 1 +0 -100, Transfer 2 +100 -100"},
+		{"pages of two", "m1", "2023-11-16", "", "2", "", "0 c1+500=500 c2+200=700 700|700 c1-100=600 c1-100=500 500|500 c2+100=600 600",
+			"Accumulation 2 +700 -0, Purchase 1 +0 -100, Transfer 2 +100 -100"},
+		{"date range in pages of one", "m1", "2023-11-18", "2023-11-21", "1", "", "700 c1-100=600 600|600 c1-100=500 500|500 c2+100=600 600",
+			"Purchase 1 +0 -100, Transfer 2 +100 -100"},
+		{"date range", "m1", "2023-11-18", "2023-11-20", "", "", "700 c1-100=600 600",
+			"Purchase 1 +0 -100"},
+		{"the merchant with m1's old name", "m2", "2023-11-16", "", "", "", "0 c3+400=400 400",
+			"Accumulation 1 +400 -0"},
+		{"nothing in range", "m1", "2024-01-01", "", "", "", "600 600", ""},
+		{"unknown merchant", "m9", "", "", "", ErrNotFound, "", ""},
+		{"not a date", "m1", "2023-13-01", "", "", ErrInvalidArgument, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages []string
+			var totals []string
+			bookmark := ""
+			for {
+				r := s.expectCode(t, "admin", "getMerchantStatement", []string{tt.merchantId, tt.from, tt.to, tt.pageSize, bookmark}, tt.code)
+				if tt.code != "" {
+					return
+				}
+				res := MerchantStatement{}
+				err := json.Unmarshal(r.Payload, &res)
+				if err != nil {
+					t.Fatal(err)
+				}
+				page := []string{fmt.Sprintf("%d", res.OpeningBalance)}
+				for _, entry := range res.Entries {
+					amount := fmt.Sprintf("+%d", entry.Credit)
+					if entry.Debit > 0 {
+						amount = fmt.Sprintf("-%d", entry.Debit)
+					}
+					page = append(page, fmt.Sprintf("%s%s=%d", entry.Counterparty, amount, entry.Balance))
+				}
+				page = append(page, fmt.Sprintf("%d", res.ClosingBalance))
+				pages = append(pages, strings.Join(page, " "))
+				totals = nil
+				for transactionType, total := range res.Totals {
+					totals = append(totals, fmt.Sprintf("%s %d +%d -%d", transactionType, total.Count, total.Credit, total.Debit))
+				}
+				sort.Strings(totals)
+				if res.Bookmark == "" {
+					break
+				}
+				if len(pages) > 10 || res.Bookmark == bookmark {
+					t.Fatalf("bookmark %q does not advance", res.Bookmark)
+				}
+				bookmark = res.Bookmark
+			}
+			if strings.Join(pages, "|") != tt.pages {
+				t.Fatalf("expected %s, got %s", tt.pages, strings.Join(pages, "|"))
+			}
+			if strings.Join(totals, ", ") != tt.totals {
+				t.Fatalf("expected last page totals %s, got %s", tt.totals, strings.Join(totals, ", "))
+			}
+		})
+	}
+
+	// a bookmark only continues the statement it came from and carries no balance a client could change
+	res := MerchantStatement{}
+	json.Unmarshal(mustSucceed(t, s.invoke("getMerchantStatement", "m1", "2023-11-16", "", "1", "")), &res)
+	s.expectCode(t, "admin", "getMerchantStatement", []string{"m2", "2023-11-16", "", "1", res.Bookmark}, ErrInvalidArgument)
+	s.expectCode(t, "admin", "getMerchantStatement", []string{"m1", "2023-11-16", "", "1", "99999" + res.Bookmark}, ErrInvalidArgument)
+}
+
+func TestAccountBalanceFromCounters(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "M1", "2", "0.01", "USD")
+	setupMerchant(t, s, "M10", "2", "0.02", "USD")
+	setupCustomer(t, s, "c1", "M1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "M1", "USD", "50", "0.50")
+	setupCustomer(t, s, "c3", "M10", "USD", "30", "0.60")
+	tests := []struct {
+		name string
+		caller string
+		after time.Duration
+		function string
+		args []string
+		balances string
+	}{
+		{"as created", "", 0, "", nil, "M1 15000 150 0, M10 3000 60 0"},
+		{"purchase", "c1user", 0, "updateCustomerPurchaseSC", []string{"c1", "M1", "0.30", "", "", ""}, "M1 12000 120 30, M10 3000 60 0"},
+		{"accumulation", "M1user", 0, "updateCustomerAccumulationSC", []string{"c2", "M1", "10.00", "", ""}, "M1 12500 125 30, M10 3000 60 0"},
+		{"transfer between members", "c1user", 0, "updateCustomerTransferSC", []string{"c1", "c2", "M1", "20", "", "", ""}, "M1 12500 125 30, M10 3000 60 0"},
+		{"new exchange rate", "M1user", 0, "updateMerchantsExchangeRate", []string{"M1", "0.02", "2023-11-15"}, "M1 12500 250 30, M10 3000 60 0"},
+		{"folded before the day closed", "admin", 2 * time.Hour, "foldCounters", nil, "M1 12500 250 30, M10 3000 60 0"},
+		{"accumulation the next day", "M1user", 2 * time.Hour, "updateCustomerAccumulationSC", []string{"c2", "M1", "2.00", "", ""}, "M1 12600 252 30, M10 3000 60 0"},
+		{"folded once the day closed", "admin", 3 * time.Hour, "foldCounters", nil, "M1 12600 252 30, M10 3000 60 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = testEpoch.Add(tt.after)
+			if tt.function != "" {
+				s.as(tt.caller)
+				mustSucceed(t, s.invoke(tt.function, tt.args...))
+			}
+			var balances []string
+			for _, merchantId := range []string{"M1", "M10"} {
+				res := MerchantBalance{}
+				err := json.Unmarshal(mustSucceed(t, s.invoke("getMerchantsAccountBalance", merchantId)), &res)
+				if err != nil {
+					t.Fatal(err)
+				}
+				balances = append(balances, fmt.Sprintf("%s %d %d %d", res.MerchantID, res.PointsLiability, res.LiabilityWorth.Units, res.PurchaseBalance.Units))
+			}
+			if strings.Join(balances, ", ") != tt.balances {
+				t.Fatalf("expected %s, got %s", tt.balances, strings.Join(balances, ", "))
+			}
+		})
+	}
+	var days []string
+	for key := range s.State {
+		if objectType, keyParts, _ := s.SplitCompositeKey(key); objectType == MerchantCountersDeltaObjectType {
+			days = append(days, keyParts[0])
+		}
+	}
+	if strings.Join(days, ",") != "2023-11-15" {
+		t.Fatalf("expected the one delta of the open day left, got deltas of %v", days)
+	}
+}
+// ============================================================================================================================
+// countersForTest - every merchant's counters and the network totals as one line, failing the test when they cannot be read
+// ============================================================================================================================
+func countersForTest(t *testing.T, s *testStub, merchantIds ...string) string {
+	t.Helper()
+	var counters []string
+	for _, merchantId := range merchantIds {
+		res := MerchantCounters{}
+		err := json.Unmarshal(mustSucceed(t, s.invoke("getMerchantCounters", merchantId)), &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters = append(counters, fmt.Sprintf("%s %t %d/%d +%d -%d =%d", merchantId, res.Inactive, res.Customers, res.ActiveMembers, res.PointsIssued, res.PointsRedeemed, res.PointsLiability))
+	}
+	res := NetworkCounters{}
+	err := json.Unmarshal(mustSucceed(t, s.invoke("getOwnersMerchantUserCount")), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters = append(counters, fmt.Sprintf("network %d %d/%d +%d -%d =%d", res.MerchantCount, res.UserCount, res.MemberCount, res.PointsIssued, res.PointsRedeemed, res.PointsLiability))
+	return strings.Join(counters, ", ")
+}
+
+func TestCountersFollowWrites(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c3", "m2", "USD", "50", "0.50")
+	tests := []struct {
+		name string
+		caller string
+		function string
+		args []string
+		code string
+		counters string
+	}{
+		{"as created", "", "", nil, "", "m1 false 2/2 +10000 -0 =10000, m2 false 1/1 +5000 -0 =5000, m3 false 0/0 +0 -0 =0, network 2 3/3 +15000 -0 =15000"},
+		{"associate", "admin", "associateCustomer", []string{"c1", "m2", "10.00", "", "", ""}, "", "m1 false 2/2 +10000 -0 =10000, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 2 3/4 +16000 -0 =16000"},
+		{"accumulation", "m1user", "updateCustomerAccumulationSC", []string{"c2", "m1", "2.00", "", ""}, "", "m1 false 2/2 +10100 -0 =10100, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 2 3/4 +16100 -0 =16100"},
+		{"accumulation typed as a purchase", "m1user", "updateCustomerAccumulationSC", []string{"c2", "m1", "2.00", "", PurchaseTransactionType}, ErrInvalidArgument, "m1 false 2/2 +10100 -0 =10100, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 2 3/4 +16100 -0 =16100"},
+		{"purchase", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.10", "", "", ""}, "", "m1 false 2/2 +10100 -1000 =9100, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 2 3/4 +16100 -1000 =15100"},
+		{"purchase typed as an accumulation", "c1user", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.10", "", "", AccumulationTransactionType}, ErrInvalidArgument, "m1 false 2/2 +10100 -1000 =9100, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 2 3/4 +16100 -1000 =15100"},
+		// the points c2 held are settled as redeemed, it no longer counts as a member or customer
+		{"delete a customer", "admin", "deleteCustomer", []string{"c2"}, "", "m1 false 1/1 +10100 -1100 =9000, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 2 2/3 +16100 -1100 =15000"},
+		{"create a merchant", "admin", "createMerchant", []string{"m3", "m3user", "Merchant m3", "food", "red", "2", "0.01", "0", "USD", "2020-01-01"}, "", "m1 false 1/1 +10100 -1100 =9000, m2 false 1/2 +6000 -0 =6000, m3 false 0/0 +0 -0 =0, network 3 2/3 +16100 -1100 =15000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.function != "" {
+				s.expectCode(t, tt.caller, tt.function, tt.args, tt.code)
+			}
+			s.as("admin")
+			if counters := countersForTest(t, s, "m1", "m2", "m3"); counters != tt.counters {
+				t.Fatalf("expected %s, got %s", tt.counters, counters)
+			}
+		})
+	}
+
+	// rebuildCounters recomputes the same counters from the records, whatever the stored ones say and however it is batched
+	rebuilt := tests[len(tests)-1].counters
+	countersKey, err := getRecordKey(s, MerchantCountersObjectType, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardKey, err := s.CreateCompositeKey(NetworkCountersObjectType, []string{"0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, batchSize := range []string{"1", "2", "7", ""} {
+		t.Run(fmt.Sprintf("rebuild in batches of %q", batchSize), func(t *testing.T) {
+			s.put(countersKey, `{"merchantId":"m1","customers":40,"activeMembers":40,"pointsIssued":1,"pointsRedeemed":2,"pointsLiability":-1}`)
+			s.put(shardKey, `{"merchantCount":9,"userCount":40,"memberCount":40,"pointsIssued":1,"pointsRedeemed":2,"pointsLiability":-1}`)
+			bookmark := ""
+			for batches := 1; ; batches++ {
+				s.as("admin")
+				res := RebuildReport{}
+				err := json.Unmarshal(mustSucceed(t, s.invoke("rebuildCounters", batchSize, bookmark)), &res)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Bookmark == "" {
+					break
+				}
+				if batches > 100 || res.Bookmark == bookmark {
+					t.Fatalf("bookmark %q does not advance", res.Bookmark)
+				}
+				bookmark = res.Bookmark
+			}
+			s.as("admin")
+			if counters := countersForTest(t, s, "m1", "m2", "m3"); counters != rebuilt {
+				t.Fatalf("expected %s, got %s", rebuilt, counters)
+			}
+		})
+	}
+	s.expectCode(t, "m1user", "rebuildCounters", nil, ErrPermissionDenied)
+	s.expectCode(t, "admin", "rebuildCounters", []string{"1", "nophase"}, ErrInvalidArgument)	// a bookmark of no phase
+}
+
+func TestDeleteCustomerClosesIt(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	setupCustomer(t, s, "c2", "m1", "USD", "10", "0.10")
+	mustSucceed(t, s.invoke("associateCustomer", "c1", "m2", "5.00", "", "", ""))
+	s.now = testEpoch.Add(24 * time.Hour)
+	s.as("c1user")
+	mustSucceed(t, s.invoke("deleteCustomer", "c1", "moving away"))
+
+	res := getCustomerForTest(t, s, "c1")
+	if res.Status != StatusClosed || res.StatusReason != "moving away" || res.StatusDateTime != "2023-11-15T22:13:20Z" {
+		t.Fatalf("unexpected status %q %q %q", res.Status, res.StatusReason, res.StatusDateTime)
+	}
+	for _, holding := range res.Holdings {
+		if holding.Points != 0 || holding.Worth.Units != 0 {
+			t.Fatalf("expected %s settled, got %+v", holding.MerchantID, holding)
+		}
+	}
+	if worth := walletWorthForTest(t, s, "c1"); worth != 0 {
+		t.Fatalf("expected nothing left in the wallet, got %d", worth)
+	}
+	var settled []string
+	for _, res_trans := range historyForTest(t, s, "c1", "", "", "2023-11-15").Transactions {
+		settled = append(settled, fmt.Sprintf("%s %s -%d", res_trans.MerchantID, res_trans.TransactionType, res_trans.Debit))
+	}
+	if strings.Join(settled, ", ") != "m1 " + CashOutTransactionType + " -10000, m2 " + CashOutTransactionType + " -500" {
+		t.Fatalf("unexpected settlements %v", settled)
+	}
+
+	tests := []invokeCase{
+		{"read", "admin", "getCustomerByID", []string{"c1"}, ErrNotFound},
+		{"read including inactive", "admin", "getCustomerByID", []string{"c1", "true"}, ""},
+		{"accumulate", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "1.00", "", ""}, ErrFailedPrecondition},
+		{"transfer to", "c2user", "updateCustomerTransferSC", []string{"c2", "c1", "m1", "1", "", "", ""}, ErrFailedPrecondition},
+		{"associate", "admin", "associateCustomer", []string{"c1", "m2", "0", "", "", ""}, ErrFailedPrecondition},
+		{"delete again", "admin", "deleteCustomer", []string{"c1"}, ErrFailedPrecondition},
+	}
+	runInvokeCases(t, s, tests, nil)
+	for includeInactive, customers := range map[string]string{"false": "c2", "true": "c1,c2"} {
+		if ids := recordIdsForTest(t, s, "getCustomersByMerchantID", "m1", includeInactive); ids != customers {
+			t.Fatalf("expected %s with includeInactive %s, got %s", customers, includeInactive, ids)
+		}
+	}
+}
+
+func TestDeleteMerchantInBatches(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "2", "0.01", "USD")
+	for i, points := range []string{"10", "20", "0", "30", "40"} {
+		setupCustomer(t, s, fmt.Sprintf("c%d", i + 1), "m1", "USD", points, points)
+	}
+	setupCustomer(t, s, "c9", "m2", "USD", "5", "0")
+	s.now = testEpoch.Add(time.Hour)
+	tests := []struct {
+		name string
+		policy string
+		bookmark string
+		code string
+		customers int
+		transactions int
+		settled Points
+		next string
+	}{
+		{"first batch", DeactivationCashOut, "", "", 2, 2, 3000, "c2"},
+		{"continued with another policy", DeactivationExpire, "c2", ErrInvalidArgument, 0, 0, 0, ""},
+		{"second batch", DeactivationCashOut, "c2", "", 2, 1, 3000, "c4"},
+		{"last batch", DeactivationCashOut, "c4", "", 1, 1, 4000, ""},
+		{"deleted again", DeactivationCashOut, "", ErrFailedPrecondition, 0, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := s.expectCode(t, "admin", "deleteMerchant", []string{"m1", tt.policy, "closing down", "2", tt.bookmark}, tt.code)
+			if tt.code != "" {
+				return
+			}
+			res := DeactivationReport{}
+			err := json.Unmarshal(r.Payload, &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Customers != tt.customers || res.Transactions != tt.transactions || res.Settled != tt.settled || res.Bookmark != tt.next {
+				t.Fatalf("unexpected report %+v", res)
+			}
+		})
+	}
+
+	res_Merchant := Merchant{}
+	json.Unmarshal(mustSucceed(t, s.invoke("getMerchantByID", "m1", "true")), &res_Merchant)
+	if res_Merchant.Status != StatusDeactivated || res_Merchant.StatusReason != "closing down" || res_Merchant.StatusDateTime != "2023-11-14T23:13:20Z" || res_Merchant.DeactivationPolicy != DeactivationCashOut {
+		t.Fatalf("unexpected status %q %q %q %q", res_Merchant.Status, res_Merchant.StatusReason, res_Merchant.StatusDateTime, res_Merchant.DeactivationPolicy)
+	}
+	for i := 1; i <= 5; i++ {
+		if holding := holdingForTest(t, s, fmt.Sprintf("c%d", i), "m1"); holding.Points != 0 {
+			t.Fatalf("expected c%d's points cashed out, got %d", i, holding.Points)
+		}
+		if worth := walletWorthForTest(t, s, fmt.Sprintf("c%d", i)); worth != 0 {
+			t.Fatalf("expected c%d's wallet emptied, got %d", i, worth)
+		}
+	}
+	if holding := holdingForTest(t, s, "c9", "m2"); holding.Points != 500 {
+		t.Fatalf("expected c9's points with m2 kept, got %d", holding.Points)
+	}
+	checks := []invokeCase{
+		{"read", "admin", "getMerchantByID", []string{"m1"}, ErrNotFound},
+		{"accumulate", "m1user", "updateCustomerAccumulationSC", []string{"c1", "m1", "1.00", "", ""}, ErrFailedPrecondition},
+		{"change PPDS", "m1user", "updateMerchantsPPDS", []string{"m1", "3", "2023-11-15"}, ErrFailedPrecondition},
+		{"associate", "admin", "associateCustomer", []string{"c9", "m1", "0", "", "", ""}, ErrFailedPrecondition},
+		{"other merchant", "m2user", "updateCustomerAccumulationSC", []string{"c9", "m2", "1.00", "", ""}, ""},
+	}
+	runInvokeCases(t, s, checks, nil)
+	s.as("admin")
+	if ids := recordIdsForTest(t, s, "getAllMerchants"); ids != "m2" {
+		t.Fatalf("expected only m2 listed, got %s", ids)
+	}
+}
+
+func TestDisassociateRemovesHoldings(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "2", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "100", "1.00")
+	mustSucceed(t, s.invoke("associateCustomer", "c1", "m2", "5.00", "", "", ""))
+	// forfeited points leave the liability without being redeemed
+	tests := []struct {
+		name string
+		caller string
+		args []string
+		code string
+		legs string
+		holdings string
+		counters string
+	}{
+		{"forfeit", "c1user", []string{"c1", "m2", DeactivationExpire, "d1"}, "", "Expiry -500, Offboarding -0", "m1",
+			"m1 false 1/1 +10000 -0 =10000, m2 false 0/0 +500 -0 =0, network 2 1/1 +10500 -0 =10000"},
+		{"retried", "c1user", []string{"c1", "m2", DeactivationExpire, "d1"}, "", "Expiry -500, Offboarding -0", "m1",
+			"m1 false 1/1 +10000 -0 =10000, m2 false 0/0 +500 -0 =0, network 2 1/1 +10500 -0 =10000"},
+		{"not a member any more", "c1user", []string{"c1", "m2", DeactivationExpire, "d2"}, ErrNotFound, "", "m1",
+			"m1 false 1/1 +10000 -0 =10000, m2 false 0/0 +500 -0 =0, network 2 1/1 +10500 -0 =10000"},
+		{"unknown policy", "c1user", []string{"c1", "m1", "refund", "d3"}, ErrInvalidArgument, "", "m1",
+			"m1 false 1/1 +10000 -0 =10000, m2 false 0/0 +500 -0 =0, network 2 1/1 +10500 -0 =10000"},
+		{"last merchant", "m1user", []string{"c1", "m1", DeactivationCashOut, "d4"}, ErrFailedPrecondition, "", "m1",
+			"m1 false 1/1 +10000 -0 =10000, m2 false 0/0 +500 -0 =0, network 2 1/1 +10500 -0 =10000"},
+		{"last merchant allowed", "m1user", []string{"c1", "m1", DeactivationCashOut, "d5", "true"}, "", "CashOut -10000, Offboarding -0", "",
+			"m1 false 0/0 +10000 -10000 =0, m2 false 0/0 +500 -0 =0, network 2 0/0 +10500 -10000 =0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := s.expectCode(t, tt.caller, "disassociateCustomer", tt.args, tt.code)
+			var legs []string
+			if tt.code == "" {
+				for _, res_trans := range transactionsForTest(t, r.Payload) {
+					legs = append(legs, fmt.Sprintf("%s -%d", res_trans.TransactionType, res_trans.Debit))
+				}
+			}
+			if strings.Join(legs, ", ") != tt.legs {
+				t.Fatalf("expected %s, got %v", tt.legs, legs)
+			}
+			if merchantIds := holdingIdsForTest(t, s, "c1"); merchantIds != tt.holdings {
+				t.Fatalf("expected holdings with %s, got %s", tt.holdings, merchantIds)
+			}
+			walletWorthForTest(t, s, "c1")
+			s.as("admin")
+			if counters := countersForTest(t, s, "m1", "m2"); counters != tt.counters {
+				t.Fatalf("expected %s, got %s", tt.counters, counters)
+			}
+		})
+	}
+	if ids := recordIdsForTest(t, s, "getCustomersByMerchantID", "m2"); ids != "" {
+		t.Fatalf("expected no customers of m2, got %s", ids)
+	}
+}
+
+// ============================================================================================================================
+// onDay - noon UTC on a "2006-01-02" date, for proposals a test dates by the day
+// ============================================================================================================================
+func onDay(t *testing.T, date string) time.Time {
+	t.Helper()
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return day.Add(12 * time.Hour)
+}
+
+func TestExpirePointsByPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		policy string
+		months string
+		earned []string									// "<date> <amountSpent>"
+		redeemed string									// "<date> <purchaseAmount>"
+		asOf string
+		expired Points
+		points Points
+	}{
+		{"months, none due", ExpiryPolicyMonths, "2", []string{"2024-01-10 10.00", "2024-02-10 5.00"}, "2024-02-20 4.00", "2024-03-09", 0, 1100},
+		{"months, oldest lot after redemption", ExpiryPolicyMonths, "2", []string{"2024-01-10 10.00", "2024-02-10 5.00"}, "2024-02-20 4.00", "2024-03-11", 600, 500},
+		{"months, every lot", ExpiryPolicyMonths, "2", []string{"2024-01-10 10.00", "2024-02-10 5.00"}, "2024-02-20 4.00", "2024-04-11", 1100, 0},
+		{"year end, last year's lot", ExpiryPolicyYearEnd, "", []string{"2023-12-01 10.00", "2024-01-05 5.00"}, "", "2024-01-06", 1000, 500},
+		{"year end, during the year", ExpiryPolicyYearEnd, "", []string{"2023-12-01 10.00", "2023-12-05 5.00"}, "", "2023-12-31", 0, 1500},
+		{"inactivity, redemption is activity", ExpiryPolicyInactivity, "1", []string{"2024-01-10 10.00"}, "2024-02-01 4.00", "2024-02-29", 0, 600},
+		{"inactivity, every lot", ExpiryPolicyInactivity, "1", []string{"2024-01-10 10.00"}, "2024-02-01 4.00", "2024-03-02", 600, 0},
+		{"never", ExpiryPolicyNone, "", []string{"2024-01-10 10.00"}, "", "2030-01-01", 0, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStub(t)
+			setupMerchant(t, s, "m1", "1", "1", "USD")
+			setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+			s.as("m1user")
+			mustSucceed(t, s.invoke("updateMerchantsExpiryPolicy", "m1", tt.policy, tt.months, "2023-11-15"))
+			for _, earning := range tt.earned {
+				fields := strings.Fields(earning)
+				s.now = onDay(t, fields[0])
+				mustSucceed(t, s.invoke("updateCustomerAccumulationSC", "c1", "m1", fields[1], "", ""))
+			}
+			if tt.redeemed != "" {
+				fields := strings.Fields(tt.redeemed)
+				s.now = onDay(t, fields[0])
+				mustSucceed(t, s.invoke("updateCustomerPurchaseSC", "c1", "m1", fields[1], "", "", ""))
+			}
+			s.now = onDay(t, tt.asOf)
+			res := ExpiryReport{}
+			err := json.Unmarshal(mustSucceed(t, s.invoke("expirePoints", "m1")), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Expired != tt.expired {
+				t.Fatalf("expected %d expired, got %d", tt.expired, res.Expired)
+			}
+			holding := holdingForTest(t, s, "c1", "m1")
+			var lots Points
+			for _, lot := range holding.Lots {
+				lots += lot.Points
+			}
+			if holding.Points != tt.points || lots != tt.points {
+				t.Fatalf("expected %d points left, got %d in lots of %d", tt.points, holding.Points, lots)
+			}
+			walletWorthForTest(t, s, "c1")
+			// expiring again as of the same time finds nothing left to expire
+			err = json.Unmarshal(mustSucceed(t, s.invoke("expirePoints", "m1")), &res)
+			if err != nil || res.Expired != 0 {
+				t.Fatalf("expected nothing expired again, got %d %v", res.Expired, err)
+			}
+		})
+	}
+}
+
+func TestExpirePointsInBatches(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "1", "1", "USD")
+	s.as("m1user")
+	mustSucceed(t, s.invoke("updateMerchantsExpiryPolicy", "m1", ExpiryPolicyMonths, "1", "2023-11-15"))
+	for i, amountSpent := range []string{"1.00", "0", "3.00"} {
+		customerId := fmt.Sprintf("c%d", i + 1)
+		setupCustomer(t, s, customerId, "m1", "USD", "0", "0")
+		if amountSpent != "0" {
+			s.as("m1user")
+			mustSucceed(t, s.invoke("updateCustomerAccumulationSC", customerId, "m1", amountSpent, "", ""))
+		}
+	}
+	s.now = testEpoch.AddDate(0, 2, 0)
+	tests := []struct {
+		name string
+		caller string
+		bookmark string
+		code string
+		customers int
+		transactions int
+		expired Points
+		next string
+	}{
+		{"an identity without a role", "m2user", "", ErrPermissionDenied, 0, 0, 0, ""},
+		{"first batch", "m1user", "", "", 2, 1, 100, "c2"},
+		{"last batch", "admin", "c2", "", 1, 1, 300, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := s.expectCode(t, tt.caller, "expirePoints", []string{"m1", "2", tt.bookmark}, tt.code)
+			if tt.code != "" {
+				return
+			}
+			res := ExpiryReport{}
+			err := json.Unmarshal(r.Payload, &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Customers != tt.customers || res.Transactions != tt.transactions || res.Expired != tt.expired || res.Bookmark != tt.next {
+				t.Fatalf("unexpected report %+v", res)
+			}
+		})
+	}
+	history := historyForTest(t, s, "c3", "", "", "", "", ExpiryTransactionType)
+	if len(history.Transactions) != 1 || history.Transactions[0].Debit != 300 || history.Transactions[0].TransactionDateTime != "2024-01-14T22:13:20Z" {
+		t.Fatalf("expected one Expiry of 300 at the proposal timestamp, got %+v", history.Transactions)
+	}
+	s.as("admin")
+	if counters := countersForTest(t, s, "m1"); counters != "m1 false 3/3 +400 -0 =0, network 1 3/3 +400 -0 =0" {
+		t.Fatalf("unexpected counters %s", counters)
+	}
+}
+
+// ============================================================================================================================
+// customerTierForTest - the tier getCustomerTier reports for a customer's holding with a merchant
+// ============================================================================================================================
+func customerTierForTest(t *testing.T, s *testStub, customerId string, merchantId string) CustomerTier {
+	t.Helper()
+	res := CustomerTier{}
+	err := json.Unmarshal(mustSucceed(t, s.invoke("getCustomerTier", customerId, merchantId)), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTiersMultiplyAccruals(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "1", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	s.as("m1user")
+	mustSucceed(t, s.invoke("updateMerchantsTiers", "m1", TierBasisPoints, "2", "Silver,Gold", "10,30", "1.5,2"))
+	tests := []struct {
+		name string
+		date string
+		amountSpent string
+		legs string
+		points Points
+		tier string
+	}{
+		{"below every tier", "2024-01-10", "5.00", "Accumulation +500", 500, " 500 next Silver 1000"},
+		{"reaches silver", "2024-01-20", "5.00", "Accumulation +750, TierChange ->Silver", 1250, "Silver 1000 next Gold 3000"},
+		{"reaches gold", "2024-02-05", "20.00", "Accumulation +4000, TierChange Silver->Gold", 5250, "Gold 3000"},
+		{"stays gold", "2024-02-06", "1.00", "Accumulation +200", 5450, "Gold 3100"},
+		// the two months back from April 1st still hold February 5th and 6th, not January
+		{"window moved on", "2024-04-01", "1.00", "Accumulation +150, TierChange Gold->Silver", 5600, "Silver 2200 next Gold 3000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = onDay(t, tt.date)
+			s.as("m1user")
+			var legs []string
+			for _, res_trans := range transactionsForTest(t, mustSucceed(t, s.invoke("updateCustomerAccumulationSC", "c1", "m1", tt.amountSpent, "", ""))) {
+				if res_trans.TransactionType == TierChangeTransactionType {
+					legs = append(legs, fmt.Sprintf("%s %s->%s", res_trans.TransactionType, res_trans.TransactionFrom, res_trans.TransactionTo))
+				} else {
+					legs = append(legs, fmt.Sprintf("%s +%d", res_trans.TransactionType, res_trans.Credit))
+				}
+			}
+			if strings.Join(legs, ", ") != tt.legs {
+				t.Fatalf("expected %s, got %s", tt.legs, strings.Join(legs, ", "))
+			}
+			if holding := holdingForTest(t, s, "c1", "m1"); holding.Points != tt.points {
+				t.Fatalf("expected %d points, got %d", tt.points, holding.Points)
+			}
+			res := customerTierForTest(t, s, "c1", "m1")
+			tier := fmt.Sprintf("%s %d", res.Tier, res.Points)
+			if res.NextTier != "" {
+				tier += fmt.Sprintf(" next %s %d", res.NextTier, res.NextThreshold)
+			}
+			if tier != tt.tier {
+				t.Fatalf("expected tier %q, got %q", tt.tier, tier)
+			}
+		})
+	}
+
+	// with no accumulation since, February left the window by April 7th, a read sees it and expirePoints records it
+	s.now = onDay(t, "2024-04-07")
+	res := customerTierForTest(t, s, "c1", "m1")
+	if tier := fmt.Sprintf("%s %d %d next %s", res.Tier, res.Multiplier, res.Points, res.NextTier); tier != " 1000000 100 next Silver" {
+		t.Fatalf("expected no tier on read, got %q", tier)
+	}
+	for _, expected := range []int{1, 0} {
+		report := ExpiryReport{}
+		json.Unmarshal(mustSucceed(t, s.invoke("expirePoints", "m1")), &report)
+		if report.TierChanges != expected || report.Transactions != 0 {
+			t.Fatalf("expected %d tier changes, got %+v", expected, report)
+		}
+	}
+	if holding := holdingForTest(t, s, "c1", "m1"); holding.Tier != "" || len(holding.Earnings) != 1 {
+		t.Fatalf("expected the holding to drop its tier and keep one day of earnings, got %q %+v", holding.Tier, holding.Earnings)
+	}
+	history := historyForTest(t, s, "c1", "", "", "", "", TierChangeTransactionType)
+	if last := history.Transactions[len(history.Transactions)-1]; last.TransactionFrom != "Silver" || last.TransactionTo != "" || last.TransactionDateTime != "2024-04-07T12:00:00Z" {
+		t.Fatalf("expected the latest TierChange from Silver on April 7th, got %+v", last)
+	}
+}
+
+func TestTiersOnSpend(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "7", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	s.as("m1user")
+	mustSucceed(t, s.invoke("updateMerchantsTiers", "m1", TierBasisSpend, "12", "Silver", "50.00", "2"))
+	// a point for every seven dollars, the amount spent and not the points qualifies
+	tests := []struct {
+		name string
+		amountSpent string
+		points Points
+		tier string
+		spend int64
+	}{
+		{"below silver", "35.00", 500, "", 3500},
+		{"reaches silver", "35.00", 1500, "Silver", 7000},
+		{"less than a point", "6.99", 1698, "Silver", 7699},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.as("m1user")
+			mustSucceed(t, s.invoke("updateCustomerAccumulationSC", "c1", "m1", tt.amountSpent, "", ""))
+			res := customerTierForTest(t, s, "c1", "m1")
+			if holding := holdingForTest(t, s, "c1", "m1"); holding.Points != tt.points || res.Tier != tt.tier || res.Spend.Units != tt.spend {
+				t.Fatalf("expected %d points in tier %q on %d spent, got %d in %q on %d", tt.points, tt.tier, tt.spend, holding.Points, res.Tier, res.Spend.Units)
+			}
+		})
+	}
+}
+
+func TestCampaignsCreditBonuses(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "1", "0.01", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	setupCustomer(t, s, "c2", "m1", "USD", "0", "0")
+	s.as("m1user")
+	mustSucceed(t, s.invoke("updateMerchantsTiers", "m1", TierBasisPoints, "12", "Gold", "50", "1"))
+	mustSucceed(t, s.invoke("createCampaign", "weekend", "m1", "Double points this weekend", "2024-03-02", "2024-03-03", "", "", "", CampaignBonusMultiplier, "2"))
+	mustSucceed(t, s.invoke("createCampaign", "welcome", "m1", "+500 points on a first purchase over $50", "2024-01-01", "2024-12-31", "50.00", "", "true", CampaignBonusFlat, "500"))
+	mustSucceed(t, s.invoke("createCampaign", "gold", "m1", "A point more for gold members", "2024-01-01", "2024-12-31", "", "Gold", "", CampaignBonusFlat, "1"))
+	tests := []struct {
+		name string
+		date string
+		function string
+		args []string
+		code string
+		legs string
+	}{
+		{"first purchase under the minimum", "2024-03-01", "updateCustomerAccumulationSC", []string{"c1", "m1", "20.00", "", ""}, "", "Accumulation +2000"},
+		{"weekend, reaching gold", "2024-03-02", "updateCustomerAccumulationSC", []string{"c1", "m1", "40.00", "", ""}, "",
+			"Accumulation +4000, TierChange +0, CampaignBonus gold +100, CampaignBonus weekend +4000"},
+		{"first purchase over the minimum", "2024-03-04", "updateCustomerAccumulationSC", []string{"c2", "m1", "60.00", "", ""}, "",
+			"Accumulation +6000, TierChange +0, CampaignBonus gold +100, CampaignBonus welcome +50000"},
+		{"second purchase over the minimum", "2024-03-04", "updateCustomerAccumulationSC", []string{"c2", "m1", "60.00", "", ""}, "",
+			"Accumulation +6000, CampaignBonus gold +100"},
+		{"gold ended", "2024-03-05", "endCampaign", []string{"gold"}, "", ""},
+		{"after gold ended", "2024-03-06", "updateCustomerAccumulationSC", []string{"c2", "m1", "1.00", "", ""}, "", "Accumulation +100"},
+		{"end an ended campaign", "2024-03-06", "endCampaign", []string{"gold"}, ErrFailedPrecondition, ""},
+		{"unknown tier", "2024-03-06", "createCampaign", []string{"platinum", "m1", "Platinum", "2024-01-01", "2024-12-31", "", "Platinum", "", CampaignBonusFlat, "1"}, ErrInvalidArgument, ""},
+		{"multiplier of one", "2024-03-06", "createCampaign", []string{"same", "m1", "Same", "2024-01-01", "2024-12-31", "", "", "", CampaignBonusMultiplier, "1"}, ErrInvalidArgument, ""},
+		{"ends before it starts", "2024-03-06", "createCampaign", []string{"late", "m1", "Late", "2024-02-01", "2024-01-01", "", "", "", CampaignBonusFlat, "1"}, ErrInvalidArgument, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = onDay(t, tt.date)
+			r := s.expectCode(t, "m1user", tt.function, tt.args, tt.code)
+			var transactions []Transaction
+			if tt.legs != "" {
+				transactions = transactionsForTest(t, r.Payload)
+			}
+			var legs []string
+			for _, res_trans := range transactions {
+				leg := strings.TrimSpace(fmt.Sprintf("%s %s", res_trans.TransactionType, res_trans.CampaignID))
+				legs = append(legs, fmt.Sprintf("%s +%d", leg, res_trans.Credit))
+				if res_trans.TransactionType == CampaignBonusTransactionType && res_trans.LinkedTransactionID != transactions[0].TransactionID {
+					t.Fatalf("expected the bonus linked to %s, got %q", transactions[0].TransactionID, res_trans.LinkedTransactionID)
+				}
+			}
+			if strings.Join(legs, ", ") != tt.legs {
+				t.Fatalf("expected %s, got %s", tt.legs, strings.Join(legs, ", "))
+			}
+		})
+	}
+
+	var reports []CampaignReport
+	json.Unmarshal(mustSucceed(t, s.invoke("getCampaignsByMerchantID", "m1")), &reports)
+	var costs []string
+	for _, report := range reports {
+		costs = append(costs, fmt.Sprintf("%s %d +%d $%d", report.Campaign.CampaignID, report.Bonuses, report.BonusPoints, report.BonusWorth.Units))
+	}
+	sort.Strings(costs)
+	if strings.Join(costs, ", ") != "gold 3 +300 $3, weekend 1 +4000 $40, welcome 1 +50000 $500" {
+		t.Fatalf("unexpected campaign costs %v", costs)
+	}
+}
+
+func TestExchangePointsWithinCaps(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "1", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.02", "USD")
+	setupCustomer(t, s, "c1", "m1", "USD", "1000", "10.00")
+	mustSucceed(t, s.invoke("associateCustomer", "c1", "m2", "0", "a1", "", ""))
+	mustSucceed(t, s.invoke("updateExchangeFee", "0.02"))
+	s.as("m1user")
+	mustSucceed(t, s.invoke("updateMerchantsExchangeSettings", "m1", "true", "5.00", "2024-03-01"))
+	tests := []struct {
+		name string
+		date string
+		caller string
+		function string
+		args []string
+		code string
+		legs string
+	}{
+		{"fee by a merchant", "2024-03-01", "m1user", "updateExchangeFee", []string{"0.5"}, ErrPermissionDenied, ""},
+		{"to a merchant not opted in", "2024-03-01", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "100", "x1"}, ErrFailedPrecondition, ""},
+		{"m2 opts in", "2024-03-01", "m2user", "updateMerchantsExchangeSettings", []string{"m2", "true", "", "2024-03-01"}, "", ""},
+		{"to the same merchant", "2024-03-01", "c1user", "exchangePoints", []string{"c1", "m1", "m1", "100", "x2"}, ErrInvalidArgument, ""},
+		{"more points than held", "2024-03-01", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "1000.01", "x3"}, ErrInsufficientPoints, ""},
+		// $1.00 less the 2% fee buys 49 points at $0.02, the fee is paid in 2 of m1's points
+		{"within the cap", "2024-03-01", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "100", "x4"}, "",
+			"Exchange m1 +0 -9800 98, Exchange m2 +4900 -0 98, ExchangeFee m1 +0 -200 2"},
+		{"over m1's daily cap out", "2024-03-01", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "401", "x5"}, ErrLimitExceeded, ""},
+		{"up to m1's daily cap out", "2024-03-01", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "400", "x6"}, "",
+			"Exchange m1 +0 -39200 392, Exchange m2 +19600 -0 392, ExchangeFee m1 +0 -800 8"},
+		{"the next day", "2024-03-02", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "1", "x7"}, "",
+			"Exchange m1 +0 -100 1, Exchange m2 +50 -0 1, ExchangeFee m1 +0 -0 0"},
+		{"m2 caps what comes in", "2024-03-02", "m2user", "updateMerchantsExchangeSettings", []string{"m2", "true", "1.00", "2024-03-02"}, "", ""},
+		{"over m2's daily cap in", "2024-03-02", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "200", "x8"}, ErrLimitExceeded, ""},
+		{"m2 opts out", "2024-03-02", "m2user", "updateMerchantsExchangeSettings", []string{"m2", "false", "", "2024-03-02"}, "", ""},
+		{"to a merchant opted out", "2024-03-02", "c1user", "exchangePoints", []string{"c1", "m1", "m2", "1", "x9"}, ErrFailedPrecondition, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = onDay(t, tt.date)
+			r := s.expectCode(t, tt.caller, tt.function, tt.args, tt.code)
+			var transactions []Transaction
+			if tt.legs != "" {
+				transactions = transactionsForTest(t, r.Payload)
+			}
+			var legs []string
+			for _, res_trans := range transactions {
+				legs = append(legs, fmt.Sprintf("%s %s +%d -%d %d", res_trans.TransactionType, res_trans.MerchantID, res_trans.Credit, res_trans.Debit, res_trans.Amount.Units))
+			}
+			if strings.Join(legs, ", ") != tt.legs {
+				t.Fatalf("expected %s, got %s", tt.legs, strings.Join(legs, ", "))
+			}
+			// the value debited is the value credited, the points debited with the fee are the points exchanged
+			if len(transactions) != 3 {
+				return
+			}
+			points, _ := parseFixed(tt.args[3], 2)
+			if transactions[0].Amount.Units != transactions[1].Amount.Units || int64(transactions[0].Debit + transactions[2].Debit) != points {
+				t.Fatalf("expected the legs to net to zero, got %s", strings.Join(legs, ", "))
+			}
+		})
+	}
+
+	for merchantId, expected := range map[string]string{"m1": "49900 499", "m2": "24550 491"} {
+		holding := holdingForTest(t, s, "c1", merchantId)
+		if got := fmt.Sprintf("%d %d", holding.Points, holding.Worth.Units); got != expected {
+			t.Fatalf("expected the holding with %s at %s, got %s", merchantId, expected, got)
+		}
+	}
+	walletWorthForTest(t, s, "c1")
+	s.as("admin")
+	res := NetworkAccount{}
+	json.Unmarshal(mustSucceed(t, s.invoke("getNetworkAccount", "USD")), &res)
+	if res.Fees.Units != 10 || res.Exchanges != 3 {
+		t.Fatalf("expected the network paid 10 in 3 fees, got %d in %d", res.Fees.Units, res.Exchanges)
+	}
+}
+
+func TestFxRatesConvertByDate(t *testing.T) {
+	s := newTestStub(t)
+	setupMerchant(t, s, "m1", "1", "0.01", "USD")
+	setupMerchant(t, s, "m2", "1", "0.01", "EUR")
+	setupCustomer(t, s, "c1", "m1", "USD", "0", "0")
+	mustSucceed(t, s.invoke("setFxRate", "EUR", "USD", "1.10", "2024-01-01"))
+	mustSucceed(t, s.invoke("setFxRate", "EUR", "USD", "1.20", "2024-03-01"))
+	mustSucceed(t, s.invoke("setFxRate", "USD", "GBP", "0.80", "2024-02-01"))
+	mustSucceed(t, s.invoke("setFxRate", "USD", "EUR", "0.80", "2024-04-01"))
+	tests := []struct {
+		name string
+		args []string
+		code string
+		rate string
+	}{
+		{"before any rate", []string{"EUR", "USD", "2023-12-31"}, ErrFailedPrecondition, ""},
+		{"the first rate", []string{"EUR", "USD", "2024-02-29"}, "", "1100000 2024-01-01T00:00:00Z"},
+		{"the latest rate", []string{"EUR", "USD", "2024-03-01"}, "", "1200000 2024-03-01T00:00:00Z"},
+		{"the reverse of the latest rate", []string{"USD", "EUR", "2024-03-31"}, "", "833333 2024-03-01T00:00:00Z"},
+		{"a later reverse rate", []string{"EUR", "USD", "2024-04-01"}, "", "1250000 2024-04-01T00:00:00Z"},
+		{"the pair itself on a later reverse rate", []string{"USD", "EUR", "2024-04-01"}, "", "800000 2024-04-01T00:00:00Z"},
+		{"the reverse only", []string{"GBP", "USD", "2024-02-01"}, "", "1250000 2024-02-01T00:00:00Z"},
+		{"a currency in itself", []string{"GBP", "GBP", "2023-12-31"}, "", "1000000 "},
+		{"no rate for the pair", []string{"EUR", "GBP", "2024-04-01"}, ErrFailedPrecondition, ""},
+		{"not a currency", []string{"EURO", "USD", ""}, ErrInvalidArgument, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := s.expectCode(t, "admin", "getFxRate", tt.args, tt.code)
+			if tt.code != "" {
+				return
+			}
+			res := FxRate{}
+			json.Unmarshal(r.Payload, &res)
+			if got := fmt.Sprintf("%d %s", res.Rate, res.EffectiveDateTime); got != tt.rate {
+				t.Fatalf("expected %s, got %s", tt.rate, got)
+			}
+		})
+	}
+
+	s.expectCode(t, "m1user", "setFxRate", []string{"EUR", "USD", "2", "2024-03-01"}, ErrPermissionDenied)
+	// amounts spent in another currency accrue at the rate in effect at the proposal timestamp
+	for _, purchase := range []struct {
+		date string
+		points Points
+	}{{"2024-02-15", 1100}, {"2024-03-15", 1200}} {
+		s.now = onDay(t, purchase.date)
+		before := holdingForTest(t, s, "c1", "m1").Points
+		mustSucceed(t, s.invoke("updateCustomerAccumulationSC", "c1", "m1", "10.00 EUR", "", ""))
+		if holding := holdingForTest(t, s, "c1", "m1"); holding.Points - before != purchase.points {
+			t.Fatalf("expected 10.00 EUR on %s to accrue %d, got %d", purchase.date, purchase.points, holding.Points - before)
+		}
+	}
+	s.as("admin")
+	mustSucceed(t, s.invoke("associateCustomer", "c1", "m2", "5.00", "a1", "", ""))
+
+	wallets := []struct {
+		currency string
+		asOf string
+		code string
+		worth string
+	}{
+		{"USD", "2024-03-15", "", "623 USD: m1 23 USD at 1000000, m2 600 USD at 1200000"},
+		{"EUR", "2024-03-15", "", "519 EUR: m1 19 EUR at 833333, m2 500 EUR at 1000000"},
+		{"USD", "2024-04-01", "", "648 USD: m1 23 USD at 1000000, m2 625 USD at 1250000"},
+		{"GBP", "2024-03-15", ErrFailedPrecondition, ""},
+	}
+	for _, tt := range wallets {
+		r := s.expectCode(t, "admin", "getWalletWorth", []string{"c1", tt.currency, tt.asOf}, tt.code)
+		if tt.code != "" {
+			continue
+		}
+		report := WalletWorth{}
+		json.Unmarshal(r.Payload, &report)
+		var holdings []string
+		for _, holding := range report.Holdings {
+			holdings = append(holdings, fmt.Sprintf("%s %d %s at %d", holding.MerchantID, holding.Converted.Units, holding.Converted.Currency, holding.FxRate))
+		}
+		if got := fmt.Sprintf("%d %s: %s", report.WalletWorth.Units, report.WalletWorth.Currency, strings.Join(holdings, ", ")); got != tt.worth {
+			t.Fatalf("expected %s, got %s", tt.worth, got)
+		}
+	}
+}
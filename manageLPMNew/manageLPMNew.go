@@ -1630,7 +1630,7 @@ func (t *ManageLPM) deleteCustomer(stub shim.ChaincodeStubInterface, args []stri
 			fmt.Println("found Customer with matching customerId")
 			customerIndex = append(customerIndex[:i], customerIndex[i+1:]...)			//remove it
 			for x:= range customerIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + customerIndex[x])
+				fmt.Println(strconv.Itoa(x) + " - " + customerIndex[x])
 			}
 			break
 		}
@@ -2076,7 +2076,7 @@ func (t *ManageLPM) deleteMerchant(stub shim.ChaincodeStubInterface, args []stri
 			fmt.Println("found Merchant with matching merchantId")
 			merchantIndex = append(merchantIndex[:i], merchantIndex[i+1:]...)			//remove it
 			for x:= range merchantIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + merchantIndex[x])
+				fmt.Println(strconv.Itoa(x) + " - " + merchantIndex[x])
 			}
 			break
 		}
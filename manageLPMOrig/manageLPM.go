@@ -20,6 +20,7 @@ package main
 import (
 "errors"
 "fmt"
+"math"
 "strconv"
 "encoding/json"
 "strings"
@@ -38,6 +39,7 @@ var OwnerIndexStr = "_Ownerindex"					//name for the key/value that will store a
 
 var MerchantInitialBalance = "100000.00"
 var StartingBalance = "100.00"
+var maxHundredths = float64(1 << 53)					//largest hundredths value a float64 still holds exactly
 
 type Customer struct{							// Attributes of a Customer 
 	CustomerID string `json:"customerId"`					
@@ -1725,6 +1727,100 @@ func (t *ManageLPM) updateCustomerTransfer(stub shim.ChaincodeStubInterface, arg
 // Write - update customer during accumulation into chaincode state - SmartContracts
 // ============================================================================================================================
 func (t *ManageLPM) updateCustomerAccumulationSC(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Customer - accumulation SC")
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+	customerId := args[0]
+	merchantId := args[1]
+	amountSpent := args[2]
+	transactionId := args[3]
+	transactionDateTime := args[4]
+
+	floatAmountSpent, err := parseFiniteFloat(amountSpent)
+	if err != nil || floatAmountSpent <= 0 {
+		return nil, errors.New("Invalid amount spent " + amountSpent)
+	}
+	err = checkTransactionIdFree(stub, transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	merchantAsBytes, err := stub.GetState(merchantId)
+	if err != nil {
+		return nil, errors.New("Failed to get Merchant merchantID")
+	}
+	res_Merchant := Merchant{}
+	json.Unmarshal(merchantAsBytes, &res_Merchant)
+	if res_Merchant.MerchantID != merchantId{
+		return nil, errors.New(merchantId + " Not Found.")
+	}
+
+	customerAsBytes, err := stub.GetState(customerId)
+	if err != nil {
+		return nil, errors.New("Failed to get Customer customerID")
+	}
+	res := Customer{}
+	json.Unmarshal(customerAsBytes, &res)
+	if res.CustomerID != customerId{
+		return nil, errors.New(customerId + " Not Found.")
+	}
+	merchantIndexForCustomer := getMerchantIndexForCustomer(res, merchantId)
+	if merchantIndexForCustomer < 0 {
+		return nil, errors.New(customerId + " is not associated with " + merchantId)
+	}
+
+	// Calculation - points earned from the merchant's PPDS, worth of those points from the merchant's exchange rate
+	floatPointsPerDollarSpent, err := parseFiniteFloat(res_Merchant.PointsPerDollarSpent)
+	if err != nil || floatPointsPerDollarSpent <= 0 {
+		return nil, errors.New("Invalid pointsPerDollarSpent for merchant " + merchantId)
+	}
+	floatExchangeRate, err := parseFiniteFloat(res_Merchant.ExchangeRate)
+	if err != nil || floatExchangeRate <= 0 {
+		return nil, errors.New("Invalid exchangeRate for merchant " + merchantId)
+	}
+	var worthToBeCredited int64
+	pointsToBeCredited, err := toHundredths(floatAmountSpent * floatPointsPerDollarSpent)
+	if err == nil {
+		worthToBeCredited, err = toHundredths(float64(pointsToBeCredited) / 100 * floatExchangeRate)
+	}
+	if err != nil {
+		return nil, errors.New("Amount spent " + amountSpent + " is too large")
+	}
+	fmt.Println("pointsToBeCredited in updateCustomerAccumulationSC::" + formatHundredths(pointsToBeCredited))
+	fmt.Println("worthToBeCredited in updateCustomerAccumulationSC::" + formatHundredths(worthToBeCredited))
+
+	err = updateCustomerPointsAtIndex(&res, merchantIndexForCustomer, pointsToBeCredited, worthToBeCredited)
+	if err != nil {
+		return nil, err
+	}
+
+	res_trans := Transaction{}
+	res_trans.TransactionID = transactionId
+	res_trans.TransactionDateTime = transactionDateTime
+	res_trans.TransactionType = "Accumulation"
+	res_trans.TransactionFrom = res_Merchant.MerchantName
+	res_trans.TransactionTo = res.UserName
+	res_trans.Credit = formatHundredths(worthToBeCredited)
+	res_trans.Debit = "0.00"
+	res_trans.CustomerID = customerId
+
+	err = putCustomer(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans)
+	if err != nil {
+		return nil, err
+	}
+
+	tosend := "{ \"customerID\" : \""+customerId+"\", \"pointsCredited\" : \""+formatHundredths(pointsToBeCredited)+"\", \"message\" : \"Customer details updated succcessfully\", \"code\" : \"200\"}"
+	err = stub.SetEvent("evtsender", []byte(tosend))
+	if err != nil {
+		return nil, err
+	}
+
 	fmt.Println("Customer details updated succcessfully")
 	return nil, nil
 }
@@ -1732,6 +1828,137 @@ func (t *ManageLPM) updateCustomerAccumulationSC(stub shim.ChaincodeStubInterfac
 // Write - update customer during redemption into chaincode state - SmartContracts
 // ============================================================================================================================
 func (t *ManageLPM) updateCustomerPurchaseSC(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("Updating Customer - purchase SC")
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 6")
+	}
+	customerId := args[0]
+	merchantId := args[1]
+	purchaseAmount := args[2]
+	transactionId1 := args[3]
+	transactionId2 := args[4]
+	transactionDateTime := args[5]
+
+	var purchaseCents int64
+	floatPurchaseAmount, err := parseFiniteFloat(purchaseAmount)
+	if err == nil {
+		purchaseCents, err = toHundredths(floatPurchaseAmount)
+	}
+	if err != nil || purchaseCents <= 0 {
+		return nil, errors.New("Invalid purchase amount " + purchaseAmount)
+	}
+	if transactionId1 == transactionId2 {
+		return nil, errors.New("Transaction ids for the customer and merchant legs must differ")
+	}
+	for _, transactionId := range []string{transactionId1, transactionId2} {
+		err = checkTransactionIdFree(stub, transactionId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	merchantAsBytes, err := stub.GetState(merchantId)
+	if err != nil {
+		return nil, errors.New("Failed to get Merchant merchantID")
+	}
+	res_Merchant := Merchant{}
+	json.Unmarshal(merchantAsBytes, &res_Merchant)
+	if res_Merchant.MerchantID != merchantId{
+		return nil, errors.New(merchantId + " Not Found.")
+	}
+
+	customerAsBytes, err := stub.GetState(customerId)
+	if err != nil {
+		return nil, errors.New("Failed to get Customer customerID")
+	}
+	res := Customer{}
+	json.Unmarshal(customerAsBytes, &res)
+	if res.CustomerID != customerId{
+		return nil, errors.New(customerId + " Not Found.")
+	}
+	merchantIndexForCustomer := getMerchantIndexForCustomer(res, merchantId)
+	if merchantIndexForCustomer < 0 {
+		return nil, errors.New(customerId + " is not associated with " + merchantId)
+	}
+
+	// Calculation - points needed to pay the purchase amount at the merchant's exchange rate
+	floatExchangeRate, err := parseFiniteFloat(res_Merchant.ExchangeRate)
+	if err != nil || floatExchangeRate <= 0 {
+		return nil, errors.New("Invalid exchangeRate for merchant " + merchantId)
+	}
+	pointsAvailable, err := getCustomerPointsAtIndex(res, merchantIndexForCustomer)
+	if err != nil {
+		return nil, err
+	}
+	pointsToBeDebited, err := toHundredths(float64(purchaseCents) / 100 / floatExchangeRate)
+	if err != nil || pointsToBeDebited > pointsAvailable {
+		return nil, errors.New("Insufficient points for " + customerId + " at " + merchantId)
+	}
+
+	fmt.Println("pointsToBeDebited in updateCustomerPurchaseSC::" + formatHundredths(pointsToBeDebited))
+	fmt.Println("pointsAvailable in updateCustomerPurchaseSC::" + formatHundredths(pointsAvailable))
+
+	err = updateCustomerPointsAtIndex(&res, merchantIndexForCustomer, -pointsToBeDebited, -purchaseCents)
+	if err != nil {
+		return nil, err
+	}
+
+	floatPurchaseBalance, err := parseFiniteFloat(res_Merchant.PurchaseBalance)
+	if err != nil {
+		return nil, errors.New("Invalid purchaseBalance for merchant " + merchantId)
+	}
+	purchaseBalanceCents, err := toHundredths(floatPurchaseBalance)
+	if err != nil {
+		return nil, errors.New("Invalid purchaseBalance for merchant " + merchantId)
+	}
+	res_Merchant.PurchaseBalance = formatHundredths(purchaseBalanceCents + purchaseCents)
+	res_Merchant.MerchantCU_date = transactionDateTime
+
+	// Transaction1 debits the customer, Transaction2 credits the merchant
+	res_trans1 := Transaction{}
+	res_trans1.TransactionID = transactionId1
+	res_trans1.TransactionDateTime = transactionDateTime
+	res_trans1.TransactionType = "Purchase"
+	res_trans1.TransactionFrom = res.UserName
+	res_trans1.TransactionTo = res_Merchant.MerchantName
+	res_trans1.Credit = "0.00"
+	res_trans1.Debit = formatHundredths(purchaseCents)
+	res_trans1.CustomerID = customerId
+
+	res_trans2 := Transaction{}
+	res_trans2.TransactionID = transactionId2
+	res_trans2.TransactionDateTime = transactionDateTime
+	res_trans2.TransactionType = "Purchase"
+	res_trans2.TransactionFrom = res.UserName
+	res_trans2.TransactionTo = res_Merchant.MerchantName
+	res_trans2.Credit = formatHundredths(purchaseCents)
+	res_trans2.Debit = "0.00"
+	res_trans2.CustomerID = customerId
+
+	err = putCustomer(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	err = putMerchant(stub, res_Merchant)
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans1)
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans2)
+	if err != nil {
+		return nil, err
+	}
+
+	tosend := "{ \"customerID\" : \""+customerId+"\", \"pointsDebited\" : \""+formatHundredths(pointsToBeDebited)+"\", \"message\" : \"Customer details updated succcessfully\", \"code\" : \"200\"}"
+	err = stub.SetEvent("evtsender", []byte(tosend))
+	if err != nil {
+		return nil, err
+	}
+
 	fmt.Println("Customer details updated succcessfully")
 	return nil, nil
 }
@@ -1739,7 +1966,177 @@ func (t *ManageLPM) updateCustomerPurchaseSC(stub shim.ChaincodeStubInterface, a
 // Write - update customer during transfer into chaincode state - SmartContracts
 // ============================================================================================================================
 func (t *ManageLPM) updateCustomerTransferSC(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	fmt.Println("Customer details updated succcessfully")
+	var err error
+	fmt.Println("Updating Customer - transfer SC")
+	if len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 7")
+	}
+	customerId1 := args[0]
+	customerId2 := args[1]
+	merchantId := args[2]
+	pointsToTransfer := args[3]
+	transactionId1 := args[4]
+	transactionId2 := args[5]
+	transactionDateTime := args[6]
+
+	var hundredthsToTransfer int64
+	floatPointsToTransfer, err := parseFiniteFloat(pointsToTransfer)
+	if err == nil {
+		hundredthsToTransfer, err = toHundredths(floatPointsToTransfer)
+	}
+	if err != nil || hundredthsToTransfer <= 0 {
+		return nil, errors.New("Invalid points to transfer " + pointsToTransfer)
+	}
+	if customerId1 == customerId2 {
+		return nil, errors.New("Sender and receiver must be different customers")
+	}
+	if transactionId1 == transactionId2 {
+		return nil, errors.New("Transaction ids for the debit and credit legs must differ")
+	}
+	for _, transactionId := range []string{transactionId1, transactionId2} {
+		err = checkTransactionIdFree(stub, transactionId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	merchantAsBytes, err := stub.GetState(merchantId)
+	if err != nil {
+		return nil, errors.New("Failed to get Merchant merchantID")
+	}
+	res_Merchant := Merchant{}
+	json.Unmarshal(merchantAsBytes, &res_Merchant)
+	if res_Merchant.MerchantID != merchantId{
+		return nil, errors.New(merchantId + " Not Found.")
+	}
+
+	customer1AsBytes, err := stub.GetState(customerId1)
+	if err != nil {
+		return nil, errors.New("Failed to get Customer customerID")
+	}
+	res1 := Customer{}
+	json.Unmarshal(customer1AsBytes, &res1)
+	if res1.CustomerID != customerId1{
+		return nil, errors.New(customerId1 + " Not Found.")
+	}
+	customer2AsBytes, err := stub.GetState(customerId2)
+	if err != nil {
+		return nil, errors.New("Failed to get Customer customerID")
+	}
+	res2 := Customer{}
+	json.Unmarshal(customer2AsBytes, &res2)
+	if res2.CustomerID != customerId2{
+		return nil, errors.New(customerId2 + " Not Found.")
+	}
+
+	merchantIndexForCustomer1 := getMerchantIndexForCustomer(res1, merchantId)
+	if merchantIndexForCustomer1 < 0 {
+		return nil, errors.New(customerId1 + " is not associated with " + merchantId)
+	}
+	merchantIndexForCustomer2 := getMerchantIndexForCustomer(res2, merchantId)
+	if merchantIndexForCustomer2 < 0 {
+		fmt.Println("Associating " + customerId2 + " with " + merchantId + " for transfer")
+		addMerchantToCustomer(&res2, res_Merchant)
+		merchantIndexForCustomer2 = getMerchantIndexForCustomer(res2, merchantId)
+	}
+
+	pointsBefore1, err := getCustomerPointsAtIndex(res1, merchantIndexForCustomer1)
+	if err != nil {
+		return nil, err
+	}
+	pointsBefore2, err := getCustomerPointsAtIndex(res2, merchantIndexForCustomer2)
+	if err != nil {
+		return nil, err
+	}
+	if hundredthsToTransfer > pointsBefore1 {
+		return nil, errors.New("Insufficient points for " + customerId1 + " at " + merchantId)
+	}
+
+	// Calculation - worth of the transferred points at the merchant's exchange rate
+	floatExchangeRate, err := parseFiniteFloat(res_Merchant.ExchangeRate)
+	if err != nil || floatExchangeRate <= 0 {
+		return nil, errors.New("Invalid exchangeRate for merchant " + merchantId)
+	}
+	worthToTransfer, err := toHundredths(float64(hundredthsToTransfer) / 100 * floatExchangeRate)
+	if err != nil {
+		return nil, errors.New("Invalid worth for transfer from " + customerId1)
+	}
+
+	err = updateCustomerPointsAtIndex(&res1, merchantIndexForCustomer1, -hundredthsToTransfer, -worthToTransfer)
+	if err != nil {
+		return nil, err
+	}
+	err = updateCustomerPointsAtIndex(&res2, merchantIndexForCustomer2, hundredthsToTransfer, worthToTransfer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transaction1 debits the sender, Transaction2 credits the receiver
+	res_trans1 := Transaction{}
+	res_trans1.TransactionID = transactionId1
+	res_trans1.TransactionDateTime = transactionDateTime
+	res_trans1.TransactionType = "Transfer"
+	res_trans1.TransactionFrom = res1.UserName
+	res_trans1.TransactionTo = res2.UserName
+	res_trans1.Credit = "0.00"
+	res_trans1.Debit = formatHundredths(hundredthsToTransfer)
+	res_trans1.CustomerID = customerId1
+
+	res_trans2 := Transaction{}
+	res_trans2.TransactionID = transactionId2
+	res_trans2.TransactionDateTime = transactionDateTime
+	res_trans2.TransactionType = "Transfer"
+	res_trans2.TransactionFrom = res1.UserName
+	res_trans2.TransactionTo = res2.UserName
+	res_trans2.Credit = formatHundredths(hundredthsToTransfer)
+	res_trans2.Debit = "0.00"
+	res_trans2.CustomerID = customerId2
+
+	// Points must be conserved - the debit leg must equal the credit leg and each must match what the holdings moved by
+	totalDebit, err := parseHundredths(res_trans1.Debit)
+	if err != nil {
+		return nil, err
+	}
+	totalCredit, err := parseHundredths(res_trans2.Credit)
+	if err != nil {
+		return nil, err
+	}
+	pointsAfter1, err := getCustomerPointsAtIndex(res1, merchantIndexForCustomer1)
+	if err != nil {
+		return nil, err
+	}
+	pointsAfter2, err := getCustomerPointsAtIndex(res2, merchantIndexForCustomer2)
+	if err != nil {
+		return nil, err
+	}
+	if totalDebit != totalCredit || pointsBefore1 - pointsAfter1 != totalDebit || pointsAfter2 - pointsBefore2 != totalCredit {
+		return nil, errors.New("Points not conserved in transfer from " + customerId1 + " to " + customerId2)
+	}
+
+	err = putCustomer(stub, res1)
+	if err != nil {
+		return nil, err
+	}
+	err = putCustomer(stub, res2)
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans1)
+	if err != nil {
+		return nil, err
+	}
+	err = putTransaction(stub, res_trans2)
+	if err != nil {
+		return nil, err
+	}
+
+	tosend := "{ \"customerID\" : \""+customerId1+"\", \"message\" : \"Customer details updated succcessfully\", \"code\" : \"200\"}"
+	err = stub.SetEvent("evtsender", []byte(tosend))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Customer details updated succcessfully for transfer")
 	return nil, nil
 }
 // ============================================================================================================================
@@ -1785,7 +2182,7 @@ func (t *ManageLPM) deleteCustomer(stub shim.ChaincodeStubInterface, args []stri
 			fmt.Println("found Customer with matching customerId")
 			customerIndex = append(customerIndex[:i], customerIndex[i+1:]...)			//remove it
 			for x:= range customerIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + customerIndex[x])
+				fmt.Println(strconv.Itoa(x) + " - " + customerIndex[x])
 			}
 			break
 		}
@@ -2245,7 +2642,7 @@ func (t *ManageLPM) deleteMerchant(stub shim.ChaincodeStubInterface, args []stri
 			fmt.Println("found Merchant with matching merchantId")
 			merchantIndex = append(merchantIndex[:i], merchantIndex[i+1:]...)			//remove it
 			for x:= range merchantIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + merchantIndex[x])
+				fmt.Println(strconv.Itoa(x) + " - " + merchantIndex[x])
 			}
 			break
 		}
@@ -2510,3 +2907,191 @@ func (t *ManageLPM) associateCustomer(stub shim.ChaincodeStubInterface, args []s
 	fmt.Println("end associateCustomer")
 	return nil, nil
 }
+
+// ============================================================================================================================
+// getMerchantIndexForCustomer - position of a merchant in the customer's comma separated merchant lists, -1 if not associated -- Internal Function
+// ============================================================================================================================
+func getMerchantIndexForCustomer(res Customer, merchantId string) int {
+	stringSliceMerchantIDs := strings.Split(res.MerchantIDs, ",")
+	for i,val := range stringSliceMerchantIDs{
+		if val == merchantId{
+			return i
+		}
+	}
+	return -1
+}
+// ============================================================================================================================
+// addMerchantToCustomer - append a merchant with no points to the customer's comma separated merchant lists -- Internal Function
+// ============================================================================================================================
+func addMerchantToCustomer(res *Customer, res_Merchant Merchant) {
+	if res.MerchantIDs == "" {
+		res.MerchantIDs = res_Merchant.MerchantID
+		res.MerchantNames = res_Merchant.MerchantName
+		res.MerchantColors = res_Merchant.IndustryColor
+		res.MerchantCurrencies = res_Merchant.MerchantCurrency
+		res.MerchantsPointsCount = "0.00"
+		res.MerchantsPointsWorth = "0.00"
+		return
+	}
+	res.MerchantIDs = res.MerchantIDs + "," + res_Merchant.MerchantID
+	res.MerchantNames = res.MerchantNames + "," + res_Merchant.MerchantName
+	res.MerchantColors = res.MerchantColors + "," + res_Merchant.IndustryColor
+	res.MerchantCurrencies = res.MerchantCurrencies + "," + res_Merchant.MerchantCurrency
+	res.MerchantsPointsCount = res.MerchantsPointsCount + ",0.00"
+	res.MerchantsPointsWorth = res.MerchantsPointsWorth + ",0.00"
+}
+// ============================================================================================================================
+// parseFiniteFloat - strconv.ParseFloat without the NaN and Inf values it accepts -- Internal Function
+// ============================================================================================================================
+func parseFiniteFloat(value string) (float64, error) {
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+		return 0, errors.New(value + " is not a finite number")
+	}
+	return floatValue, nil
+}
+// ============================================================================================================================
+// toHundredths - round a points or money value to whole hundredths (cents) so balances compare exactly -- Internal Function
+// ============================================================================================================================
+func toHundredths(value float64) (int64, error) {
+	hundredths := math.Round(value * 100)
+	if math.IsNaN(hundredths) || hundredths > maxHundredths || hundredths < -maxHundredths {
+		return 0, errors.New(strconv.FormatFloat(value, 'f', -1, 64) + " is out of range")
+	}
+	return int64(hundredths), nil
+}
+// ============================================================================================================================
+// formatHundredths - hundredths back to the 2 decimal string stored on the ledger -- Internal Function
+// ============================================================================================================================
+func formatHundredths(hundredths int64) string {
+	sign := ""
+	if hundredths < 0 {
+		sign = "-"
+		hundredths = -hundredths
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, hundredths/100, hundredths%100)
+}
+// ============================================================================================================================
+// parseHundredths - a stored 2 decimal string as hundredths -- Internal Function
+// ============================================================================================================================
+func parseHundredths(value string) (int64, error) {
+	floatValue, err := parseFiniteFloat(value)
+	if err != nil {
+		return 0, err
+	}
+	return toHundredths(floatValue)
+}
+// ============================================================================================================================
+// getCustomerPointsAtIndex - points the customer holds at one merchant slot, in hundredths -- Internal Function
+// ============================================================================================================================
+func getCustomerPointsAtIndex(res Customer, index int) (int64, error) {
+	stringSlicePointsCount := strings.Split(res.MerchantsPointsCount, ",")
+	if index < 0 || index >= len(stringSlicePointsCount) {
+		return 0, errors.New("Invalid merchant index for customer " + res.CustomerID)
+	}
+	pointsCount, err := parseHundredths(stringSlicePointsCount[index])
+	if err != nil {
+		return 0, errors.New("Invalid merchantsPointsCount for customer " + res.CustomerID)
+	}
+	return pointsCount, nil
+}
+// ============================================================================================================================
+// updateCustomerPointsAtIndex - add points and worth in hundredths (negative to debit) to one merchant slot and to the wallet worth -- Internal Function
+// ============================================================================================================================
+func updateCustomerPointsAtIndex(res *Customer, index int, pointsDelta int64, worthDelta int64) error {
+	stringSliceMerchantIDs := strings.Split(res.MerchantIDs, ",")
+	stringSlicePointsCount := strings.Split(res.MerchantsPointsCount, ",")
+	stringSlicePointsWorth := strings.Split(res.MerchantsPointsWorth, ",")
+	if len(stringSlicePointsCount) != len(stringSliceMerchantIDs) || len(stringSlicePointsWorth) != len(stringSliceMerchantIDs) {
+		return errors.New("Merchant lists out of step for customer " + res.CustomerID)
+	}
+	if index < 0 || index >= len(stringSliceMerchantIDs) {
+		return errors.New("Invalid merchant index for customer " + res.CustomerID)
+	}
+	pointsCount, err := parseHundredths(stringSlicePointsCount[index])
+	if err != nil {
+		return errors.New("Invalid merchantsPointsCount for customer " + res.CustomerID)
+	}
+	pointsWorth, err := parseHundredths(stringSlicePointsWorth[index])
+	if err != nil {
+		return errors.New("Invalid merchantsPointsWorth for customer " + res.CustomerID)
+	}
+	walletWorth, err := parseHundredths(res.WalletWorth)
+	if err != nil {
+		return errors.New("Invalid walletWorth for customer " + res.CustomerID)
+	}
+	if pointsCount + pointsDelta < 0 {
+		return errors.New("Insufficient points for customer " + res.CustomerID)
+	}
+	stringSlicePointsCount[index] = formatHundredths(pointsCount + pointsDelta)
+	stringSlicePointsWorth[index] = formatHundredths(pointsWorth + worthDelta)
+	res.MerchantsPointsCount = strings.Join(stringSlicePointsCount, ",")
+	res.MerchantsPointsWorth = strings.Join(stringSlicePointsWorth, ",")
+	res.WalletWorth = formatHundredths(walletWorth + worthDelta)
+	return nil
+}
+// ============================================================================================================================
+// putCustomer - store a Customer with customerId as key -- Internal Function
+// ============================================================================================================================
+func putCustomer(stub shim.ChaincodeStubInterface, res Customer) error {
+	customerAsBytes, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Println("customer_json in putCustomer::" + string(customerAsBytes))
+	return stub.PutState(res.CustomerID, customerAsBytes)
+}
+// ============================================================================================================================
+// putTransaction - store a Transaction with transactionId as key and add it to the Transaction index -- Internal Function
+// ============================================================================================================================
+func putTransaction(stub shim.ChaincodeStubInterface, res_trans Transaction) error {
+	transactionAsBytes, err := json.Marshal(res_trans)
+	if err != nil {
+		return err
+	}
+	fmt.Println("transaction_json in putTransaction::" + string(transactionAsBytes))
+	err = stub.PutState(res_trans.TransactionID, transactionAsBytes)
+	if err != nil {
+		return err
+	}
+	transactionIndexAsBytes, err := stub.GetState(TransactionIndexStr)
+	if err != nil {
+		return errors.New("Failed to get Transaction index")
+	}
+	var transactionIndex []string
+	json.Unmarshal(transactionIndexAsBytes, &transactionIndex)						//un stringify it aka JSON.parse()
+	transactionIndex = append(transactionIndex, res_trans.TransactionID)			//add Transaction transactionId to index list
+	jsonAsBytes, _ := json.Marshal(transactionIndex)
+	return stub.PutState(TransactionIndexStr, jsonAsBytes)
+}
+// ============================================================================================================================
+// checkTransactionIdFree - fail when a client transactionId is empty or already used as a key, Transactions share the key
+// space with Customers and Merchants so writing one under a used key would overwrite that record -- Internal Function
+// ============================================================================================================================
+func checkTransactionIdFree(stub shim.ChaincodeStubInterface, transactionId string) error {
+	if transactionId == "" {
+		return errors.New("Empty transaction id")
+	}
+	valueAsBytes, err := stub.GetState(transactionId)
+	if err != nil {
+		return errors.New("Failed to get Transaction transactionId")
+	}
+	if len(valueAsBytes) > 0 {
+		return errors.New("Transaction id " + transactionId + " already exists")
+	}
+	return nil
+}
+// ============================================================================================================================
+// putMerchant - store a Merchant with merchantId as key -- Internal Function
+// ============================================================================================================================
+func putMerchant(stub shim.ChaincodeStubInterface, res_Merchant Merchant) error {
+	merchantAsBytes, err := json.Marshal(res_Merchant)
+	if err != nil {
+		return err
+	}
+	fmt.Println("merchant_json in putMerchant::" + string(merchantAsBytes))
+	return stub.PutState(res_Merchant.MerchantID, merchantAsBytes)
+}
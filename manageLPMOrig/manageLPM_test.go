@@ -0,0 +1,217 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ============================================================================================================================
+// testStub - a MockStub that numbers its invocations
+// ============================================================================================================================
+type testStub struct {
+	*shim.MockStub
+	t *testing.T
+	txn int
+}
+
+// ============================================================================================================================
+// testCase - one invocation of a table driven test, whether it must be rejected and the state it must leave
+// ============================================================================================================================
+type testCase struct {
+	name string
+	function string
+	args []string
+	fails bool
+	want string
+}
+
+// ============================================================================================================================
+// newTestStub - an initialized ledger
+// ============================================================================================================================
+func newTestStub(t *testing.T) *testStub {
+	s := &testStub{MockStub: shim.NewMockStub("manageLPM", new(ManageLPM)), t: t}
+	_, err := s.MockInit("init", "init", []string{"x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+// ============================================================================================================================
+// invoke - invoke function in a transaction of its own
+// ============================================================================================================================
+func (s *testStub) invoke(function string, args ...string) error {
+	s.txn++
+	_, err := s.MockInvoke(fmt.Sprintf("tx%04d", s.txn), function, args)
+	return err
+}
+// ============================================================================================================================
+// mustInvoke - invoke function and fail the test when it is rejected
+// ============================================================================================================================
+func (s *testStub) mustInvoke(function string, args ...string) {
+	s.t.Helper()
+	err := s.invoke(function, args...)
+	if err != nil {
+		s.t.Fatalf("%s failed: %s", function, err)
+	}
+}
+// ============================================================================================================================
+// run - run each case in order against the same ledger, after each one state must describe the ledger as the case wants
+// ============================================================================================================================
+func (s *testStub) run(cases []testCase, state func() string) {
+	for _, tt := range cases {
+		s.t.Run(tt.name, func(t *testing.T) {
+			err := s.invoke(tt.function, tt.args...)
+			if tt.fails != (err != nil) {
+				t.Fatalf("expected failure %t, got %v", tt.fails, err)
+			}
+			if got := state(); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+// ============================================================================================================================
+// record - the JSON stored under key, decoded into v
+// ============================================================================================================================
+func (s *testStub) record(key string, v interface{}) {
+	s.t.Helper()
+	valueAsBytes, err := s.GetState(key)
+	if err != nil || len(valueAsBytes) == 0 {
+		s.t.Fatalf("nothing stored for %s", key)
+	}
+	err = json.Unmarshal(valueAsBytes, v)
+	if err != nil {
+		s.t.Fatalf("malformed record %s: %s", key, err)
+	}
+}
+// ============================================================================================================================
+// wallet - a customer's wallet worth and merchant columns as one line
+// ============================================================================================================================
+func (s *testStub) wallet(customerId string) string {
+	res := Customer{}
+	s.record(customerId, &res)
+	return res.WalletWorth + " " + res.MerchantIDs + " " + res.MerchantsPointsCount + " " + res.MerchantsPointsWorth
+}
+// ============================================================================================================================
+// setupMerchant - create a USD merchant with no purchase balance
+// ============================================================================================================================
+func (s *testStub) setupMerchant(merchantId string, pointsPerDollarSpent string, exchangeRate string) {
+	s.t.Helper()
+	s.mustInvoke("createMerchant", merchantId, merchantId + "user", "Merchant " + merchantId, "food", "red", pointsPerDollarSpent, exchangeRate, "0.00", "USD", "2024-03-01")
+}
+// ============================================================================================================================
+// setupCustomer - create a customer of merchantId holding points worth worth
+// ============================================================================================================================
+func (s *testStub) setupCustomer(customerId string, merchantId string, points string, worth string) {
+	s.t.Helper()
+	s.mustInvoke("createCustomer", customerId, customerId + "user", "Customer " + customerId, worth, merchantId, "Merchant " + merchantId, "red", "USD", points, worth, "onboard-" + customerId, "2024-03-01", "CustomerOnBoarding")
+}
+
+func TestAccumulationSCComputesPoints(t *testing.T) {
+	s := newTestStub(t)
+	s.setupMerchant("m1", "2", "0.01")
+	s.setupMerchant("m2", "1", "0.01")
+	s.setupCustomer("c1", "m1", "10.00", "0.10")
+	// 12.50 spent earns 25.00 points worth 0.25 at 0.01 each
+	accrued := "0.35 m1 35.00 0.35"
+	s.run([]testCase{
+		{"two points per dollar", "updateCustomerAccumulationSC", []string{"c1", "m1", "12.50", "t1", "2024-03-02"}, false, accrued},
+		{"a reused transaction id", "updateCustomerAccumulationSC", []string{"c1", "m1", "1.00", "t1", "2024-03-02"}, true, accrued},
+		{"a customer id as transaction id", "updateCustomerAccumulationSC", []string{"c1", "m1", "1.00", "m1", "2024-03-02"}, true, accrued},
+		{"an unknown merchant", "updateCustomerAccumulationSC", []string{"c1", "m9", "1.00", "t2", "2024-03-02"}, true, accrued},
+		{"a merchant it is not associated with", "updateCustomerAccumulationSC", []string{"c1", "m2", "1.00", "t3", "2024-03-02"}, true, accrued},
+		{"an amount that is not a number", "updateCustomerAccumulationSC", []string{"c1", "m1", "NaN", "t4", "2024-03-02"}, true, accrued},
+		{"a negative amount", "updateCustomerAccumulationSC", []string{"c1", "m1", "-1.00", "t5", "2024-03-02"}, true, accrued},
+	}, func() string {
+		return s.wallet("c1")
+	})
+
+	res_trans := Transaction{}
+	s.record("t1", &res_trans)
+	if res_trans.TransactionType != "Accumulation" || res_trans.Credit != "0.25" || res_trans.CustomerID != "c1" {
+		t.Fatalf("unexpected transaction %+v", res_trans)
+	}
+	res_Merchant := Merchant{}
+	s.record("m1", &res_Merchant)
+	if res_Merchant.MerchantID != "m1" {
+		t.Fatalf("m1 was overwritten with %+v", res_Merchant)
+	}
+}
+
+func TestPurchaseSCRedeemsPoints(t *testing.T) {
+	s := newTestStub(t)
+	s.setupMerchant("m1", "1", "0.01")
+	s.setupCustomer("c1", "m1", "100.00", "1.00")
+	// 0.40 at 0.01 a point takes 40.00 points and goes to m1's purchase balance
+	paid := "0.60 m1 60.00 0.60 / 0.40"
+	s.run([]testCase{
+		{"within the points held", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.40", "p1", "p2", "2024-03-02"}, false, paid},
+		{"an overdraft", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.61", "p3", "p4", "2024-03-02"}, true, paid},
+		{"a reused transaction id", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.10", "p5", "p2", "2024-03-02"}, true, paid},
+		{"one id for both legs", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.10", "p6", "p6", "2024-03-02"}, true, paid},
+		{"an unknown merchant", "updateCustomerPurchaseSC", []string{"c1", "m9", "0.10", "p7", "p8", "2024-03-02"}, true, paid},
+		{"an unknown customer", "updateCustomerPurchaseSC", []string{"c9", "m1", "0.10", "p9", "p10", "2024-03-02"}, true, paid},
+		{"every point held", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.60", "p11", "p12", "2024-03-02"}, false, "0.00 m1 0.00 0.00 / 1.00"},
+		{"with no points left", "updateCustomerPurchaseSC", []string{"c1", "m1", "0.01", "p13", "p14", "2024-03-02"}, true, "0.00 m1 0.00 0.00 / 1.00"},
+	}, func() string {
+		res_Merchant := Merchant{}
+		s.record("m1", &res_Merchant)
+		return s.wallet("c1") + " / " + res_Merchant.PurchaseBalance
+	})
+
+	for _, transactionId := range []string{"p3", "p4", "p13", "p14"} {
+		if valueAsBytes, _ := s.GetState(transactionId); len(valueAsBytes) > 0 {
+			t.Fatalf("a rejected purchase wrote %s", transactionId)
+		}
+	}
+}
+
+func TestTransferSCMovesPoints(t *testing.T) {
+	s := newTestStub(t)
+	s.setupMerchant("m1", "1", "0.01")
+	s.setupMerchant("m2", "1", "0.01")
+	s.setupCustomer("c1", "m1", "100.00", "1.00")
+	s.setupCustomer("c2", "m2", "0.00", "0.00")
+	// c2 is associated with m1 by the first transfer, the 100.00 points with m1 stay 100.00 between them
+	moved := "0.70 m1 70.00 0.70 | 0.30 m2,m1 0.00,30.00 0.00,0.30"
+	s.run([]testCase{
+		{"to a customer of another merchant", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "30.00", "x1", "x2", "2024-03-02"}, false, moved},
+		{"more than the sender holds", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "70.01", "x3", "x4", "2024-03-02"}, true, moved},
+		{"from a merchant the sender is not associated with", "updateCustomerTransferSC", []string{"c1", "c2", "m2", "1.00", "x5", "x6", "2024-03-02"}, true, moved},
+		{"an unknown merchant", "updateCustomerTransferSC", []string{"c1", "c2", "m9", "1.00", "x7", "x8", "2024-03-02"}, true, moved},
+		{"to itself", "updateCustomerTransferSC", []string{"c1", "c1", "m1", "1.00", "x9", "x10", "2024-03-02"}, true, moved},
+		{"a reused transaction id", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "1.00", "x1", "x11", "2024-03-02"}, true, moved},
+		{"a merchant id as transaction id", "updateCustomerTransferSC", []string{"c1", "c2", "m1", "1.00", "x12", "m2", "2024-03-02"}, true, moved},
+		{"back again", "updateCustomerTransferSC", []string{"c2", "c1", "m1", "30.00", "x13", "x14", "2024-03-02"}, false, "1.00 m1 100.00 1.00 | 0.00 m2,m1 0.00,0.00 0.00,0.00"},
+	}, func() string {
+		return s.wallet("c1") + " | " + s.wallet("c2")
+	})
+
+	debit := Transaction{}
+	credit := Transaction{}
+	s.record("x1", &debit)
+	s.record("x2", &credit)
+	if debit.Debit != "30.00" || debit.CustomerID != "c1" || credit.Credit != "30.00" || credit.CustomerID != "c2" {
+		t.Fatalf("unexpected legs %+v %+v", debit, credit)
+	}
+}